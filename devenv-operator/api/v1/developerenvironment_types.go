@@ -17,6 +17,8 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -24,50 +26,707 @@ import (
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 // DeveloperEnvironmentSpec defines the desired state of DeveloperEnvironment
+// +kubebuilder:validation:XValidation:rule="has(self.language) || (has(self.languages) && size(self.languages) > 0)",message="language or languages must be set"
 type DeveloperEnvironmentSpec struct {
-	// Language and framework configuration
+	// Language and framework configuration. Shorthand for a single entry of Languages.
 	// +kubebuilder:validation:Enum=nodejs;go;python;java;rust;
-	Language string `json:"language"`
-	Version  string `json:"version"`
+	// +optional
+	Language string `json:"language,omitempty"`
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Languages lists every toolchain to provision, for polyglot environments.
+	// Takes precedence over Language and Version.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Languages []LanguageSpec `json:"languages,omitempty"`
 
 	// Development tools and IDE
 	IDE IDEConfig `json:"ide,omitempty"`
 
-	// Database configuration
-	Database DatabaseSpec `json:"database,omitempty"`
+	// Database provisions a database next to the IDE. Shorthand for a single
+	// entry of Services named database. Removing it from an existing
+	// environment deletes the database together with its volume.
+	// +optional
+	Database *DatabaseSpec `json:"database,omitempty"`
+
+	// Services lists the backing services of the environment, such as
+	// databases and message brokers. Takes precedence over Database. Removing
+	// a service deletes it together with its volume.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Services []ServiceSpec `json:"services,omitempty"`
+
+	// RestoreFrom names a DeveloperEnvironmentSnapshot next to the environment
+	// to create its volumes from. The workspace is restored, and so is every
+	// backing service of the same name and type as in the snapshot. It can
+	// only be set when the environment is created.
+	// +optional
+	RestoreFrom string `json:"restoreFrom,omitempty"`
+
+	// Owner is the developer the environment belongs to, defaulted to the
+	// user who creates it. Volumes retained on deletion are adopted by the
+	// next environment of the same owner.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+
+	// DeletionPolicy decides what happens to the workspace and backing
+	// service volumes when the environment is deleted
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// DeletionSnapshot configures the snapshot taken by the Snapshot deletion policy
+	// +optional
+	DeletionSnapshot *SnapshotOptions `json:"deletionSnapshot,omitempty"`
 
 	// Additional dependencies
 	Dependencies []DependencySpec `json:"dependencies,omitempty"`
+
+	// Workspace configures the volume holding /config/workspace
+	// +optional
+	Workspace *WorkspaceSpec `json:"workspace,omitempty"`
+
+	// NamespaceMode selects where the environment's resources are created.
+	// Defaults to the operator-wide mode. Changing it on an existing environment
+	// migrates the environment, including its volumes, to the new namespace.
+	// +optional
+	NamespaceMode NamespaceMode `json:"namespaceMode,omitempty"`
+
+	// Quota sizes the ResourceQuota and LimitRange of a dedicated environment namespace.
+	// Unset values fall back to the operator defaults.
+	// +optional
+	Quota *QuotaSpec `json:"quota,omitempty"`
+
+	// TLS selects the certificate the IDE is served with. Defaults to the
+	// operator-wide configuration.
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// Suspended scales the IDE and database to zero while keeping their volumes.
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+
+	// Schedule limits the environment to working hours, suspending it outside of them
+	// +optional
+	Schedule *ScheduleSpec `json:"schedule,omitempty"`
+
+	// IdleTimeout suspends the environment once the IDE has seen no activity for this long
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+
+	// ProvisioningTimeout fails an environment that is not ready this long
+	// after it was created, resumed or its spec last changed, and never does
+	// when zero. Defaults to the operator-wide timeout.
+	// +optional
+	ProvisioningTimeout *metav1.Duration `json:"provisioningTimeout,omitempty"`
+
+	// Repositories are cloned into the workspace before the IDE starts.
+	// Repositories already present on the workspace volume are left untouched.
+	// +listType=map
+	// +listMapKey=path
+	// +optional
+	Repositories []RepositorySpec `json:"repositories,omitempty"`
+
+	// Git sets the identity commits made in the IDE are authored with
+	// +optional
+	Git *GitIdentity `json:"git,omitempty"`
+}
+
+// RepositorySpec describes a git repository to clone into the workspace
+// +kubebuilder:validation:XValidation:rule="!self.path.matches('(^|/)[.][.]?(/|$)')",message="path must not contain . or .. segments"
+type RepositorySpec struct {
+	// URL of the repository, over HTTPS or SSH
+	// +kubebuilder:validation:Pattern=`^(https?://|ssh://|[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:)`
+	URL string `json:"url"`
+	// Ref to check out: a branch, tag or commit. The default branch of the remote when empty.
+	// +optional
+	Ref string `json:"ref,omitempty"`
+	// Path to clone into, relative to /config/workspace
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`
+	Path string `json:"path"`
+	// CredentialsSecret names a Secret in the namespace of the DeveloperEnvironment holding
+	// an ssh-privatekey, and optionally known_hosts, for SSH URLs, or a token, and optionally
+	// a username, for HTTPS URLs
+	// +optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+}
+
+// GitIdentity is the author and committer of commits made in the IDE
+type GitIdentity struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// ScheduleSpec defines the working hours of an environment as a pair of cron expressions
+type ScheduleSpec struct {
+	// Wake is the cron expression of when working hours start, e.g. "0 8 * * 1-5"
+	Wake string `json:"wake"`
+	// Suspend is the cron expression of when working hours end, e.g. "0 19 * * 1-5"
+	Suspend string `json:"suspend"`
+	// TimeZone is the IANA time zone the expressions are evaluated in. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// QuotaSpec sizes the ResourceQuota and LimitRange of an environment namespace
+type QuotaSpec struct {
+	// Hard limits of the namespace ResourceQuota, e.g. requests.cpu, limits.memory or persistentvolumeclaims
+	Hard corev1.ResourceList `json:"hard,omitempty"`
+	// DefaultRequest is applied to containers that do not request resources themselves
+	DefaultRequest corev1.ResourceList `json:"defaultRequest,omitempty"`
+	// Default is applied as the limit of containers that do not set limits themselves
+	Default corev1.ResourceList `json:"default,omitempty"`
 }
 
+// NamespaceMode describes the tenancy model of an environment
+// +kubebuilder:validation:Enum=Shared;Dedicated
+type NamespaceMode string
+
+const (
+	// NamespaceModeShared places the environment in the namespace of the DeveloperEnvironment
+	NamespaceModeShared NamespaceMode = "Shared"
+	// NamespaceModeDedicated places the environment in its own devenv-<name> namespace
+	NamespaceModeDedicated NamespaceMode = "Dedicated"
+)
+
+// TLSMode selects where the certificate of the IDE comes from
+// +kubebuilder:validation:Enum=SelfSigned;Issuer;ClusterIssuer;Secret;None
+type TLSMode string
+
+const (
+	// TLSModeSelfSigned issues a self-signed certificate per environment,
+	// which browsers do not trust
+	TLSModeSelfSigned TLSMode = "SelfSigned"
+	// TLSModeIssuer issues the certificate from an existing cert-manager
+	// Issuer in the namespace of the environment's resources
+	TLSModeIssuer TLSMode = "Issuer"
+	// TLSModeClusterIssuer issues the certificate from an existing
+	// cert-manager ClusterIssuer, such as an ACME one
+	TLSModeClusterIssuer TLSMode = "ClusterIssuer"
+	// TLSModeSecret serves the certificate of an existing Secret, usually a
+	// wildcard certificate shared by every environment
+	TLSModeSecret TLSMode = "Secret"
+	// TLSModeNone serves the IDE over plain HTTP
+	TLSModeNone TLSMode = "None"
+)
+
+// TLSSpec selects the certificate the IDE is served with
+// +kubebuilder:validation:XValidation:rule="(self.mode == 'Issuer' || self.mode == 'ClusterIssuer') == has(self.issuerName)",message="issuerName must be set for the Issuer and ClusterIssuer modes only"
+// +kubebuilder:validation:XValidation:rule="(self.mode == 'Secret') == has(self.secretName)",message="secretName must be set for the Secret mode only"
+type TLSSpec struct {
+	Mode TLSMode `json:"mode"`
+	// IssuerName names the Issuer or ClusterIssuer of the certificate
+	// +optional
+	IssuerName string `json:"issuerName,omitempty"`
+	// SecretName names a kubernetes.io/tls Secret in the namespace of the
+	// DeveloperEnvironment whose certificate covers the IDE host, such as a
+	// wildcard certificate for *.<domain>
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// LanguageSpec selects a language toolchain
+type LanguageSpec struct {
+	// +kubebuilder:validation:Enum=nodejs;go;python;java;rust
+	Name string `json:"name"`
+	// Version of the toolchain. The install script picks a default when empty.
+	// +optional
+	Version string `json:"version,omitempty"`
+}
+
+// EffectiveLanguages returns the toolchains of the environment, reading the
+// Language and Version shorthand when Languages is empty.
+func (s *DeveloperEnvironmentSpec) EffectiveLanguages() []LanguageSpec {
+	if len(s.Languages) > 0 {
+		return s.Languages
+	}
+	if s.Language == "" {
+		return nil
+	}
+	return []LanguageSpec{{Name: s.Language, Version: s.Version}}
+}
+
+// IDEType selects the IDE an environment runs
+// +kubebuilder:validation:Enum=vscode;jupyterlab
+type IDEType string
+
+const (
+	// IDETypeVSCode runs code-server
+	IDETypeVSCode IDEType = "vscode"
+	// IDETypeJupyterLab runs JupyterLab
+	IDETypeJupyterLab IDEType = "jupyterlab"
+)
+
 // IDEConfig defines IDE and development tool settings
 type IDEConfig struct {
-	Type           string            `json:"type"`
-	Extensions     []string          `json:"extensions,omitempty"`
-	Settings       map[string]string `json:"settings,omitempty"`
-	PasswordSecret string            `json:"passwordSecret,omitempty"`
+	Type IDEType `json:"type"`
+	// Extensions to install before the IDE starts: VS Code extension IDs of the form
+	// publisher.name, or pip packages of JupyterLab extensions.
+	// VS Code extensions and pip packages can be pinned to a version, as in publisher.name@1.2.3.
+	// +optional
+	Extensions []string `json:"extensions,omitempty"`
+	// VSIX provides .vsix files of VS Code extensions, installed instead of
+	// downloading the extensions, for clusters without access to a registry
+	// +optional
+	VSIX *VSIXSource `json:"vsix,omitempty"`
+	// ExtensionRegistry is the URL of the Open VSX registry code-server
+	// installs extensions from, such as https://open-vsx.org. Defaults to the
+	// registry of the operator.
+	// +kubebuilder:validation:Pattern=`^https?://[^\s]+$`
+	// +optional
+	ExtensionRegistry string `json:"extensionRegistry,omitempty"`
+	// Settings of the IDE: VS Code settings, or JupyterLab settings named <plugin>.<setting>.
+	// Values are read as JSON, and as strings where they are not valid JSON.
+	// +optional
+	Settings map[string]string `json:"settings,omitempty"`
+	// PasswordSecret is the password of the IDE in plain text.
+	// Deprecated: use PasswordSecretRef. The operator moves the password into a Secret and clears it.
+	// +optional
+	PasswordSecret string `json:"passwordSecret,omitempty"`
+	// PasswordSecretRef selects the password of the IDE in a Secret next to the DeveloperEnvironment.
+	// A random password is generated into the Secret <name>-vscode-password when unset.
+	// +optional
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+	// Resources of the IDE container. Unset requests and limits fall back to the operator defaults.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// VSIXSource holds .vsix files, named after the extension and its version
+// as in publisher.name-1.2.3.vsix. An extension is installed from its file
+// when there is one, picking the latest version unless it is pinned.
+// +kubebuilder:validation:XValidation:rule="has(self.configMap) != has(self.s3)",message="exactly one of configMap and s3 must be set"
+type VSIXSource struct {
+	// ConfigMap names a ConfigMap next to the DeveloperEnvironment whose
+	// binaryData keys are .vsix files
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+	// S3 holds .vsix files in an S3-compatible bucket such as MinIO, under
+	// the key as a prefix
+	// +optional
+	S3 *S3Location `json:"s3,omitempty"`
+}
+
+// SecretKeyReference selects a key of a Secret in the namespace of the DeveloperEnvironment
+type SecretKeyReference struct {
+	Name string `json:"name"`
+	// +kubebuilder:default=password
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// WorkspaceSpec configures the developer workspace
+type WorkspaceSpec struct {
+	// Storage of the workspace volume
+	// +optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+}
+
+// StorageSpec sizes a persistent volume of the environment
+type StorageSpec struct {
+	// Size of the volume. Volumes can be grown in place if their StorageClass allows expansion, but never shrunk.
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+	// StorageClassName of the volume. It cannot be changed once the volume exists.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// DefaultServiceName is the name of the service spec.database stands for
+const DefaultServiceName = "database"
+
+// ServiceSpec is a named backing service of the environment
+// +kubebuilder:validation:XValidation:rule="self.name != 'vscode-server'",message="vscode-server is reserved for the IDE"
+type ServiceSpec struct {
+	// Name of the service. Its objects are named <environment>-<name>.
+	// +kubebuilder:validation:Pattern=`^[a-z]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=30
+	Name         string `json:"name"`
+	DatabaseSpec `json:",inline"`
+}
+
+// EffectiveServices returns the backing services of the environment, reading
+// the Database shorthand as a service named database when Services is empty.
+func (s *DeveloperEnvironmentSpec) EffectiveServices() []ServiceSpec {
+	if len(s.Services) > 0 {
+		return s.Services
+	}
+	if s.Database == nil {
+		return nil
+	}
+	return []ServiceSpec{{Name: DefaultServiceName, DatabaseSpec: *s.Database}}
 }
 
 // DatabaseSpec defines database configuration
 type DatabaseSpec struct {
-	// +kubebuilder:validation:Enum=postgres;redis;
+	// Type of the service. kafka runs a single KRaft broker.
+	// +kubebuilder:validation:Enum=postgres;redis;mysql;mariadb;mongodb;rabbitmq;kafka
 	Type string `json:"type"`
 	// +kubebuilder:default=latest
 	Version string `json:"version"`
+	// Resources of the database container. Unset requests and limits fall back to the operator defaults.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Storage of the database volume
+	// +optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+	// Seed loads data into the database once it is ready. Supported by
+	// postgres, mysql, mariadb and mongodb.
+	// +optional
+	Seed *SeedSpec `json:"seed,omitempty"`
+}
+
+// SeedSpec is the source of the data a database is seeded with. Seeding runs
+// again whenever the source changes, so the scripts should be idempotent.
+// +kubebuilder:validation:XValidation:rule="has(self.configMap) != has(self.s3)",message="exactly one of configMap and s3 must be set"
+type SeedSpec struct {
+	// ConfigMap names a ConfigMap next to the DeveloperEnvironment whose keys
+	// are init scripts, run in lexical order: .sql and .sql.gz files, .js
+	// files for mongodb, and .sh scripts.
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+	// S3 is a dump in an S3-compatible bucket such as MinIO: .sql, .sql.gz or
+	// a pg_restore .dump for postgres, .sql or .sql.gz for mysql and mariadb,
+	// and a mongodump .archive or .archive.gz for mongodb.
+	// +optional
+	S3 *S3Location `json:"s3,omitempty"`
+}
+
+// S3Location is an object in an S3-compatible bucket
+type S3Location struct {
+	// Endpoint of the S3 API, such as http://minio.minio.svc:9000
+	// +kubebuilder:validation:Pattern=`^https?://[^\s]+$`
+	Endpoint string `json:"endpoint"`
+	// +kubebuilder:validation:MinLength=3
+	Bucket string `json:"bucket"`
+	// Key of the object
+	// +kubebuilder:validation:Pattern=`^[^\s]+$`
+	Key string `json:"key"`
+	// CredentialsSecret names a Secret next to the DeveloperEnvironment
+	// holding an accesskey and a secretkey
+	CredentialsSecret string `json:"credentialsSecret"`
 }
 
 // DependencySpec defines additional tool dependencies
 type DependencySpec struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
+	// Name of the package, Go module or crate
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9@][A-Za-z0-9@._/+:-]*$`
+	Name string `json:"name"`
+	// Version to pin the package to. The latest version is installed when empty.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._+~:-]*$`
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Manager is the package manager installing the dependency
+	// +kubebuilder:default=apt
+	// +optional
+	Manager DependencyManager `json:"manager,omitempty"`
+}
+
+// DependencyManager is a package manager dependencies can be installed with
+// +kubebuilder:validation:Enum=apt;pip;npm;go;cargo
+type DependencyManager string
+
+const (
+	DependencyManagerApt   DependencyManager = "apt"
+	DependencyManagerPip   DependencyManager = "pip"
+	DependencyManagerNpm   DependencyManager = "npm"
+	DependencyManagerGo    DependencyManager = "go"
+	DependencyManagerCargo DependencyManager = "cargo"
+)
+
+// DependencyPhase is the install state of a dependency
+type DependencyPhase string
+
+const (
+	// DependencyPending means the IDE has not reported on the dependency yet
+	DependencyPending DependencyPhase = "Pending"
+	// DependencyInstalled means the dependency was installed
+	DependencyInstalled DependencyPhase = "Installed"
+	// DependencyFailed means the package manager failed to install the dependency
+	DependencyFailed DependencyPhase = "Failed"
+)
+
+// DependencyStatus reports the install result of a dependency
+type DependencyStatus struct {
+	Name    string            `json:"name"`
+	Version string            `json:"version,omitempty"`
+	Manager DependencyManager `json:"manager"`
+	Phase   DependencyPhase   `json:"phase"`
+	Message string            `json:"message,omitempty"`
 }
 
+// ExtensionPhase is the install state of an extension
+type ExtensionPhase string
+
+const (
+	// ExtensionPending means the extension has not been installed yet
+	ExtensionPending ExtensionPhase = "Pending"
+	// ExtensionInstalled means the extension was installed
+	ExtensionInstalled ExtensionPhase = "Installed"
+	// ExtensionFailed means the extension could not be installed
+	ExtensionFailed ExtensionPhase = "Failed"
+)
+
+// ExtensionSource is where an extension was installed from
+type ExtensionSource string
+
+const (
+	// ExtensionSourceRegistry is the extension registry or package index of the IDE
+	ExtensionSourceRegistry ExtensionSource = "Registry"
+	// ExtensionSourceVSIX is a .vsix file of spec.ide.vsix
+	ExtensionSourceVSIX ExtensionSource = "VSIX"
+)
+
+// ExtensionStatus reports the install result of an extension
+type ExtensionStatus struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version,omitempty"`
+	Source  ExtensionSource `json:"source,omitempty"`
+	Phase   ExtensionPhase  `json:"phase"`
+	Message string          `json:"message,omitempty"`
+}
+
+// RepositoryStatus reports the clone of a repository
+type RepositoryStatus struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+	// Commit checked out in the workspace when the IDE last started
+	Commit  string `json:"commit,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Phases of a DeveloperEnvironment
+const (
+	// PhasePending means the environment has been accepted but nothing has been created yet
+	PhasePending = "Pending"
+	// PhaseProvisioning means child resources exist but not all components are ready
+	PhaseProvisioning = "Provisioning"
+	// PhaseReady means every component of the environment is ready
+	PhaseReady = "Ready"
+	// PhaseDegraded means the environment was ready before and at least one component no longer is
+	PhaseDegraded = "Degraded"
+	// PhaseFailed means the environment could not be provisioned
+	PhaseFailed = "Failed"
+	// PhaseSuspended means the environment is scaled to zero, keeping its volumes
+	PhaseSuspended = "Suspended"
+	// PhaseTerminating means the environment is being deleted
+	PhaseTerminating = "Terminating"
+)
+
+// Condition types reported in DeveloperEnvironmentStatus.Conditions
+const (
+	// ConditionReconciled reports whether the last reconcile of the environment succeeded
+	ConditionReconciled = "Reconciled"
+	// ConditionIDEReady reports the state of the IDE Deployment
+	ConditionIDEReady = "IDEReady"
+	// ConditionServicesReady reports whether every backing service is ready
+	ConditionServicesReady = "ServicesReady"
+	// ConditionCertificateReady reports whether the certificate of the IDE is
+	// issued and valid, unless the IDE is served without TLS
+	ConditionCertificateReady = "CertificateReady"
+	// ConditionIngressReady reports the state of the IDE Ingress
+	ConditionIngressReady = "IngressReady"
+	// ConditionRuntimeReady reports whether the language toolchain of the IDE can be provided
+	ConditionRuntimeReady = "RuntimeReady"
+	// ConditionDependenciesInstalled reports whether every dependency was installed
+	ConditionDependenciesInstalled = "DependenciesInstalled"
+	// ConditionExtensionsInstalled reports whether every extension was installed
+	ConditionExtensionsInstalled = "ExtensionsInstalled"
+	// ConditionRepositoriesCloned reports whether every repository was cloned into the workspace
+	ConditionRepositoriesCloned = "RepositoriesCloned"
+	// ConditionDatabasesSeeded reports whether every database with a seed has been seeded from its current source
+	ConditionDatabasesSeeded = "DatabasesSeeded"
+	// ConditionRestored reports whether the volumes of spec.restoreFrom have been restored
+	ConditionRestored = "Restored"
+	// ConditionStorageReady reports whether the volumes match the requested size and class
+	ConditionStorageReady = "StorageReady"
+	// ConditionSuspended reports whether the environment is suspended and why
+	ConditionSuspended = "Suspended"
+	// ConditionQuotaExceeded reports whether the environment has run out of its resource quota
+	ConditionQuotaExceeded = "QuotaExceeded"
+	// ConditionProvisioned reports whether the environment became ready
+	// within its provisioning timeout
+	ConditionProvisioned = "Provisioned"
+	// ConditionNamespaceMigrated reports the progress of moving the environment to another namespace
+	ConditionNamespaceMigrated = "NamespaceMigrated"
+)
+
 // DeveloperEnvironmentStatus defines the observed state of DeveloperEnvironment
 type DeveloperEnvironmentStatus struct {
-	Phase       string      `json:"phase"`
-	Conditions  []Condition `json:"conditions,omitempty"`
+	// +kubebuilder:validation:Enum=Pending;Provisioning;Ready;Degraded;Failed;Suspended;Terminating
+	Phase      string      `json:"phase"`
+	Conditions []Condition `json:"conditions,omitempty"`
+	// AccessURL is the URL the IDE is served at
 	AccessURL   string      `json:"accessURL,omitempty"`
 	LastUpdated metav1.Time `json:"lastUpdated,omitempty"`
+	// Certificate reports the certificate the IDE is served with, unless it
+	// is served without TLS
+	// +optional
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
+	// Database describes how to reach the environment database of spec.database
+	Database *DatabaseStatus `json:"database,omitempty"`
+	// Services reports how to reach each backing service and whether it is ready
+	Services []ServiceStatus `json:"services,omitempty"`
+	// Namespace is the namespace the environment's resources live in
+	Namespace string `json:"namespace,omitempty"`
+	// ObservedGeneration is the generation of the spec the status was computed for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Suspended reports whether the IDE and database are scaled to zero
+	Suspended bool `json:"suspended,omitempty"`
+	// NextWakeTime is when the schedule next starts working hours, while the environment is suspended
+	NextWakeTime *metav1.Time `json:"nextWakeTime,omitempty"`
+	// ProvisioningStartTime is when the environment started provisioning its
+	// current spec, while it has not been ready since
+	// +optional
+	ProvisioningStartTime *metav1.Time `json:"provisioningStartTime,omitempty"`
+	// IdleSuspendedAt is when the environment was suspended for being idle,
+	// while it stays suspended for it
+	// +optional
+	IdleSuspendedAt *metav1.Time `json:"idleSuspendedAt,omitempty"`
+	// LastActivityTime is the last time the IDE was seen in use
+	LastActivityTime *metav1.Time `json:"lastActivityTime,omitempty"`
+	// Runtime reports how the language toolchain of the IDE is provided
+	Runtime *RuntimeStatus `json:"runtime,omitempty"`
+	// Dependencies reports the install result of each of spec.dependencies
+	Dependencies []DependencyStatus `json:"dependencies,omitempty"`
+	// Extensions reports the install result of each of spec.ide.extensions
+	Extensions []ExtensionStatus `json:"extensions,omitempty"`
+	// Repositories reports the checked out commit of each of spec.repositories
+	Repositories []RepositoryStatus `json:"repositories,omitempty"`
+	// Restore reports the restore of spec.restoreFrom
+	// +optional
+	Restore *RestoreStatus `json:"restore,omitempty"`
+	// DeletionSnapshot is the DeveloperEnvironmentSnapshot taken when the
+	// environment was deleted with the Snapshot deletion policy
+	// +optional
+	DeletionSnapshot string `json:"deletionSnapshot,omitempty"`
+	// AdoptedVolumes lists the volumes retained by a deleted environment of
+	// the same owner that the environment took over
+	// +optional
+	AdoptedVolumes []string `json:"adoptedVolumes,omitempty"`
+}
+
+// DeletionPolicy is what happens to the volumes of a deleted environment
+// +kubebuilder:validation:Enum=Delete;Retain;Snapshot
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the volumes with the environment
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain keeps the volumes for the next environment of the same owner
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicySnapshot takes a DeveloperEnvironmentSnapshot of the
+	// volumes before deleting them, and retains them if the snapshot fails
+	DeletionPolicySnapshot DeletionPolicy = "Snapshot"
+)
+
+// RestorePhase is the progress of restoring an environment from a snapshot
+type RestorePhase string
+
+const (
+	RestorePhasePending   RestorePhase = "Pending"
+	RestorePhaseRunning   RestorePhase = "Running"
+	RestorePhaseSucceeded RestorePhase = "Succeeded"
+	RestorePhaseFailed    RestorePhase = "Failed"
+)
+
+// RestoreStatus reports the restore of an environment from a snapshot
+type RestoreStatus struct {
+	// Snapshot the environment is restored from
+	Snapshot string `json:"snapshot"`
+	// Method the snapshot was taken with
+	Method SnapshotMethod `json:"method,omitempty"`
+	Phase  RestorePhase   `json:"phase"`
+	// Volumes lists the restored volumes: workspace, and the names of backing services
+	Volumes []string `json:"volumes,omitempty"`
+	Message string   `json:"message,omitempty"`
+}
+
+// RuntimeSource tells where the language toolchain of an environment comes from
+// +kubebuilder:validation:Enum=Catalog;InstallScript;IDEImage
+type RuntimeSource string
+
+const (
+	// RuntimeSourceCatalog means the IDE runs a prebuilt image from a LanguageRuntime
+	RuntimeSourceCatalog RuntimeSource = "Catalog"
+	// RuntimeSourceIDEImage means the image of the IDE type comes with the toolchain
+	RuntimeSourceIDEImage RuntimeSource = "IDEImage"
+	// RuntimeSourceInstallScript means the toolchain is installed by a script when the IDE starts
+	RuntimeSourceInstallScript RuntimeSource = "InstallScript"
+)
+
+// CertificateStatus reports the certificate the IDE is served with
+type CertificateStatus struct {
+	Mode TLSMode `json:"mode"`
+	// Issuer is the cert-manager issuer of the certificate, as <kind>/<name>
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+	// SecretName is the Secret the Ingress reads the certificate from
+	SecretName string `json:"secretName"`
+	// NotAfter is when the certificate expires
+	// +optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+	// RenewalTime is when cert-manager renews the certificate
+	// +optional
+	RenewalTime *metav1.Time `json:"renewalTime,omitempty"`
+}
+
+// RuntimeStatus describes the resolved IDE image
+type RuntimeStatus struct {
+	// Image the IDE container runs
+	Image  string        `json:"image"`
+	Source RuntimeSource `json:"source"`
+	// LanguageRuntime is the name of the LanguageRuntime the image was resolved from
+	LanguageRuntime string `json:"languageRuntime,omitempty"`
+	// ScriptedLanguages are the languages the install script provisions on top of the image
+	ScriptedLanguages []string `json:"scriptedLanguages,omitempty"`
+}
+
+// ServiceStatus describes a backing service of the environment
+type ServiceStatus struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+	// Seed reports the seeding of the service, if it has a seed
+	// +optional
+	Seed *SeedStatus `json:"seed,omitempty"`
+
+	DatabaseStatus `json:",inline"`
+}
+
+// SeedPhase is the progress of seeding a database
+type SeedPhase string
+
+const (
+	// SeedPhasePending waits for the database to become ready
+	SeedPhasePending   SeedPhase = "Pending"
+	SeedPhaseRunning   SeedPhase = "Running"
+	SeedPhaseSucceeded SeedPhase = "Succeeded"
+	SeedPhaseFailed    SeedPhase = "Failed"
+)
+
+// SeedStatus reports the seeding of a database from a source
+type SeedStatus struct {
+	// Checksum identifies the seed source
+	Checksum string    `json:"checksum"`
+	Phase    SeedPhase `json:"phase"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// DatabaseStatus describes how to connect to the environment database
+type DatabaseStatus struct {
+	// Host is the in-cluster DNS name of the database Service
+	Host string `json:"host"`
+	Port int32  `json:"port"`
+	User string `json:"user,omitempty"`
+	// Name of the database to connect to, if the database type has one
+	Name string `json:"name,omitempty"`
+	// ConnectionSecret is the name of the Secret holding the password and DSN
+	ConnectionSecret string `json:"connectionSecret"`
 }
 
 // Condition contains details for the current condition of the DevEnv
@@ -76,10 +735,18 @@ type Condition struct {
 	Status  string `json:"status"`
 	Reason  string `json:"reason,omitempty"`
 	Message string `json:"message,omitempty"`
+	// LastTransitionTime is the last time the condition changed status
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// ObservedGeneration is the generation of the spec the condition was computed for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Language",type=string,JSONPath=`.spec.language`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.accessURL`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // DeveloperEnvironment is the Schema for the developerenvironments API
 type DeveloperEnvironment struct {
@@ -0,0 +1,141 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SnapshotMethod is how the volumes of an environment are captured
+// +kubebuilder:validation:Enum=Auto;VolumeSnapshot;S3
+type SnapshotMethod string
+
+const (
+	// SnapshotMethodAuto takes CSI VolumeSnapshots when the cluster supports them, and uploads to S3 otherwise
+	SnapshotMethodAuto SnapshotMethod = "Auto"
+	// SnapshotMethodVolumeSnapshot takes a CSI VolumeSnapshot of every volume
+	SnapshotMethodVolumeSnapshot SnapshotMethod = "VolumeSnapshot"
+	// SnapshotMethodS3 uploads a tar archive of every volume, or a dump of
+	// the databases that have a dump tool, to an S3-compatible bucket
+	SnapshotMethodS3 SnapshotMethod = "S3"
+)
+
+// DeveloperEnvironmentSnapshotSpec selects the environment to capture and how
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="snapshots are immutable"
+type DeveloperEnvironmentSnapshotSpec struct {
+	// EnvironmentName is the DeveloperEnvironment next to the snapshot whose
+	// workspace and backing service volumes are captured
+	EnvironmentName string `json:"environmentName"`
+
+	SnapshotOptions `json:",inline"`
+}
+
+// SnapshotOptions configures how the volumes of an environment are captured
+// +kubebuilder:validation:XValidation:rule="!has(self.method) || self.method != 'S3' || has(self.s3)",message="s3 is required by the S3 method"
+type SnapshotOptions struct {
+	// Method of capturing the volumes
+	// +kubebuilder:default=Auto
+	// +optional
+	Method SnapshotMethod `json:"method,omitempty"`
+	// VolumeSnapshotClassName of the VolumeSnapshots. The default class of
+	// the CSI driver is used when empty.
+	// +optional
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+	// S3 is where the S3 method uploads the volumes, under
+	// <key>/<snapshot>/<volume>. Key is a prefix here.
+	// +optional
+	S3 *S3Location `json:"s3,omitempty"`
+}
+
+// SnapshotPhase is the progress of a snapshot
+type SnapshotPhase string
+
+const (
+	SnapshotPhasePending    SnapshotPhase = "Pending"
+	SnapshotPhaseInProgress SnapshotPhase = "InProgress"
+	SnapshotPhaseReady      SnapshotPhase = "Ready"
+	SnapshotPhaseFailed     SnapshotPhase = "Failed"
+)
+
+// SnapshotVolume is the capture of one volume of the environment
+type SnapshotVolume struct {
+	// Name of the volume: workspace, or the name of a backing service
+	Name string `json:"name"`
+	// Type of the backing service, empty for the workspace
+	// +optional
+	Type string `json:"type,omitempty"`
+	// ClaimName is the PersistentVolumeClaim that was captured
+	ClaimName string `json:"claimName"`
+	// Size of the volume when it was captured, the minimum size to restore it to
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+	// VolumeSnapshotContentName holds the CSI snapshot of the volume
+	// +optional
+	VolumeSnapshotContentName string `json:"volumeSnapshotContentName,omitempty"`
+	// Key of the S3 object holding the archive or dump of the volume
+	// +optional
+	Key     string        `json:"key,omitempty"`
+	Phase   SnapshotPhase `json:"phase,omitempty"`
+	Message string        `json:"message,omitempty"`
+}
+
+// DeveloperEnvironmentSnapshotStatus reports the progress of the snapshot
+type DeveloperEnvironmentSnapshotStatus struct {
+	Phase SnapshotPhase `json:"phase,omitempty"`
+	// Namespace the volumes were captured in, the namespace of the environment
+	Namespace string `json:"namespace,omitempty"`
+	// Method the volumes were captured with, Auto resolved
+	Method  SnapshotMethod `json:"method,omitempty"`
+	Message string         `json:"message,omitempty"`
+	// Volumes lists the captured volumes
+	Volumes []SnapshotVolume `json:"volumes,omitempty"`
+	// CompletedAt is when every volume was captured
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName=devsnap
+//+kubebuilder:validation:XValidation:rule="self.metadata.name.size() <= 30",message="name must be no more than 30 characters"
+//+kubebuilder:printcolumn:name="Environment",type=string,JSONPath=`.spec.environmentName`
+//+kubebuilder:printcolumn:name="Method",type=string,JSONPath=`.status.method`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// DeveloperEnvironmentSnapshot captures the volumes of a DeveloperEnvironment,
+// which a new environment can be restored from with spec.restoreFrom
+type DeveloperEnvironmentSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DeveloperEnvironmentSnapshotSpec   `json:"spec,omitempty"`
+	Status DeveloperEnvironmentSnapshotStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DeveloperEnvironmentSnapshotList contains a list of DeveloperEnvironmentSnapshot
+type DeveloperEnvironmentSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DeveloperEnvironmentSnapshot `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DeveloperEnvironmentSnapshot{}, &DeveloperEnvironmentSnapshotList{})
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LanguageRuntimeSpec maps the versions of a language to prebuilt IDE images
+type LanguageRuntimeSpec struct {
+	// Language the images provide, matching DeveloperEnvironment spec.language
+	Language string `json:"language"`
+
+	// IDE the images run. The images replace the image of the IDE type, so
+	// they should be built on top of it with the toolchain preinstalled.
+	// +kubebuilder:default=vscode
+	// +optional
+	IDE IDEType `json:"ide,omitempty"`
+
+	// Images lists the prebuilt image of each supported version
+	// +kubebuilder:validation:MinItems=1
+	Images []RuntimeImage `json:"images"`
+}
+
+// RuntimeImage is the prebuilt image of one language version
+type RuntimeImage struct {
+	// Version of the language, matching DeveloperEnvironment spec.version exactly
+	Version string `json:"version"`
+	// Image reference, preferably pinned by digest
+	Image string `json:"image"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Language",type=string,JSONPath=`.spec.language`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// LanguageRuntime is the Schema for the languageruntimes API
+type LanguageRuntime struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec LanguageRuntimeSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LanguageRuntimeList contains a list of LanguageRuntime
+type LanguageRuntimeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LanguageRuntime `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LanguageRuntime{}, &LanguageRuntimeList{})
+}
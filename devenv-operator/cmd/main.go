@@ -17,22 +17,34 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+
 	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
 	"github.com/adityajoshi12/devenv-operator/internal/controller"
+	webhookv1 "github.com/adityajoshi12/devenv-operator/internal/webhook/v1"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,6 +57,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(apiv1.AddToScheme(scheme))
+	utilruntime.Must(certmanagerv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -71,6 +84,13 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "b42ce6de.adityajoshi.online",
+		// Events are only read to explain failures, which does not warrant
+		// caching every event of the cluster
+		Client: client.Options{
+			Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Event{}}},
+		},
+		// Nor do the child objects warrant caching every object of their kinds
+		Cache: cache.Options{ByObject: controller.CacheByObject()},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -88,30 +108,136 @@ func main() {
 		os.Exit(1)
 	}
 
-	var resourceURL string
-	if url := os.Getenv("RESOURCE_URL"); url != "" {
-		resourceURL = url
-	} else {
-		resourceURL = "developerenv.adityajoshi.online"
-	}
+	resourceURL := getEnv("RESOURCE_URL", "developerenv.adityajoshi.online")
 	setupLog.Info("Resource URL", "url", resourceURL)
 
-	var ingressClass string
-	if ingClass := os.Getenv("INGRESS_CLASS"); ingClass != "" {
-		ingressClass = ingClass
-	} else {
-		ingressClass = "nginx"
+	ingressClass := getEnv("INGRESS_CLASS", "nginx")
+
+	namespaceMode := apiv1.NamespaceMode(getEnv("NAMESPACE_MODE", string(apiv1.NamespaceModeShared)))
+	if namespaceMode != apiv1.NamespaceModeShared && namespaceMode != apiv1.NamespaceModeDedicated {
+		setupLog.Error(nil, "NAMESPACE_MODE must be Shared or Dedicated", "mode", namespaceMode)
+		os.Exit(1)
+	}
+	setupLog.Info("Namespace mode", "mode", namespaceMode)
+
+	defaultTLS := apiv1.TLSSpec{
+		Mode:       apiv1.TLSMode(getEnv("TLS_MODE", string(apiv1.TLSModeSelfSigned))),
+		IssuerName: os.Getenv("TLS_ISSUER"),
+		SecretName: os.Getenv("TLS_SECRET"),
+	}
+	if err := validateTLS(defaultTLS); err != nil {
+		setupLog.Error(err, "invalid TLS configuration", "mode", defaultTLS.Mode)
+		os.Exit(1)
+	}
+	setupLog.Info("TLS mode", "mode", defaultTLS.Mode)
+
+	var defaultQuota apiv1.QuotaSpec
+	for env, list := range map[string]*corev1.ResourceList{
+		"DEFAULT_QUOTA":             &defaultQuota.Hard,
+		"DEFAULT_CONTAINER_REQUEST": &defaultQuota.DefaultRequest,
+		"DEFAULT_CONTAINER_LIMIT":   &defaultQuota.Default,
+	} {
+		if *list, err = parseResourceList(os.Getenv(env)); err != nil {
+			setupLog.Error(err, "invalid resource list", "variable", env)
+			os.Exit(1)
+		}
+	}
+
+	ingressNamespace := getEnv("INGRESS_NAMESPACE", "ingress-nginx")
+	operatorNamespace := getEnv("POD_NAMESPACE", "devenv-operator-system")
+
+	disableInstallScript, err := strconv.ParseBool(getEnv("DISABLE_INSTALL_SCRIPT", "false"))
+	if err != nil {
+		setupLog.Error(err, "invalid DISABLE_INSTALL_SCRIPT")
+		os.Exit(1)
+	}
+
+	defaults := controller.EnvironmentDefaults{
+		StorageClassName:  os.Getenv("DEFAULT_STORAGE_CLASS"),
+		ExtensionRegistry: os.Getenv("EXTENSION_REGISTRY"),
+	}
+	if registry := defaults.ExtensionRegistry; registry != "" &&
+		!strings.HasPrefix(registry, "https://") && !strings.HasPrefix(registry, "http://") {
+		setupLog.Error(nil, "EXTENSION_REGISTRY must be an http or https URL", "registry", registry)
+		os.Exit(1)
+	}
+	for env, list := range map[string]struct {
+		target   *corev1.ResourceList
+		fallback string
+	}{
+		"DEFAULT_IDE_REQUESTS":      {&defaults.IDEResources.Requests, "cpu=500m,memory=512Mi"},
+		"DEFAULT_IDE_LIMITS":        {&defaults.IDEResources.Limits, "cpu=1,memory=1Gi"},
+		"DEFAULT_DATABASE_REQUESTS": {&defaults.DatabaseResources.Requests, "cpu=250m,memory=256Mi"},
+		"DEFAULT_DATABASE_LIMITS":   {&defaults.DatabaseResources.Limits, "cpu=1,memory=1Gi"},
+	} {
+		if *list.target, err = parseResourceList(getEnv(env, list.fallback)); err != nil {
+			setupLog.Error(err, "invalid resource list", "variable", env)
+			os.Exit(1)
+		}
+	}
+	for name, resources := range map[string]corev1.ResourceRequirements{
+		"IDE":      defaults.IDEResources,
+		"database": defaults.DatabaseResources,
+	} {
+		for resourceName, request := range resources.Requests {
+			if limit, ok := resources.Limits[resourceName]; ok && request.Cmp(limit) > 0 {
+				setupLog.Error(nil, "default request exceeds default limit", "component", name, "resource", resourceName)
+				os.Exit(1)
+			}
+		}
+	}
+	for env, size := range map[string]*resource.Quantity{
+		"DEFAULT_WORKSPACE_SIZE": &defaults.WorkspaceSize,
+		"DEFAULT_DATABASE_SIZE":  &defaults.DatabaseSize,
+	} {
+		if *size, err = resource.ParseQuantity(getEnv(env, "10Gi")); err != nil || size.Sign() <= 0 {
+			setupLog.Error(err, "invalid volume size", "variable", env)
+			os.Exit(1)
+		}
+	}
+
+	if defaults.ProvisioningTimeout, err = time.ParseDuration(getEnv("PROVISIONING_TIMEOUT", "30m")); err != nil ||
+		defaults.ProvisioningTimeout < 0 {
+		setupLog.Error(err, "invalid provisioning timeout", "variable", "PROVISIONING_TIMEOUT")
+		os.Exit(1)
+	}
+	if defaults.IDESettings, err = parseSettings(os.Getenv("DEFAULT_IDE_SETTINGS")); err != nil {
+		setupLog.Error(err, "invalid IDE settings", "variable", "DEFAULT_IDE_SETTINGS")
+		os.Exit(1)
 	}
 
 	if err = (&controller.DeveloperEnvironmentReconciler{
-		Client:       mgr.GetClient(),
-		Scheme:       mgr.GetScheme(),
-		ResourceURL:  resourceURL,
-		IngressClass: ingressClass,
+		Client:               mgr.GetClient(),
+		APIReader:            mgr.GetAPIReader(),
+		Scheme:               mgr.GetScheme(),
+		ResourceURL:          resourceURL,
+		IngressClass:         ingressClass,
+		NamespaceMode:        namespaceMode,
+		DefaultQuota:         defaultQuota,
+		DefaultTLS:           defaultTLS,
+		IngressNamespace:     ingressNamespace,
+		OperatorNamespace:    operatorNamespace,
+		DisableInstallScript: disableInstallScript,
+		Defaults:             defaults,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DeveloperEnvironment")
 		os.Exit(1)
 	}
+	if err = (&controller.DeveloperEnvironmentSnapshotReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Scheme:    mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DeveloperEnvironmentSnapshot")
+		os.Exit(1)
+	}
+	// Webhooks need serving certificates, so running locally with `make run` requires ENABLE_WEBHOOKS=false
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookv1.SetupDeveloperEnvironmentWebhookWithManager(mgr, resourceURL); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DeveloperEnvironment")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -128,3 +254,67 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// getEnv returns the value of the environment variable, or fallback when it is unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseResourceList parses a comma separated list of name=quantity pairs, e.g. "cpu=500m,memory=1Gi"
+func parseResourceList(value string) (corev1.ResourceList, error) {
+	if value == "" {
+		return nil, nil
+	}
+	list := corev1.ResourceList{}
+	for _, pair := range strings.Split(value, ",") {
+		name, quantity, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("expected name=quantity, got %q", pair)
+		}
+		q, err := resource.ParseQuantity(quantity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity for %s: %w", name, err)
+		}
+		list[corev1.ResourceName(name)] = q
+	}
+	return list, nil
+}
+
+// parseSettings parses a JSON object of settings, keeping each value as JSON,
+// e.g. {"editor.tabSize": 2, "files.autoSave": "afterDelay"}
+func parseSettings(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("expected a JSON object: %w", err)
+	}
+	settings := make(map[string]string, len(raw))
+	for name, setting := range raw {
+		settings[name] = string(setting)
+	}
+	return settings, nil
+}
+
+// validateTLS checks that TLS_ISSUER and TLS_SECRET are set for the modes
+// that use them
+func validateTLS(tls apiv1.TLSSpec) error {
+	switch tls.Mode {
+	case apiv1.TLSModeSelfSigned, apiv1.TLSModeNone:
+	case apiv1.TLSModeIssuer, apiv1.TLSModeClusterIssuer:
+		if tls.IssuerName == "" {
+			return fmt.Errorf("TLS_ISSUER must be set for the %s mode", tls.Mode)
+		}
+	case apiv1.TLSModeSecret:
+		if tls.SecretName == "" {
+			return fmt.Errorf("TLS_SECRET must be set for the %s mode", tls.Mode)
+		}
+	default:
+		return fmt.Errorf("TLS_MODE must be SelfSigned, Issuer, ClusterIssuer, Secret or None")
+	}
+	return nil
+}
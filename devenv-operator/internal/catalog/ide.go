@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package catalog describes what the supported IDE and backing service types
+// can do, for the controller running them and the webhooks validating
+// environments to agree.
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// IDE describes the capabilities of an IDE type
+type IDE struct {
+	// Languages come with the image, in whatever version it has
+	Languages []string
+	// Scripts tells whether the image can run the install and dependency
+	// scripts at startup, which need sudo and apt
+	Scripts bool
+	// VSIX tells whether the IDE installs extensions from .vsix files and
+	// Open VSX registries
+	VSIX bool
+	// ActivityPath reports the last user activity, read by Activity, for the
+	// types that can be suspended when idle
+	ActivityPath string
+	Activity     func(body io.Reader) (time.Time, error)
+}
+
+// IDETypes are the supported IDE types
+var IDETypes = map[apiv1.IDEType]IDE{
+	apiv1.IDETypeVSCode: {
+		Scripts:      true,
+		VSIX:         true,
+		ActivityPath: "/healthz",
+		Activity:     codeServerActivity,
+	},
+	apiv1.IDETypeJupyterLab: {
+		Languages: []string{"python"},
+	},
+}
+
+// codeServerActivity reads the last heartbeat from the health of code-server
+func codeServerActivity(body io.Reader) (time.Time, error) {
+	var health struct {
+		LastHeartbeat int64 `json:"lastHeartbeat"`
+	}
+	if err := json.NewDecoder(body).Decode(&health); err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode code-server health: %w", err)
+	}
+	if health.LastHeartbeat == 0 {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(health.LastHeartbeat), nil
+}
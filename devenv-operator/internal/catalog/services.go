@@ -0,0 +1,34 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package catalog
+
+// Service describes the capabilities of a backing service type
+type Service struct {
+	// Seedable tells whether the service can be seeded from init scripts or a dump
+	Seedable bool
+}
+
+// ServiceTypes are the supported backing service types
+var ServiceTypes = map[string]Service{
+	"postgres": {Seedable: true},
+	"redis":    {},
+	"mysql":    {Seedable: true},
+	"mariadb":  {Seedable: true},
+	"mongodb":  {Seedable: true},
+	"rabbitmq": {},
+	"kafka":    {},
+}
@@ -0,0 +1,406 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// Keys of the tools ConfigMap
+const (
+	installToolsScript        = "install-tools.sh"
+	installDependenciesScript = "install-dependencies.sh"
+)
+
+// annotationToolsChecksum rolls the IDE when the scripts it runs at startup change
+const annotationToolsChecksum = "devenv.adityajoshi.online/tools-checksum"
+
+// dependencyChecksumKey holds the checksum of the dependency list the results in the status ConfigMap belong to
+const dependencyChecksumKey = "checksum"
+
+// Paths of the dependency reporter, which shares the results volume with the
+// IDE and is the only container of the pod given the service account token
+const (
+	dependencyReporterContainerName = "dependency-reporter"
+	dependencyResultsPath           = "/var/run/devenv/dependencies"
+	serviceAccountTokenPath         = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// dependencyScriptTemplate installs every dependency, carrying on past
+// failures, and leaves the exit code of each on the results volume for the
+// dependency reporter.
+var dependencyScriptTemplate = template.Must(template.New("install-dependencies").Parse(`#!/bin/bash
+echo $SUDO_PASSWORD | sudo -S -v
+export DEBIAN_FRONTEND=noninteractive
+export PATH=$PATH:/usr/local/go/bin:$HOME/go/bin:$HOME/.cargo/bin
+export NVM_DIR="$HOME/.nvm"
+[ -s "$NVM_DIR/nvm.sh" ] && \. "$NVM_DIR/nvm.sh"
+{{- if .Apt }}
+sudo apt-get update
+{{- end }}
+
+results={{ .Results }}
+: > $results.tmp
+{{- range $i, $command := .Commands }}
+{{ $command }}
+echo "{{ $i }}=$?" >> $results.tmp
+{{- end }}
+mv $results.tmp $results
+
+echo "Dependency installation complete!"
+`))
+
+func ideServiceAccountName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-ide", devEnv.Name)
+}
+
+func dependencyStatusConfigMapName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-dependency-status", devEnv.Name)
+}
+
+// dependencyManager returns the manager of a dependency, apt unless set
+func dependencyManager(dep apiv1.DependencySpec) apiv1.DependencyManager {
+	if dep.Manager == "" {
+		return apiv1.DependencyManagerApt
+	}
+	return dep.Manager
+}
+
+// shellQuote quotes a value for use as a single word in a bash script
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// dependencyCommand returns the command installing the dependency at its pinned version
+func dependencyCommand(dep apiv1.DependencySpec) string {
+	switch dependencyManager(dep) {
+	case apiv1.DependencyManagerPip:
+		spec := dep.Name
+		if dep.Version != "" {
+			spec += "==" + dep.Version
+		}
+		return "python3 -m pip install --user " + shellQuote(spec)
+	case apiv1.DependencyManagerNpm:
+		spec := dep.Name
+		if dep.Version != "" {
+			spec += "@" + dep.Version
+		}
+		return "npm install -g " + shellQuote(spec)
+	case apiv1.DependencyManagerGo:
+		version := dep.Version
+		if version == "" {
+			version = "latest"
+		}
+		return "go install " + shellQuote(dep.Name+"@"+version)
+	case apiv1.DependencyManagerCargo:
+		command := "cargo install " + shellQuote(dep.Name)
+		if dep.Version != "" {
+			command += " --version " + shellQuote(dep.Version)
+		}
+		return command
+	default:
+		spec := dep.Name
+		if dep.Version != "" {
+			spec += "=" + dep.Version
+		}
+		return "sudo apt-get install -y " + shellQuote(spec)
+	}
+}
+
+// dependencyChecksum identifies a dependency list, so results can be matched to it
+func dependencyChecksum(deps []apiv1.DependencySpec) string {
+	data, _ := json.Marshal(deps)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checksum returns a short hash of the ConfigMap data
+func checksum(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+// renderDependencyScript renders the script installing spec.dependencies when the IDE starts
+func renderDependencyScript(devEnv *apiv1.DeveloperEnvironment) (string, error) {
+	data := struct {
+		Apt      bool
+		Commands []string
+		Results  string
+	}{
+		Results: path.Join(dependencyResultsPath, "results"),
+	}
+	for _, dep := range devEnv.Spec.Dependencies {
+		if dependencyManager(dep) == apiv1.DependencyManagerApt {
+			data.Apt = true
+		}
+		data.Commands = append(data.Commands, dependencyCommand(dep))
+	}
+
+	var script bytes.Buffer
+	if err := dependencyScriptTemplate.Execute(&script, data); err != nil {
+		return "", fmt.Errorf("failed to render dependency script: %w", err)
+	}
+	return script.String(), nil
+}
+
+// dependencyReporterScript records the exit codes the dependency script
+// leaves on the results volume in the dependency status ConfigMap. Only exit
+// codes of the dependencies of the current list are taken from the file.
+const dependencyReporterScript = `
+results=` + dependencyResultsPath + `/results
+reported=
+while true; do
+  if [ -f $results ] && [ "$(cat $results)" != "$reported" ]; then
+    reported=$(cat $results)
+    data="\"$CHECKSUM_KEY\":\"$CHECKSUM\""
+    for i in $(seq 0 $((COUNT - 1))); do
+      code=$(sed -n "s/^$i=\([0-9]\{1,3\}\)$/\1/p" $results | head -n 1)
+      [ -n "$code" ] && data="$data,\"$i\":\"$code\""
+    done
+    curl -sS --fail --cacert $SA/ca.crt \
+      -H "Authorization: Bearer $(cat $SA/token)" \
+      -H "Content-Type: application/merge-patch+json" \
+      -X PATCH "https://kubernetes.default.svc/api/v1/namespaces/$NAMESPACE/configmaps/$CONFIGMAP" \
+      -d "{\"data\":{$data}}" > /dev/null || reported=
+  fi
+  sleep 5
+done
+`
+
+// dependencyReporterContainer runs the dependency reporter next to the IDE,
+// in the image of the IDE for its bash and curl. It holds the token of the
+// IDE service account, which the IDE container is not given, so that the
+// IDE cannot write the status ConfigMap itself.
+func dependencyReporterContainer(devEnv *apiv1.DeveloperEnvironment) corev1.Container {
+	return corev1.Container{
+		Name:            dependencyReporterContainerName,
+		Image:           devEnv.Status.Runtime.Image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/bash", "-c", dependencyReporterScript},
+		Env: []corev1.EnvVar{
+			{Name: "SA", Value: serviceAccountTokenPath},
+			{Name: "NAMESPACE", Value: environmentNamespace(devEnv)},
+			{Name: "CONFIGMAP", Value: dependencyStatusConfigMapName(devEnv)},
+			{Name: "CHECKSUM_KEY", Value: dependencyChecksumKey},
+			{Name: "CHECKSUM", Value: dependencyChecksum(devEnv.Spec.Dependencies)},
+			{Name: "COUNT", Value: strconv.Itoa(len(devEnv.Spec.Dependencies))},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "dependency-results",
+				MountPath: dependencyResultsPath,
+				ReadOnly:  true,
+			},
+			{
+				Name:      "dependency-reporter-token",
+				MountPath: serviceAccountTokenPath,
+				ReadOnly:  true,
+			},
+		},
+	}
+}
+
+// dependencyVolumes are the results volume shared by the IDE and the
+// dependency reporter, and the service account token of the reporter
+func dependencyVolumes() []corev1.Volume {
+	return []corev1.Volume{
+		{
+			Name: "dependency-results",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+		{
+			Name: "dependency-reporter-token",
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{
+						{
+							ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+								Path:              "token",
+								ExpirationSeconds: Ptr(int64(3600)),
+							},
+						},
+						{
+							ConfigMap: &corev1.ConfigMapProjection{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+								Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// setupDependencyReporting gives the dependency reporter a service account
+// that may only record dependency results in the environment's status
+// ConfigMap.
+func (r *DeveloperEnvironmentReconciler) setupDependencyReporting(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) error {
+	statusConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dependencyStatusConfigMapName(devEnv),
+			Namespace: environmentNamespace(devEnv),
+		},
+	}
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ideServiceAccountName(devEnv),
+			Namespace: environmentNamespace(devEnv),
+		},
+	}
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-dependency-reporter", devEnv.Name),
+			Namespace: environmentNamespace(devEnv),
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"configmaps"},
+				ResourceNames: []string{statusConfigMap.Name},
+				Verbs:         []string{"get", "patch"},
+			},
+		},
+	}
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      role.Name,
+			Namespace: environmentNamespace(devEnv),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      serviceAccount.Name,
+				Namespace: serviceAccount.Namespace,
+			},
+		},
+	}
+
+	// The status ConfigMap is applied without data, leaving the results written by the IDE alone
+	for _, obj := range []client.Object{statusConfigMap, serviceAccount, role, roleBinding} {
+		if err := r.apply(ctx, devEnv, obj); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// dependencyStatuses matches the results reported by the IDE to spec.dependencies
+func (r *DeveloperEnvironmentReconciler) dependencyStatuses(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) ([]apiv1.DependencyStatus, error) {
+	if len(devEnv.Spec.Dependencies) == 0 {
+		return nil, nil
+	}
+
+	results := map[string]string{}
+	statusConfigMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      dependencyStatusConfigMapName(devEnv),
+		Namespace: environmentNamespace(devEnv),
+	}, statusConfigMap)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get dependency status: %w", err)
+	}
+	// Results of an earlier dependency list say nothing about the current one
+	if statusConfigMap.Data[dependencyChecksumKey] == dependencyChecksum(devEnv.Spec.Dependencies) {
+		results = statusConfigMap.Data
+	}
+
+	statuses := make([]apiv1.DependencyStatus, 0, len(devEnv.Spec.Dependencies))
+	for i, dep := range devEnv.Spec.Dependencies {
+		status := apiv1.DependencyStatus{
+			Name:    dep.Name,
+			Version: dep.Version,
+			Manager: dependencyManager(dep),
+			Phase:   apiv1.DependencyPending,
+		}
+		if code, ok := results[strconv.Itoa(i)]; ok {
+			if code == "0" {
+				status.Phase = apiv1.DependencyInstalled
+			} else {
+				status.Phase = apiv1.DependencyFailed
+				status.Message = fmt.Sprintf("%s exited with code %s", status.Manager, code)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// dependencyCondition summarises the dependency statuses
+func dependencyCondition(statuses []apiv1.DependencyStatus) apiv1.Condition {
+	var pending, failed []string
+	for _, status := range statuses {
+		switch status.Phase {
+		case apiv1.DependencyPending:
+			pending = append(pending, status.Name)
+		case apiv1.DependencyFailed:
+			failed = append(failed, status.Name)
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		return conditionFromBool(apiv1.ConditionDependenciesInstalled, false, "InstallFailed",
+			fmt.Sprintf("Failed to install %s", strings.Join(failed, ", ")))
+	case len(pending) > 0:
+		return conditionFromBool(apiv1.ConditionDependenciesInstalled, false, "Installing",
+			fmt.Sprintf("Waiting for %s", strings.Join(pending, ", ")))
+	default:
+		return conditionFromBool(apiv1.ConditionDependenciesInstalled, true, "Installed",
+			fmt.Sprintf("%d dependencies installed", len(statuses)))
+	}
+}
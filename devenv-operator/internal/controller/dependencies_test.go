@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestDependencyCommand(t *testing.T) {
+	tests := []struct {
+		dep  apiv1.DependencySpec
+		want string
+	}{
+		{
+			dep:  apiv1.DependencySpec{Name: "jq", Version: "1.6-2.1ubuntu3"},
+			want: "sudo apt-get install -y 'jq=1.6-2.1ubuntu3'",
+		},
+		{
+			dep:  apiv1.DependencySpec{Name: "ripgrep", Manager: apiv1.DependencyManagerApt},
+			want: "sudo apt-get install -y 'ripgrep'",
+		},
+		{
+			dep:  apiv1.DependencySpec{Name: "black", Version: "24.8.0", Manager: apiv1.DependencyManagerPip},
+			want: "python3 -m pip install --user 'black==24.8.0'",
+		},
+		{
+			dep:  apiv1.DependencySpec{Name: "@angular/cli", Version: "18.2.0", Manager: apiv1.DependencyManagerNpm},
+			want: "npm install -g '@angular/cli@18.2.0'",
+		},
+		{
+			dep:  apiv1.DependencySpec{Name: "golang.org/x/tools/gopls", Manager: apiv1.DependencyManagerGo},
+			want: "go install 'golang.org/x/tools/gopls@latest'",
+		},
+		{
+			dep:  apiv1.DependencySpec{Name: "cargo-watch", Version: "8.5.2", Manager: apiv1.DependencyManagerCargo},
+			want: "cargo install 'cargo-watch' --version '8.5.2'",
+		},
+		{
+			dep:  apiv1.DependencySpec{Name: "it's"},
+			want: `sudo apt-get install -y 'it'\''s'`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := dependencyCommand(tt.dep); got != tt.want {
+			t.Errorf("dependencyCommand(%+v) = %s, want %s", tt.dep, got, tt.want)
+		}
+	}
+}
+
+// Only the reporter is given the token of the service account, so the IDE
+// leaves its results on the shared volume instead of writing the ConfigMap
+func TestDependencyReporting(t *testing.T) {
+	devEnv := &apiv1.DeveloperEnvironment{}
+	devEnv.Name = "golang-env"
+	devEnv.Spec.Dependencies = []apiv1.DependencySpec{{Name: "jq"}}
+	devEnv.Status.Runtime = &apiv1.RuntimeStatus{Image: "lscr.io/linuxserver/code-server:4.96.4"}
+
+	script, err := renderDependencyScript(devEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, "serviceaccount") || strings.Contains(script, "curl") {
+		t.Errorf("dependency script of the IDE uses a token:\n%s", script)
+	}
+	if !strings.Contains(script, dependencyResultsPath) {
+		t.Errorf("dependency script does not leave its results in %s:\n%s", dependencyResultsPath, script)
+	}
+
+	mounts := map[string]bool{}
+	for _, mount := range dependencyReporterContainer(devEnv).VolumeMounts {
+		mounts[mount.MountPath] = mount.ReadOnly
+	}
+	if readOnly, ok := mounts[serviceAccountTokenPath]; !ok || !readOnly {
+		t.Errorf("dependency reporter mounts = %v, want the token read-only", mounts)
+	}
+	if readOnly, ok := mounts[dependencyResultsPath]; !ok || !readOnly {
+		t.Errorf("dependency reporter mounts = %v, want the results read-only", mounts)
+	}
+}
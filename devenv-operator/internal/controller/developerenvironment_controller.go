@@ -20,43 +20,86 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	"html/template"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"slices"
 	"strings"
 
 	"time"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
 	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	networkingv1 "k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
 )
 
 const finalizerString = "finalizer.devenv.adityajoshi.online"
 
+// fieldManager is the server-side apply field manager owning the fields of child objects
+const fieldManager = "devenv-operator"
+
 // DeveloperEnvironmentReconciler reconciles a DeveloperEnvironment object
 type DeveloperEnvironmentReconciler struct {
 	client.Client
+	// APIReader reads the objects the cache does not hold: those of the user,
+	// and those of earlier versions that are not labeled as managed yet
+	APIReader    client.Reader
 	Scheme       *runtime.Scheme
 	ResourceURL  string
 	IngressClass string
+	// NamespaceMode is the default tenancy model for environments that do not set one
+	NamespaceMode apiv1.NamespaceMode
+	// DefaultQuota sizes the quota of dedicated namespaces where the environment does not
+	DefaultQuota apiv1.QuotaSpec
+	// DefaultTLS selects the certificate of environments that do not, whose
+	// Secret is in the operator namespace
+	DefaultTLS apiv1.TLSSpec
+	// IngressNamespace is the namespace of the ingress controller allowed to reach the IDE
+	IngressNamespace string
+	// OperatorNamespace is the namespace the operator runs in, allowed to reach the IDE to check for activity
+	OperatorNamespace string
+	// DisableInstallScript rejects environments no LanguageRuntime provides an image for,
+	// instead of installing their toolchain with a script at startup
+	DisableInstallScript bool
+	// Defaults sizes the IDE, database and volumes where the environment does not
+	Defaults EnvironmentDefaults
 }
 
 // +kubebuilder:rbac:groups=api.adityajoshi.online,resources=developerenvironments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=api.adityajoshi.online,resources=developerenvironments/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=api.adityajoshi.online,resources=developerenvironments/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps;secrets;services;persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=api.adityajoshi.online,resources=developerenvironmentsnapshots,verbs=get;list;watch
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots;volumesnapshotcontents,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=resourcequotas;limitranges,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses;networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=issuers;certificates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=api.adityajoshi.online,resources=languageruntimes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=get;list
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
 func (r *DeveloperEnvironmentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
@@ -73,10 +116,18 @@ func (r *DeveloperEnvironmentReconciler) Reconcile(ctx context.Context, req ctrl
 
 	if devEnv.DeletionTimestamp != nil {
 		if containsString(devEnv.Finalizers, finalizerString) {
+			if err := r.setPhase(ctx, devEnv, apiv1.PhaseTerminating); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+
 			// Run finalization logic for finalizer.devenv.adityajoshi.online
-			if err := r.finalizeDeveloperEnvironment(ctx, devEnv); err != nil {
+			done, err := r.finalizeDeveloperEnvironment(ctx, devEnv)
+			if err != nil {
 				return ctrl.Result{}, err
 			}
+			if !done {
+				return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+			}
 
 			// Remove finalizer from the list and update it
 			devEnv.Finalizers = removeString(devEnv.Finalizers, finalizerString)
@@ -104,90 +155,167 @@ func (r *DeveloperEnvironmentReconciler) Reconcile(ctx context.Context, req ctrl
 			}
 		}
 	}
-	// Update status
-	if err := r.updateStatus(ctx, devEnv); err != nil {
-		return ctrl.Result{}, err
+	// Newly created environments start out Pending
+	if devEnv.Status.Phase == "" {
+		if err := r.setPhase(ctx, devEnv, apiv1.PhasePending); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
-	if err := r.reconcileDeveloperEnvironment(ctx, devEnv); err != nil {
-		logger.Error(err, "Failed to reconcile developer environment")
-		return ctrl.Result{RequeueAfter: time.Minute}, err
-	}
+	observed := devEnv.Status.DeepCopy()
+	result, reconcileErr := r.reconcileDeveloperEnvironment(ctx, devEnv)
 
-	// Update status
-	if err := r.updateStatus(ctx, devEnv); err != nil {
+	// Update status from the reconcile result and the state of the child resources
+	if err := r.updateStatus(ctx, devEnv, observed, reconcileErr); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
+	if reconcileErr != nil {
+		logger.Error(reconcileErr, "Failed to reconcile developer environment")
+		return ctrl.Result{RequeueAfter: time.Minute}, reconcileErr
+	}
+
+	// Changes to the child objects trigger the next reconcile
+	if requeue := r.provisioningRequeue(devEnv); requeue > 0 && (result.RequeueAfter == 0 || requeue < result.RequeueAfter) {
+		result.RequeueAfter = requeue
+	}
+	// Secrets of the user are not watched, so they are read again periodically
+	if r.readsUserSecret(devEnv) && (result.RequeueAfter == 0 || userSecretResync < result.RequeueAfter) {
+		result.RequeueAfter = userSecretResync
+	}
+	return result, nil
 }
 
 // Reconcile main logic
 func (r *DeveloperEnvironmentReconciler) reconcileDeveloperEnvironment(
 	ctx context.Context,
 	devEnv *apiv1.DeveloperEnvironment,
-) error {
-	// 1. Create Namespace
-	if err := r.ensureNamespace(ctx, devEnv); err != nil {
-		return err
+) (ctrl.Result, error) {
+	// 1. Create Namespace, moving the environment there if it lives elsewhere
+	placed, err := r.reconcileNamespace(ctx, devEnv)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !placed {
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	// 2. Decide whether the environment should be running
+	requeueAfter, err := r.reconcileSuspension(ctx, devEnv)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// 3. Isolate the environment
+	if err := r.setupIsolation(ctx, devEnv); err != nil {
+		return ctrl.Result{}, err
 	}
+
+	// 4. Setup Certificates
 	if err := r.setupCertificates(ctx, devEnv); err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
 
-	// 3. Provision Development Tools
-	if err := r.provisionDevelopmentTools(ctx, devEnv); err != nil {
-		return err
+	// 5. Provision Development Tools
+	toolsChecksum, err := r.provisionDevelopmentTools(ctx, devEnv)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
-	// 4. Setup IDE (VS Code Server)
-	if err := r.setupVSCodeServer(ctx, devEnv); err != nil {
-		return err
+	// 6. Adopt the volumes a deleted environment of the same owner retained
+	if err := r.adoptRetainedVolumes(ctx, devEnv); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	// 5. Configure Dependencies
-	if err := r.setupDatabase(ctx, devEnv); err != nil {
-		return err
+	// 7. Restore the volumes from a snapshot before anything mounts them
+	restored, err := r.restoreSnapshot(ctx, devEnv)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !restored {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 	}
 
-	return nil
+	// 8. Setup IDE (VS Code Server)
+	if err := r.setupVSCodeServer(ctx, devEnv, toolsChecksum); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// 9. Setup backing services
+	if err := r.setupServices(ctx, devEnv); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Come back for the next scheduled wake or suspend, or to check for activity
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-// Namespace creation
-func (r *DeveloperEnvironmentReconciler) ensureNamespace(
+func (r *DeveloperEnvironmentReconciler) provisionDevelopmentTools(
 	ctx context.Context,
 	devEnv *apiv1.DeveloperEnvironment,
-) error {
-	ns := fmt.Sprintf("devenv-%s", devEnv.Name)
-
-	// Check if the namespace exists
-	namespace := &corev1.Namespace{}
-	err := r.Get(ctx, client.ObjectKey{Name: ns}, namespace)
-	if err != nil && apierrors.IsNotFound(err) {
-		// Namespace does not exist, create it
-		namespace = &corev1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: ns,
-				Labels: map[string]string{
-					"managed-by":  "devenv-operator",
-					"environment": devEnv.Name,
-				},
-			},
+) (string, error) {
+	runtime, err := r.resolveRuntime(ctx, devEnv)
+	setCondition(devEnv, runtimeCondition(runtime, err))
+	if err != nil {
+		return "", err
+	}
+	devEnv.Status.Runtime = &runtime
+
+	if err := r.setupDependencyReporting(ctx, devEnv); err != nil {
+		return "", err
+	}
+
+	kind, err := ideKind(devEnv)
+	if err != nil {
+		return "", err
+	}
+	if len(devEnv.Spec.Dependencies) > 0 && !kind.Scripts {
+		return "", fmt.Errorf("the %s IDE cannot install dependencies at startup", devEnv.Spec.IDE.Type)
+	}
+
+	scripts := map[string]string{}
+	// Prebuilt images come with the toolchain, so the script is only needed as a fallback
+	if len(runtime.ScriptedLanguages) > 0 {
+		if scripts[installToolsScript], err = renderInstallScript(devEnv, runtime.ScriptedLanguages); err != nil {
+			return "", err
 		}
-		if err := r.Create(ctx, namespace); err != nil {
-			return fmt.Errorf("failed to create namespace: %w", err)
+	}
+	if len(devEnv.Spec.Dependencies) > 0 {
+		if scripts[installDependenciesScript], err = renderDependencyScript(devEnv); err != nil {
+			return "", err
 		}
-	} else if err != nil {
-		// An error occurred while checking for the namespace
-		return fmt.Errorf("failed to get namespace: %w", err)
 	}
-	return nil
+
+	// Create a ConfigMap to store the rendered installation scripts
+	toolsConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-dev-tools-scripts", devEnv.Name),
+			Namespace: environmentNamespace(devEnv),
+			Labels: map[string]string{
+				"developer-env":     devEnv.Name,
+				"developer-env-uid": string(devEnv.UID),
+				"app":               "development-tools",
+			},
+		},
+		Data: scripts,
+	}
+
+	if len(scripts) == 0 {
+		if err := r.Delete(ctx, toolsConfigMap); err != nil && !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to delete tools ConfigMap: %w", err)
+		}
+		return "", nil
+	}
+	if err := r.apply(ctx, devEnv, toolsConfigMap); err != nil {
+		return "", fmt.Errorf("failed to apply tools ConfigMap: %w", err)
+	}
+
+	// The scripts only run when the IDE starts, so a change to them has to roll it
+	return checksum(scripts), nil
 }
 
-func (r *DeveloperEnvironmentReconciler) provisionDevelopmentTools(
-	ctx context.Context,
-	devEnv *apiv1.DeveloperEnvironment,
-) error {
+// renderInstallScript renders the script installing the given language toolchains when the IDE starts
+func renderInstallScript(devEnv *apiv1.DeveloperEnvironment, languages []string) (string, error) {
 	// Create a template for the installation script
 	installScriptTemplate, err := template.New("install-tools").Parse(`#!/bin/bash
 echo $SUDO_PASSWORD | sudo -S -v
@@ -242,9 +370,22 @@ RUST_VERSION={{ if .Versions.Rust }}{{ .Versions.Rust }}{{ else }}stable{{ end }
 sudo curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh -s -- -y --default-toolchain ${RUST_VERSION}
 {{- end }}
 
-# Install additional tools specified in the environment
-{{- range .AdditionalTools }}
-sudo apt-get install -y {{ . }}
+{{- if .Languages.Java }}
+# Java: OpenJDK, Maven and Gradle
+JAVA_VERSION={{ if .Versions.Java }}{{ .Versions.Java }}{{ else }}21{{ end }}
+GRADLE_VERSION=8.10.2
+sudo apt-get install -y openjdk-${JAVA_VERSION}-jdk-headless maven unzip
+JAVA_HOME=/usr/lib/jvm/java-${JAVA_VERSION}-openjdk-$(dpkg --print-architecture)
+if [ ! -d /opt/gradle/gradle-${GRADLE_VERSION} ]; then
+    wget -q https://services.gradle.org/distributions/gradle-${GRADLE_VERSION}-bin.zip
+    sudo unzip -q -d /opt/gradle gradle-${GRADLE_VERSION}-bin.zip
+    rm gradle-${GRADLE_VERSION}-bin.zip
+fi
+sudo ln -sfn /opt/gradle/gradle-${GRADLE_VERSION} /opt/gradle/current
+grep -q '^export JAVA_HOME=' ~/.bashrc || cat >> ~/.bashrc <<PROFILE
+export JAVA_HOME=${JAVA_HOME}
+export PATH=\$PATH:\$JAVA_HOME/bin:/opt/gradle/current/bin
+PROFILE
 {{- end }}
 
 # Clean up
@@ -255,7 +396,7 @@ chmod 777 /config/workspace/lost+found
 echo "Development tools installation complete!"
 `)
 	if err != nil {
-		return fmt.Errorf("failed to parse installation script template: %w", err)
+		return "", fmt.Errorf("failed to parse installation script template: %w", err)
 	}
 	// Prepare the template data
 	type TemplateData struct {
@@ -264,138 +405,233 @@ echo "Development tools installation complete!"
 			NodeJS bool
 			Go     bool
 			Rust   bool
+			Java   bool
 		}
 		Versions struct {
 			Python string
 			NodeJS string
 			Go     string
 			Rust   string
+			Java   string
 		}
-		AdditionalTools []string
 	}
 
 	// Populate template data
-	templateData := TemplateData{
-		Languages: struct {
-			Python bool
-			NodeJS bool
-			Go     bool
-			Rust   bool
-		}{
-			Python: devEnv.Spec.Language == "python",
-			NodeJS: devEnv.Spec.Language == "nodejs",
-			Go:     devEnv.Spec.Language == "go",
-			Rust:   devEnv.Spec.Language == "rust",
-		},
-		Versions: struct {
-			Python string
-			NodeJS string
-			Go     string
-			Rust   string
-		}{
-			Python: devEnv.Spec.Version,
-			NodeJS: devEnv.Spec.Version,
-			Go:     devEnv.Spec.Version,
-			Rust:   devEnv.Spec.Version,
-		},
+	var templateData TemplateData
+	for _, language := range devEnv.Spec.EffectiveLanguages() {
+		if !slices.Contains(languages, language.Name) {
+			continue
+		}
+		switch language.Name {
+		case "python":
+			templateData.Languages.Python, templateData.Versions.Python = true, language.Version
+		case "nodejs":
+			templateData.Languages.NodeJS, templateData.Versions.NodeJS = true, language.Version
+		case "go":
+			templateData.Languages.Go, templateData.Versions.Go = true, language.Version
+		case "rust":
+			templateData.Languages.Rust, templateData.Versions.Rust = true, language.Version
+		case "java":
+			templateData.Languages.Java, templateData.Versions.Java = true, language.Version
+		}
 	}
 
 	// Render the script
 	var scriptContent bytes.Buffer
 	if err := installScriptTemplate.Execute(&scriptContent, templateData); err != nil {
-		return fmt.Errorf("failed to render installation script: %w", err)
+		return "", fmt.Errorf("failed to render installation script: %w", err)
 	}
+	return scriptContent.String(), nil
+}
 
-	// Create a ConfigMap to store the rendered installation script
-	toolsConfigMap := &corev1.ConfigMap{
+// workspacePVC returns the claim of the workspace volume, to be sized by applyPVC
+func workspacePVC(devEnv *apiv1.DeveloperEnvironment) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-dev-tools-scripts", devEnv.Name),
-			Namespace: devEnv.Namespace,
+			Name:      workspacePVCName(devEnv),
+			Namespace: environmentNamespace(devEnv),
 			Labels: map[string]string{
-				"developer-env":     devEnv.Name,
-				"developer-env-uid": string(devEnv.UID),
-				"app":               "development-tools",
+				"app":           "vscode-server",
+				"developer-env": devEnv.Name,
 			},
 		},
-		Data: map[string]string{
-			"install-tools.sh": scriptContent.String(),
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
 		},
 	}
-
-	// Create or update the ConfigMap
-	existingConfigMap := &corev1.ConfigMap{}
-	err = r.Get(ctx, types.NamespacedName{
-		Name:      toolsConfigMap.Name,
-		Namespace: toolsConfigMap.Namespace,
-	}, existingConfigMap)
-
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create the ConfigMap if it doesn't exist
-			if createErr := r.Create(ctx, toolsConfigMap); createErr != nil {
-				return fmt.Errorf("failed to create tools ConfigMap: %w", createErr)
-			}
-		} else {
-			return fmt.Errorf("failed to check existing ConfigMap: %w", err)
-		}
-	} else {
-		// Update existing ConfigMap
-		existingConfigMap.Data = toolsConfigMap.Data
-		existingConfigMap.Labels = toolsConfigMap.Labels
-		if updateErr := r.Update(ctx, existingConfigMap); updateErr != nil {
-			return fmt.Errorf("failed to update tools ConfigMap: %w", updateErr)
-		}
-	}
-
-	return nil
 }
 
 func (r *DeveloperEnvironmentReconciler) setupVSCodeServer(
 	ctx context.Context,
 	devEnv *apiv1.DeveloperEnvironment,
+	toolsChecksum string,
 ) error {
-	// Generate a unique name for the VS Code server resources
+	// Generate a unique name for the VS Code server resources. The IDE
+	// resources keep it whatever the IDE type, so the type can be changed.
 	vsCodeServerName := fmt.Sprintf("%s-vscode-server", devEnv.Name)
 
+	kind, err := ideKind(devEnv)
+	if err != nil {
+		return err
+	}
+	resources, err := r.ideResources(devEnv)
+	if err != nil {
+		return err
+	}
+	storage, err := r.workspaceStorage(devEnv)
+	if err != nil {
+		return err
+	}
+
 	// Create a PersistentVolumeClaim for workspace persistence
-	pvc := &corev1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-vscode-workspace", devEnv.Name),
-			Namespace: devEnv.Namespace,
-			Labels: map[string]string{
-				"app":           "vscode-server",
-				"developer-env": devEnv.Name,
+	if err := r.applyPVC(ctx, devEnv, workspacePVC(devEnv), storage); err != nil {
+		return fmt.Errorf("failed to apply VS Code workspace PVC: %w", err)
+	}
+
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "workspace",
+			MountPath: kind.workspacePath,
+		},
+	}
+	volumes := []corev1.Volume{
+		{
+			Name: "workspace",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: workspacePVCName(devEnv),
+				},
 			},
 		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{
-				corev1.ReadWriteOnce,
+	}
+
+	var postStart []string
+	if len(devEnv.Status.Runtime.ScriptedLanguages) > 0 {
+		postStart = append(postStart, "./config/tools/"+installToolsScript)
+	}
+	if len(devEnv.Spec.Dependencies) > 0 {
+		postStart = append(postStart, "./config/tools/"+installDependenciesScript)
+	}
+	if toolsChecksum != "" {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "tools-script",
+			MountPath: "/config/tools",
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: "tools-script",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: fmt.Sprintf("%s-dev-tools-scripts", devEnv.Name),
+					},
+					DefaultMode: Ptr(int32(0777)),
+				},
 			},
-			Resources: corev1.VolumeResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse("10Gi"),
+		})
+	}
+
+	passwordChecksum, err := r.setupIDEPassword(ctx, devEnv)
+	if err != nil {
+		return err
+	}
+
+	// Settings are merged into the user settings by a sidecar, or mounted
+	// where the IDE reads them at startup
+	settings, err := r.setupIDESettings(ctx, devEnv, kind)
+	if err != nil {
+		return err
+	}
+	var sidecars []corev1.Container
+	settingsChecksum := ""
+	if settings != "" {
+		volumes = append(volumes, settingsVolume(devEnv))
+		if kind.syncSettings {
+			volumeMounts = append(volumeMounts, userSettingsMount(kind))
+			sidecars = append(sidecars, settingsSyncContainer(devEnv, kind))
+		} else {
+			volumeMounts = append(volumeMounts, corev1.VolumeMount{
+				Name:      "ide-settings",
+				MountPath: kind.settingsPath,
+				SubPath:   ideSettingsFile,
+				ReadOnly:  true,
+			})
+			settingsChecksum = checksum(map[string]string{ideSettingsFile: settings})
+		}
+	}
+
+	// Dependency results are reported by a sidecar, since the IDE is not given
+	// the token of its service account
+	if len(devEnv.Spec.Dependencies) > 0 {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "dependency-results",
+			MountPath: dependencyResultsPath,
+		})
+		volumes = append(volumes, dependencyVolumes()...)
+		sidecars = append(sidecars, dependencyReporterContainer(devEnv))
+	}
+
+	// Clone spec.repositories before the IDE starts
+	credentials, err := r.setupGitCredentials(ctx, devEnv)
+	if err != nil {
+		return err
+	}
+	var initContainers []corev1.Container
+	if len(devEnv.Spec.Repositories) > 0 {
+		script, err := renderCloneScript(devEnv)
+		if err != nil {
+			return err
+		}
+		initContainers = append(initContainers, cloneContainer(script, credentials))
+	}
+	if credentials {
+		volumes = append(volumes, corev1.Volume{
+			Name: "git-credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  gitCredentialsSecretName(devEnv),
+					DefaultMode: Ptr(int32(0400)),
 				},
 			},
-		},
+		})
 	}
 
-	// Create or update the PVC
-	if err := r.Create(ctx, pvc); err != nil {
-		if !errors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create VS Code workspace PVC: %w", err)
+	// Install spec.ide.extensions before the IDE starts, from the .vsix files
+	// of spec.ide.vsix or from the registry
+	registryEnv, err := r.extensionRegistryEnv(devEnv, kind)
+	if err != nil {
+		return err
+	}
+	vsixChecksum, err := r.setupVSIX(ctx, devEnv, kind)
+	if err != nil {
+		return err
+	}
+	if len(devEnv.Spec.IDE.Extensions) > 0 && kind.installExtension != "" {
+		script, err := renderExtensionsScript(devEnv, kind, vsixChecksum != "")
+		if err != nil {
+			return err
+		}
+		if vsixChecksum != "" {
+			volumes = append(volumes, vsixVolume(devEnv))
+			if devEnv.Spec.IDE.VSIX.S3 != nil {
+				initContainers = append(initContainers, vsixDownloadContainer(devEnv))
+			}
 		}
+		initContainers = append(initContainers, extensionsContainer(devEnv, kind, script, registryEnv, vsixChecksum != ""))
+		volumeMounts = append(volumeMounts, extensionsMount(kind))
 	}
 
-	installExtensionCommand := &corev1.Lifecycle{}
-	if len(devEnv.Spec.IDE.Extensions) > 0 {
-		installExtensionCommand = &corev1.Lifecycle{
+	// The tools and dependency scripts run once the IDE has started
+	postStartHook := &corev1.Lifecycle{}
+	if len(postStart) > 0 {
+		postStartHook = &corev1.Lifecycle{
 			PostStart: &corev1.LifecycleHandler{
 				Exec: &corev1.ExecAction{
 					Command: []string{
 						"/bin/bash",
 						"-c",
-						fmt.Sprintf("./config/tools/install-tools.sh && ./app/code-server/bin/code-server --extensions-dir /config/extensions --install-extension %s",
-							strings.Join(devEnv.Spec.IDE.Extensions, " --install-extension ")),
+						strings.Join(postStart, " && "),
 					},
 				},
 			},
@@ -403,10 +639,11 @@ func (r *DeveloperEnvironmentReconciler) setupVSCodeServer(
 	}
 
 	// Create a deployment for the VS Code server
+	readiness, liveness := ideProbes(kind)
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      vsCodeServerName,
-			Namespace: devEnv.Namespace,
+			Namespace: environmentNamespace(devEnv),
 			Labels: map[string]string{
 				"app":               "vscode-server",
 				"developer-env":     devEnv.Name,
@@ -414,7 +651,7 @@ func (r *DeveloperEnvironmentReconciler) setupVSCodeServer(
 			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: Ptr(int32(1)),
+			Replicas: desiredReplicas(devEnv),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app":           "vscode-server",
@@ -427,127 +664,56 @@ func (r *DeveloperEnvironmentReconciler) setupVSCodeServer(
 						"app":           "vscode-server",
 						"developer-env": devEnv.Name,
 					},
+					Annotations: map[string]string{
+						annotationToolsChecksum:    toolsChecksum,
+						annotationPasswordChecksum: passwordChecksum,
+						annotationSettingsChecksum: settingsChecksum,
+						annotationVSIXChecksum:     vsixChecksum,
+					},
 				},
 				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
+					ServiceAccountName:           ideServiceAccountName(devEnv),
+					AutomountServiceAccountToken: Ptr(false),
+					InitContainers:               initContainers,
+					Containers: append([]corev1.Container{
 						{
 							Name:            "vscode-server",
-							Image:           "linuxserver/code-server:4.95.3",
+							Image:           devEnv.Status.Runtime.Image,
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Ports: []corev1.ContainerPort{
 								{
 									Name:          "http",
-									ContainerPort: 8443,
-								},
-							},
-							Env: []corev1.EnvVar{
-								{
-									Name:  "PUID",
-									Value: "1000",
-								},
-								{
-									Name:  "PGID",
-									Value: "1000",
-								},
-								{
-									Name: "PASSWORD",
-									ValueFrom: &corev1.EnvVarSource{
-										SecretKeyRef: &corev1.SecretKeySelector{
-											LocalObjectReference: corev1.LocalObjectReference{
-												Name: fmt.Sprintf("%s-vscode-password", devEnv.Name),
-											},
-											Key: "password",
-										},
-									},
-								},
-								{
-									Name: "SUDO_PASSWORD",
-									ValueFrom: &corev1.EnvVarSource{
-										SecretKeyRef: &corev1.SecretKeySelector{
-											LocalObjectReference: corev1.LocalObjectReference{
-												Name: fmt.Sprintf("%s-vscode-password", devEnv.Name),
-											},
-											Key: "password",
-										},
-									},
+									ContainerPort: kind.port,
 								},
 							},
+							ReadinessProbe: readiness,
+							LivenessProbe:  liveness,
+							Env: append(append(kind.env(idePasswordSecretName(devEnv)), registryEnv...),
+								append(gitIdentityEnv(devEnv), serviceEnv(devEnv)...)...),
 
-							Lifecycle: installExtensionCommand,
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "workspace",
-									MountPath: "/config/workspace",
-								},
-								{
-									Name:      "tools-script",
-									MountPath: "/config/tools",
-								},
-							},
-							Resources: corev1.ResourceRequirements{
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("500m"),
-									corev1.ResourceMemory: resource.MustParse("512Mi"),
-								},
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("1"),
-									corev1.ResourceMemory: resource.MustParse("1Gi"),
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "workspace",
-							VolumeSource: corev1.VolumeSource{
-								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: fmt.Sprintf("%s-vscode-workspace", devEnv.Name),
-								},
-							},
-						},
-						{
-							Name: "tools-script",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: fmt.Sprintf("%s-dev-tools-scripts", devEnv.Name),
-									},
-									DefaultMode: Ptr(int32(0777)),
-								},
-							},
+							Lifecycle:    postStartHook,
+							VolumeMounts: volumeMounts,
+							Resources:    resources,
 						},
-					},
+					}, sidecars...),
+					Volumes: volumes,
 				},
 			},
 		},
 	}
+	if kind.fsGroup != nil {
+		deployment.Spec.Template.Spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: kind.fsGroup}
+	}
 
-	// Create or update the deployment
-	if err := r.Create(ctx, deployment); err != nil {
-		if !errors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create VS Code server deployment: %w", err)
-		}
-
-		// If already exists, update the deployment
-		existingDeployment := &appsv1.Deployment{}
-		if getErr := r.Get(ctx, types.NamespacedName{
-			Name:      vsCodeServerName,
-			Namespace: devEnv.Namespace,
-		}, existingDeployment); getErr != nil {
-			return fmt.Errorf("failed to get existing VS Code server deployment: %w", getErr)
-		}
-
-		existingDeployment.Spec = deployment.Spec
-		if updateErr := r.Update(ctx, existingDeployment); updateErr != nil {
-			return fmt.Errorf("failed to update VS Code server deployment: %w", updateErr)
-		}
+	if err := r.apply(ctx, devEnv, deployment); err != nil {
+		return fmt.Errorf("failed to apply VS Code server deployment: %w", err)
 	}
 
 	// Create a service to expose the VS Code server
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      vsCodeServerName,
-			Namespace: devEnv.Namespace,
+			Namespace: environmentNamespace(devEnv),
 			Labels: map[string]string{
 				"app":           "vscode-server",
 				"developer-env": devEnv.Name,
@@ -561,76 +727,52 @@ func (r *DeveloperEnvironmentReconciler) setupVSCodeServer(
 			Ports: []corev1.ServicePort{
 				{
 					Name:       "http",
-					Port:       8443,
-					TargetPort: intstr.FromInt(8443),
+					Port:       kind.port,
+					TargetPort: intstr.FromInt32(kind.port),
 				},
 			},
 			Type: corev1.ServiceTypeClusterIP,
 		},
 	}
 
-	// Create or update the service
-	if err := r.Create(ctx, service); err != nil {
-		if !errors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create VS Code server service: %w", err)
-		}
-
-		// If already exists, update the service
-		existingService := &corev1.Service{}
-		if getErr := r.Get(ctx, types.NamespacedName{
-			Name:      vsCodeServerName,
-			Namespace: devEnv.Namespace,
-		}, existingService); getErr != nil {
-			return fmt.Errorf("failed to get existing VS Code server service: %w", getErr)
-		}
-
-		existingService.Spec = service.Spec
-		if updateErr := r.Update(ctx, existingService); updateErr != nil {
-			return fmt.Errorf("failed to update VS Code server service: %w", updateErr)
-		}
+	if err := r.apply(ctx, devEnv, service); err != nil {
+		return fmt.Errorf("failed to apply VS Code server service: %w", err)
 	}
 
-	secretName := fmt.Sprintf("%s-vscode-password", devEnv.Name)
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      secretName,
-			Namespace: devEnv.Namespace,
-			Labels: map[string]string{
-				"app":           "vscode-server",
-				"developer-env": devEnv.Name,
+	ingressClass := r.IngressClass
+	ingressName := fmt.Sprintf("%s-vscode-ingress", devEnv.Name)
+	// The certificate is set up by setupCertificates rather than requested
+	// from the ingress-shim of cert-manager
+	annotations := map[string]string{
+		"kubernetes.io/ingress.class": ingressClass,
+	}
+	var ingressTLS []networkingv1.IngressTLS
+	if secretName := r.tlsSecretName(devEnv); secretName != "" {
+		annotations["nginx.ingress.kubernetes.io/force-ssl-redirect"] = "true"
+		ingressTLS = []networkingv1.IngressTLS{
+			{
+				Hosts: []string{
+					r.ideHost(devEnv),
+				},
+				SecretName: secretName,
 			},
-		},
-		StringData: map[string]string{
-			"password": devEnv.Spec.IDE.PasswordSecret,
-		},
-	}
-
-	if err := r.Create(ctx, secret); err != nil {
-		if !errors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create VS Code server secret: %w", err)
 		}
 	}
-	ingressClass := r.IngressClass
-	ingressName := fmt.Sprintf("%s-vscode-ingress", devEnv.Name)
 	ingress := &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      ingressName,
-			Namespace: devEnv.Namespace,
+			Namespace: environmentNamespace(devEnv),
 			Labels: map[string]string{
 				"app":           "vscode-server",
 				"developer-env": devEnv.Name,
 			},
-			Annotations: map[string]string{
-				"cert-manager.io/issuer":                         "selfsigned-cluster-issuer",
-				"kubernetes.io/ingress.class":                    ingressClass,
-				"nginx.ingress.kubernetes.io/force-ssl-redirect": "true",
-			},
+			Annotations: annotations,
 		},
 		Spec: networkingv1.IngressSpec{
 			IngressClassName: &ingressClass,
 			Rules: []networkingv1.IngressRule{
 				{
-					Host: fmt.Sprintf("%s.%s", devEnv.Name, r.ResourceURL),
+					Host: r.ideHost(devEnv),
 					IngressRuleValue: networkingv1.IngressRuleValue{
 						HTTP: &networkingv1.HTTPIngressRuleValue{
 							Paths: []networkingv1.HTTPIngressPath{
@@ -641,7 +783,7 @@ func (r *DeveloperEnvironmentReconciler) setupVSCodeServer(
 										Service: &networkingv1.IngressServiceBackend{
 											Name: fmt.Sprintf("%s-vscode-server", devEnv.Name),
 											Port: networkingv1.ServiceBackendPort{
-												Number: 8443,
+												Number: kind.port,
 											},
 										},
 									},
@@ -651,406 +793,74 @@ func (r *DeveloperEnvironmentReconciler) setupVSCodeServer(
 					},
 				},
 			},
-			TLS: []networkingv1.IngressTLS{
-				{
-					Hosts: []string{
-						fmt.Sprintf("%s.%s", devEnv.Name, r.ResourceURL),
-					},
-					SecretName: fmt.Sprintf("%s.%s", devEnv.Name, r.ResourceURL),
-				},
-			},
+			TLS: ingressTLS,
 		},
 	}
 
-	if err := r.Create(ctx, ingress); err != nil {
-		if !errors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create VS Code server ingress: %w", err)
-		}
-
-		// If already exists, update the ingress
-		existingIngress := &networkingv1.Ingress{}
-		if getErr := r.Get(ctx, types.NamespacedName{
-			Name:      ingressName,
-			Namespace: devEnv.Namespace,
-		}, existingIngress); getErr != nil {
-			return fmt.Errorf("failed to get existing VS Code server ingress: %w", getErr)
-		}
-
-		existingIngress.Spec = ingress.Spec
-		if updateErr := r.Update(ctx, existingIngress); updateErr != nil {
-			return fmt.Errorf("failed to update VS Code server ingress: %w", updateErr)
-		}
+	if err := r.apply(ctx, devEnv, ingress); err != nil {
+		return fmt.Errorf("failed to apply VS Code server ingress: %w", err)
 	}
 
 	return nil
 }
 
-func (r *DeveloperEnvironmentReconciler) setupDatabase(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
-	dbType := devEnv.Spec.Database.Type
-	dbVersion := devEnv.Spec.Database.Version
-	dbName := fmt.Sprintf("%s-database", devEnv.Name)
-
-	var containerPorts []corev1.ContainerPort
-	var envVars []corev1.EnvVar
-	var volumeMounts []corev1.VolumeMount
-	var volumes []corev1.Volume
+// ideHost is the public host name the IDE is served at
+func (r *DeveloperEnvironmentReconciler) ideHost(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s.%s", devEnv.Name, r.ResourceURL)
+}
 
-	switch dbType {
-	case "postgres":
-		containerPorts = []corev1.ContainerPort{
-			{
-				Name:          "db",
-				ContainerPort: 5432,
-			},
-		}
-		envVars = []corev1.EnvVar{
-			{
-				Name:  "POSTGRES_DB",
-				Value: "postgres",
-			},
-			{
-				Name:  "POSTGRES_USER",
-				Value: "postgres",
-			},
-			{
-				Name:  "POSTGRES_PASSWORD",
-				Value: "postgres",
-			},
-			{
-				Name:  "PGDATA",
-				Value: "/var/lib/postgresql/data/pgdata",
-			},
-		}
-		volumeMounts = []corev1.VolumeMount{
-			{
-				Name:      "db-data",
-				MountPath: "/var/lib/postgresql/data",
-			},
-		}
-		volumes = []corev1.Volume{
-			{
-				Name: "db-data",
-				VolumeSource: corev1.VolumeSource{
-					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-						ClaimName: fmt.Sprintf("%s-db-pvc", devEnv.Name),
-					},
-				},
-			},
-		}
-	case "redis":
-		containerPorts = []corev1.ContainerPort{
-			{
-				Name:          "db",
-				ContainerPort: 6379,
-			},
-		}
-		envVars = []corev1.EnvVar{
-			{
-				Name:  "REDIS_PASSWORD",
-				Value: "password",
-			},
-		}
-		volumeMounts = []corev1.VolumeMount{
-			{
-				Name:      "db-data",
-				MountPath: "/data",
-			},
-		}
-		volumes = []corev1.Volume{
-			{
-				Name: "db-data",
-				VolumeSource: corev1.VolumeSource{
-					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-						ClaimName: fmt.Sprintf("%s-db-pvc", devEnv.Name),
-					},
-				},
-			},
-		}
-	}
-
-	// Define the database PVC
-	pvc := &corev1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-db-pvc", devEnv.Name),
-			Namespace: devEnv.Namespace,
-			Labels: map[string]string{
-				"app":           "database",
-				"developer-env": devEnv.Name,
-			},
-		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{
-				corev1.ReadWriteOnce,
-			},
-			Resources: corev1.VolumeResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse("10Gi"),
-				},
-			},
-		},
+// accessURL is the URL the IDE is served at, over plain HTTP in the None TLS mode
+func (r *DeveloperEnvironmentReconciler) accessURL(devEnv *apiv1.DeveloperEnvironment) string {
+	if r.tlsSecretName(devEnv) == "" {
+		return fmt.Sprintf("http://%s", r.ideHost(devEnv))
 	}
+	return fmt.Sprintf("https://%s", r.ideHost(devEnv))
+}
 
-	// Check if the PVC already exists
-	existingPVC := &corev1.PersistentVolumeClaim{}
-	err := r.Get(ctx, types.NamespacedName{
-		Name:      pvc.Name,
-		Namespace: pvc.Namespace,
-	}, existingPVC)
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			// PVC does not exist, create it
-			if createErr := r.Create(ctx, pvc); createErr != nil {
-				return fmt.Errorf("failed to create database PVC: %w", createErr)
+// finalizeDeveloperEnvironment deletes the environment, keeping its volumes as
+// spec.deletionPolicy asks. It reports false while the snapshot of the
+// Snapshot policy is being taken.
+func (r *DeveloperEnvironmentReconciler) finalizeDeveloperEnvironment(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) (bool, error) {
+	switch devEnv.Spec.DeletionPolicy {
+	case apiv1.DeletionPolicyRetain:
+		if err := r.retainVolumes(ctx, devEnv); err != nil {
+			return false, err
+		}
+	case apiv1.DeletionPolicySnapshot:
+		phase, err := r.takeDeletionSnapshot(ctx, devEnv)
+		if err != nil {
+			return false, err
+		}
+		switch phase {
+		case apiv1.SnapshotPhaseReady:
+		case apiv1.SnapshotPhaseFailed:
+			// Rather keep the volumes than lose the work in them
+			if err := r.retainVolumes(ctx, devEnv); err != nil {
+				return false, err
 			}
-		} else {
-			return fmt.Errorf("failed to check existing PVC: %w", err)
+		default:
+			return false, nil
 		}
 	}
 
-	// Define the database deployment
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      dbName,
-			Namespace: devEnv.Namespace,
-			Labels: map[string]string{
-				"app":           "database",
-				"developer-env": devEnv.Name,
-			},
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: Ptr(int32(1)),
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app":           "database",
-					"developer-env": devEnv.Name,
-				},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app":           "database",
-						"developer-env": devEnv.Name,
-					},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:         "database",
-							Image:        fmt.Sprintf("%s:%s", dbType, dbVersion),
-							Ports:        containerPorts,
-							Env:          envVars,
-							VolumeMounts: volumeMounts,
-						},
-					},
-					Volumes: volumes,
-				},
-			},
-		},
+	// Delete the dedicated namespace together with everything in it
+	if err := r.deleteEnvironmentNamespace(ctx, devEnv, environmentNamespace(devEnv)); err != nil {
+		return false, err
 	}
 
-	// Create or update the deployment
-	if err := r.Create(ctx, deployment); err != nil {
-		if !errors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create database deployment: %w", err)
-		}
-
-		// If already exists, update the deployment
-		existingDeployment := &appsv1.Deployment{}
-		if getErr := r.Get(ctx, types.NamespacedName{
-			Name:      dbName,
-			Namespace: devEnv.Namespace,
-		}, existingDeployment); getErr != nil {
-			return fmt.Errorf("failed to get existing database deployment: %w", getErr)
-		}
-
-		existingDeployment.Spec = deployment.Spec
-		if updateErr := r.Update(ctx, existingDeployment); updateErr != nil {
-			return fmt.Errorf("failed to update database deployment: %w", updateErr)
-		}
+	// Earlier versions created an unused devenv-<name> namespace for every environment
+	if err := r.deleteEnvironmentNamespace(ctx, devEnv, dedicatedNamespaceName(devEnv)); err != nil {
+		return false, err
 	}
 
-	// Define the database service
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      dbName,
-			Namespace: devEnv.Namespace,
-			Labels: map[string]string{
-				"app":           "database",
-				"developer-env": devEnv.Name,
-			},
-		},
-		Spec: corev1.ServiceSpec{
-			Selector: map[string]string{
-				"app":           "database",
-				"developer-env": devEnv.Name,
-			},
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "db",
-					Port:       containerPorts[0].ContainerPort,
-					TargetPort: intstr.FromInt32(containerPorts[0].ContainerPort),
-				},
-			},
-			Type: corev1.ServiceTypeClusterIP,
-		},
-	}
-
-	// Create or update the service
-	if err := r.Create(ctx, service); err != nil {
-		if !errors.IsAlreadyExists(err) {
-			return fmt.Errorf("failed to create database service: %w", err)
-		}
-
-		// If already exists, update the service
-		existingService := &corev1.Service{}
-		if getErr := r.Get(ctx, types.NamespacedName{
-			Name:      dbName,
-			Namespace: devEnv.Namespace,
-		}, existingService); getErr != nil {
-			return fmt.Errorf("failed to get existing database service: %w", getErr)
-		}
-
-		existingService.Spec = service.Spec
-		if updateErr := r.Update(ctx, existingService); updateErr != nil {
-			return fmt.Errorf("failed to update database service: %w", updateErr)
-		}
+	if err := r.deleteRestoredContents(ctx, devEnv); err != nil {
+		return false, err
 	}
 
-	return nil
-}
-
-// Update status of the DevEnv resource
-func (r *DeveloperEnvironmentReconciler) updateStatus(
-	ctx context.Context,
-	devEnv *apiv1.DeveloperEnvironment,
-) error {
-	devEnv.Status.Phase = "Ready"
-	devEnv.Status.LastUpdated = metav1.Now()
-
-	return r.Status().Update(ctx, devEnv)
+	// Child objects in the CR namespace are owned by the DeveloperEnvironment and garbage collected with it
+	return true, nil
 }
 
-func (r *DeveloperEnvironmentReconciler) finalizeDeveloperEnvironment(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
-	// Delete Namespace
-	namespace := &corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: fmt.Sprintf("devenv-%s", devEnv.Name),
-		},
-	}
-	if err := r.Delete(ctx, namespace); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete namespace: %w", err)
-	}
-
-	// Delete Deployment
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-vscode-server", devEnv.Name),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, deployment); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete deployment: %w", err)
-	}
-
-	// Delete Service
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-vscode-server", devEnv.Name),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, service); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete service: %w", err)
-	}
-
-	// Delete Secret
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-vscode-password", devEnv.Name),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete secret: %w", err)
-	}
-
-	// Delete Ingress
-	ingress := &networkingv1.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-vscode-ingress", devEnv.Name),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, ingress); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete ingress: %w", err)
-	}
-
-	// Delete Tools configmap
-	configMap := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-dev-tools-scripts", devEnv.Name),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, configMap); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete tools configmap: %w", err)
-	}
-
-	pvc := &corev1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-vscode-workspace", devEnv.Name),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, pvc); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete pvc: %w", err)
-	}
-	// Delete Issuer
-	issuer := &certmanagerv1.Issuer{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "selfsigned-cluster-issuer",
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, issuer); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete issuer: %w", err)
-	}
-
-	// Delete Certificate
-	certificate := &certmanagerv1.Certificate{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s.%s", devEnv.Name, r.ResourceURL),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, certificate); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete certificate: %w", err)
-	}
-
-	// Delete Database Deployment
-	dbDeployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-database", devEnv.Name),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, dbDeployment); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete database deployment: %w", err)
-	}
-
-	// Delete Database Service
-	dbService := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-database", devEnv.Name),
-			Namespace: devEnv.Namespace,
-		},
-	}
-	if err := r.Delete(ctx, dbService); err != nil && !apierrors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete database service: %w", err)
-	}
-
-	return nil
-}
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -1075,72 +885,109 @@ func (r *DeveloperEnvironmentReconciler) SetupWithManager(mgr ctrl.Manager) erro
 	if err := certmanagerv1.AddToScheme(mgr.GetScheme()); err != nil {
 		return err
 	}
-	return ctrl.NewControllerManagedBy(mgr).
+	// Child objects next to their environment are owned by it. Those in a
+	// dedicated namespace cannot be, as owner references cannot cross
+	// namespaces, so they are mapped back through their labels.
+	enqueueEnvironment := handler.EnqueueRequestsFromMapFunc(r.environmentForObject)
+	inOtherNamespace := builder.WithPredicates(predicate.NewPredicateFuncs(inOtherNamespace))
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&apiv1.DeveloperEnvironment{}).
+		Watches(&corev1.Namespace{}, enqueueEnvironment)
+	for _, obj := range watchedChildren() {
+		b = b.Owns(obj).Watches(obj, enqueueEnvironment, inOtherNamespace)
+	}
+	return b.
+		Watches(&apiv1.LanguageRuntime{}, handler.EnqueueRequestsFromMapFunc(r.environmentsForRuntime)).
+		Watches(&apiv1.DeveloperEnvironmentSnapshot{}, handler.EnqueueRequestsFromMapFunc(r.environmentsForSnapshot)).
 		Complete(r)
 }
 
-func (r *DeveloperEnvironmentReconciler) setupCertificates(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
-	// Check if the Issuer exists
-	selfSignedClusterIssuerName := "selfsigned-cluster-issuer"
-	existingIssuer := &certmanagerv1.Issuer{}
-	err := r.Get(ctx, client.ObjectKey{Name: selfSignedClusterIssuerName, Namespace: devEnv.Namespace}, existingIssuer)
-	if err != nil && apierrors.IsNotFound(err) {
-		// Issuer does not exist, create it
-		issuer := &certmanagerv1.Issuer{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      selfSignedClusterIssuerName,
-				Namespace: devEnv.Namespace,
-			},
-			Spec: certmanagerv1.IssuerSpec{
-				IssuerConfig: certmanagerv1.IssuerConfig{
-					SelfSigned: &certmanagerv1.SelfSignedIssuer{},
-				},
-			},
-		}
-		if err := r.Create(ctx, issuer); err != nil {
-			return fmt.Errorf("failed to create Issuer: %w", err)
-		}
-	} else if err != nil {
-		// An error occurred while checking for the Issuer
-		return fmt.Errorf("failed to get Issuer: %w", err)
-	}
-
-	// Check if the Certificate exists
-	existingCertificate := &certmanagerv1.Certificate{}
-	certificateName := fmt.Sprintf("%s.%s", devEnv.Name, r.ResourceURL)
-	err = r.Get(ctx, client.ObjectKey{Name: certificateName, Namespace: devEnv.Namespace}, existingCertificate)
-	if err != nil && apierrors.IsNotFound(err) {
-		// Certificate does not exist, create it
-		certificate := &certmanagerv1.Certificate{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      certificateName,
-				Namespace: devEnv.Namespace,
-			},
-			Spec: certmanagerv1.CertificateSpec{
-				IsCA:       true,
-				CommonName: certificateName,
-				SecretName: certificateName,
-				PrivateKey: &certmanagerv1.CertificatePrivateKey{
-					Algorithm: certmanagerv1.ECDSAKeyAlgorithm,
-					Size:      256,
-				},
-				IssuerRef: cmmeta.ObjectReference{
-					Name:  selfSignedClusterIssuerName,
-					Kind:  certmanagerv1.IssuerKind,
-					Group: certmanagerv1.SchemeGroupVersion.Group,
-				},
-			},
-		}
-		if err := r.Create(ctx, certificate); err != nil {
-			return fmt.Errorf("failed to create Certificate: %w", err)
+// watchedChildren are the kinds of child objects whose changes reconcile their environment
+func watchedChildren() []client.Object {
+	return []client.Object{
+		&corev1.ConfigMap{},
+		&corev1.PersistentVolumeClaim{},
+		&corev1.Secret{},
+		&corev1.Service{},
+		&appsv1.Deployment{},
+		&appsv1.StatefulSet{},
+		&batchv1.Job{},
+		&networkingv1.Ingress{},
+		&networkingv1.NetworkPolicy{},
+		&corev1.ResourceQuota{},
+		&corev1.LimitRange{},
+		&certmanagerv1.Issuer{},
+		&certmanagerv1.Certificate{},
+	}
+}
+
+// CacheByObject limits the cache of the kinds of child objects to those the
+// operator labeled as managed, instead of every such object of the cluster.
+// Objects of the user are read through the APIReader of the reconcilers.
+func CacheByObject() map[client.Object]cache.ByObject {
+	managed := cache.ByObject{Label: labels.SelectorFromSet(labels.Set{labelManagedBy: managedBy})}
+	byObject := map[client.Object]cache.ByObject{
+		&corev1.ServiceAccount{}: managed,
+		&rbacv1.Role{}:           managed,
+		&rbacv1.RoleBinding{}:    managed,
+	}
+	for _, obj := range watchedChildren() {
+		byObject[obj] = managed
+	}
+	return byObject
+}
+
+// inOtherNamespace tells whether a child object lives in another namespace than its environment
+func inOtherNamespace(obj client.Object) bool {
+	ns := obj.GetLabels()[labelEnvironmentNamespace]
+	return ns != "" && ns != obj.GetNamespace()
+}
+
+// getChild reads a child object from the cache, falling back to the API
+// server for objects created by earlier versions, which are not labeled as
+// managed until they are applied again
+func (r *DeveloperEnvironmentReconciler) getChild(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	err := r.Get(ctx, key, obj)
+	if apierrors.IsNotFound(err) {
+		return r.APIReader.Get(ctx, key, obj)
+	}
+	return err
+}
+
+// apply reconciles a child object with server-side apply. Objects are labeled
+// with their environment and as managed so that they are cached and watched,
+// and objects in the CR namespace are also owned by it so that they are
+// garbage collected with it.
+func (r *DeveloperEnvironmentReconciler) apply(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	obj client.Object,
+) error {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[labelEnvironment] = devEnv.Name
+	labels[labelEnvironmentNamespace] = devEnv.Namespace
+	labels[labelManagedBy] = managedBy
+	obj.SetLabels(labels)
+
+	if obj.GetNamespace() == devEnv.Namespace {
+		if err := controllerutil.SetControllerReference(devEnv, obj, r.Scheme); err != nil {
+			return err
 		}
-	} else if err != nil {
-		// An error occurred while checking for the Certificate
-		return fmt.Errorf("failed to get Certificate: %w", err)
 	}
-	return nil
+	return r.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
 }
+
 func Ptr[T any](v T) *T {
 	return &v
 }
@@ -0,0 +1,327 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+const (
+	// extensionsContainerName is the init container installing spec.ide.extensions
+	extensionsContainerName = "install-extensions"
+	// extensionsSubPath keeps the installed extensions on the workspace
+	// volume, where the IDE finds those the init container installed
+	extensionsSubPath = ".devenv/extensions"
+	// vsixPath is where the extensions init container finds the .vsix files
+	vsixPath = "/vsix"
+)
+
+// annotationVSIXChecksum rolls the IDE when the .vsix files change, so the
+// extensions are installed from the new files
+const annotationVSIXChecksum = "devenv.adityajoshi.online/vsix-checksum"
+
+// extensionsScriptTemplate installs every extension, from its .vsix file
+// when there is one, trying three times before giving up on it. Like the
+// clone script, it reports the result of each extension through the
+// termination message of the container, and never fails the container, so
+// that a broken extension does not keep the IDE from starting.
+var extensionsScriptTemplate = template.Must(template.New("install-extensions").Parse(`set -u
+results=/dev/termination-log
+echo "checksum {{ .Checksum }}" > $results
+
+# install <index> <name> <version>
+install() {
+    local index=$1 name=$2 version=$3 file= source=Registry attempt log=/tmp/extension-$1.log
+{{- if .VSIX }}
+    if [ -n "$version" ]; then
+        [ -f "{{ .VSIX }}/$name-$version.vsix" ] && file={{ .VSIX }}/$name-$version.vsix
+    else
+        file=$(ls "{{ .VSIX }}/$name"-*.vsix 2>/dev/null | sort -V | tail -n 1)
+    fi
+    [ -n "$file" ] && source=VSIX
+{{- end }}
+    for attempt in 1 2 3; do
+        if { {{ .Install }}; } > $log 2>&1; then
+            cat $log
+            echo "$index $source" >> $results
+            return
+        fi
+        cat $log
+        [ $attempt -lt 3 ] && sleep $((attempt * 5))
+    done
+    echo "$index ! $(tail -n 1 $log | cut -c 1-120)" >> $results
+}
+{{ range .Extensions }}
+install {{ .Index }} {{ .Name }} {{ .Version }}
+{{- end }}
+chown -R {{ .Owner }} {{ .Path }}
+exit 0
+`))
+
+// vsixName names the copy of the .vsix files, or of the credentials of their bucket, next to the IDE
+func vsixName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-vsix", devEnv.Name)
+}
+
+// parseExtension splits an extension into its name and pinned version, if any
+func parseExtension(extension string) (string, string) {
+	name, version, _ := strings.Cut(extension, "@")
+	return name, version
+}
+
+// extensionChecksum identifies an extension list, so results can be matched to it
+func extensionChecksum(extensions []string) string {
+	data := map[string]string{}
+	for i, extension := range extensions {
+		data[strconv.Itoa(i)] = extension
+	}
+	return checksum(data)
+}
+
+// renderExtensionsScript renders the script of the extensions init container
+func renderExtensionsScript(devEnv *apiv1.DeveloperEnvironment, kind ideType, vsix bool) (string, error) {
+	type extension struct {
+		Index, Name, Version string
+	}
+	data := struct {
+		Checksum   string
+		VSIX       string
+		Install    string
+		Owner      string
+		Path       string
+		Extensions []extension
+	}{
+		Checksum: extensionChecksum(devEnv.Spec.IDE.Extensions),
+		Install:  kind.installExtension,
+		Owner:    kind.owner,
+		Path:     kind.extensionsPath,
+	}
+	if vsix {
+		data.VSIX = vsixPath
+	}
+	for i, ext := range devEnv.Spec.IDE.Extensions {
+		name, version := parseExtension(ext)
+		data.Extensions = append(data.Extensions, extension{
+			Index:   strconv.Itoa(i),
+			Name:    shellQuote(name),
+			Version: shellQuote(version),
+		})
+	}
+
+	var script bytes.Buffer
+	if err := extensionsScriptTemplate.Execute(&script, data); err != nil {
+		return "", fmt.Errorf("failed to render extensions script: %w", err)
+	}
+	return script.String(), nil
+}
+
+// setupVSIX copies the .vsix files of spec.ide.vsix, or the credentials of
+// their bucket, next to the IDE, which may live in another namespace than
+// the environment. It returns the checksum of the files, or nothing when
+// the extensions are not installed from .vsix files.
+func (r *DeveloperEnvironmentReconciler) setupVSIX(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	kind ideType,
+) (string, error) {
+	meta := metav1.ObjectMeta{
+		Name:      vsixName(devEnv),
+		Namespace: environmentNamespace(devEnv),
+		Labels: map[string]string{
+			"app":           "vscode-server",
+			"developer-env": devEnv.Name,
+		},
+	}
+	vsix := devEnv.Spec.IDE.VSIX
+	if vsix == nil || !kind.VSIX || len(devEnv.Spec.IDE.Extensions) == 0 {
+		for _, obj := range []client.Object{&corev1.ConfigMap{ObjectMeta: meta}, &corev1.Secret{ObjectMeta: meta}} {
+			if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+				return "", fmt.Errorf("failed to delete VSIX source %s: %w", obj.GetName(), err)
+			}
+		}
+		return "", nil
+	}
+
+	sum, err := r.copySource(ctx, devEnv, meta, vsix.ConfigMap, vsix.S3)
+	if err != nil {
+		return "", fmt.Errorf("failed to copy VSIX source: %w", err)
+	}
+	return sum, nil
+}
+
+// vsixVolume holds the .vsix files, downloaded from the bucket by an init container or mounted from the ConfigMap
+func vsixVolume(devEnv *apiv1.DeveloperEnvironment) corev1.Volume {
+	volume := corev1.Volume{Name: "vsix"}
+	if devEnv.Spec.IDE.VSIX.S3 != nil {
+		volume.EmptyDir = &corev1.EmptyDirVolumeSource{}
+	} else {
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: vsixName(devEnv)},
+		}
+	}
+	return volume
+}
+
+// vsixDownloadContainer fetches the .vsix files under the prefix of the bucket with the MinIO client
+func vsixDownloadContainer(devEnv *apiv1.DeveloperEnvironment) corev1.Container {
+	s3 := devEnv.Spec.IDE.VSIX.S3
+	return corev1.Container{
+		Name:    "download-vsix",
+		Image:   mcImage,
+		Command: []string{"mc"},
+		Args:    []string{"mirror", s3Path("source", s3.Bucket, s3.Key), vsixPath},
+		Env:     mcHostEnv("source", s3.Endpoint, vsixName(devEnv)),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "vsix",
+				MountPath: vsixPath,
+			},
+		},
+	}
+}
+
+// extensionsMount mounts the installed extensions from the workspace volume
+func extensionsMount(kind ideType) corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      "workspace",
+		MountPath: kind.extensionsPath,
+		SubPath:   extensionsSubPath,
+	}
+}
+
+// extensionsContainer returns the init container installing the extensions,
+// in the image of the IDE. It runs as root to hand the extensions to the
+// IDE user, whatever user the image starts as.
+func extensionsContainer(devEnv *apiv1.DeveloperEnvironment, kind ideType, script string, env []corev1.EnvVar, vsix bool) corev1.Container {
+	container := corev1.Container{
+		Name:            extensionsContainerName,
+		Image:           devEnv.Status.Runtime.Image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c", script},
+		Env:             env,
+		SecurityContext: &corev1.SecurityContext{RunAsUser: Ptr(int64(0))},
+		VolumeMounts:    []corev1.VolumeMount{extensionsMount(kind)},
+	}
+	if vsix {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "vsix",
+			MountPath: vsixPath,
+			ReadOnly:  true,
+		})
+	}
+	return container
+}
+
+// extensionRegistryEnv points the IDE at the Open VSX registry of the
+// environment, or of the operator
+func (r *DeveloperEnvironmentReconciler) extensionRegistryEnv(devEnv *apiv1.DeveloperEnvironment, kind ideType) ([]corev1.EnvVar, error) {
+	registry := devEnv.Spec.IDE.ExtensionRegistry
+	if registry == "" {
+		registry = r.Defaults.ExtensionRegistry
+	}
+	if registry == "" || kind.registryEnv == nil {
+		return nil, nil
+	}
+	return kind.registryEnv(strings.TrimSuffix(registry, "/"))
+}
+
+// openVSXGallery configures code-server to use an Open VSX registry
+func openVSXGallery(registry string) ([]corev1.EnvVar, error) {
+	gallery, err := json.Marshal(map[string]string{
+		"serviceUrl":          registry + "/vscode/gallery",
+		"itemUrl":             registry + "/vscode/item",
+		"resourceUrlTemplate": registry + "/vscode/unpkg/{publisher}/{name}/{version}/{path}",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render extension gallery: %w", err)
+	}
+	return []corev1.EnvVar{{Name: "EXTENSIONS_GALLERY", Value: string(gallery)}}, nil
+}
+
+// extensionStatuses matches the results reported by the extensions init container to spec.ide.extensions
+func extensionStatuses(extensions []string, results string) []apiv1.ExtensionStatus {
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(results), "\n")
+	reported := map[string]string{}
+	// Results of an earlier extension list say nothing about the current one
+	if lines[0] == "checksum "+extensionChecksum(extensions) {
+		for _, line := range lines[1:] {
+			if index, result, ok := strings.Cut(line, " "); ok {
+				reported[index] = result
+			}
+		}
+	}
+
+	statuses := make([]apiv1.ExtensionStatus, 0, len(extensions))
+	for i, extension := range extensions {
+		name, version := parseExtension(extension)
+		status := apiv1.ExtensionStatus{Name: name, Version: version, Phase: apiv1.ExtensionPending}
+		result, ok := reported[strconv.Itoa(i)]
+		switch {
+		case !ok:
+		case strings.HasPrefix(result, "! "):
+			status.Phase = apiv1.ExtensionFailed
+			status.Message = strings.TrimPrefix(result, "! ")
+		default:
+			status.Phase = apiv1.ExtensionInstalled
+			status.Source = apiv1.ExtensionSource(result)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// extensionCondition summarises the extension statuses
+func extensionCondition(statuses []apiv1.ExtensionStatus) apiv1.Condition {
+	var pending, failed []string
+	for _, status := range statuses {
+		switch status.Phase {
+		case apiv1.ExtensionPending:
+			pending = append(pending, status.Name)
+		case apiv1.ExtensionFailed:
+			failed = append(failed, status.Name)
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		return conditionFromBool(apiv1.ConditionExtensionsInstalled, false, "InstallFailed",
+			fmt.Sprintf("Failed to install %s", strings.Join(failed, ", ")))
+	case len(pending) > 0:
+		return conditionFromBool(apiv1.ConditionExtensionsInstalled, false, "Installing",
+			fmt.Sprintf("Waiting for %s", strings.Join(pending, ", ")))
+	default:
+		return conditionFromBool(apiv1.ConditionExtensionsInstalled, true, "Installed",
+			fmt.Sprintf("%d extensions installed", len(statuses)))
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestExtensionStatuses(t *testing.T) {
+	extensions := []string{"golang.Go@0.42.1", "esbenp.prettier-vscode"}
+	header := "checksum " + extensionChecksum(extensions) + "\n"
+
+	tests := []struct {
+		name    string
+		results string
+		want    []apiv1.ExtensionStatus
+	}{
+		{
+			name: "not installed yet",
+			want: []apiv1.ExtensionStatus{
+				{Name: "golang.Go", Version: "0.42.1", Phase: apiv1.ExtensionPending},
+				{Name: "esbenp.prettier-vscode", Phase: apiv1.ExtensionPending},
+			},
+		},
+		{
+			name:    "installed and failed",
+			results: header + "0 VSIX\n1 ! Failed Installing Extensions: esbenp.prettier-vscode\n",
+			want: []apiv1.ExtensionStatus{
+				{Name: "golang.Go", Version: "0.42.1", Source: apiv1.ExtensionSourceVSIX, Phase: apiv1.ExtensionInstalled},
+				{Name: "esbenp.prettier-vscode", Phase: apiv1.ExtensionFailed,
+					Message: "Failed Installing Extensions: esbenp.prettier-vscode"},
+			},
+		},
+		{
+			name:    "results of another extension list",
+			results: "checksum 0000000000000000\n0 Registry\n",
+			want: []apiv1.ExtensionStatus{
+				{Name: "golang.Go", Version: "0.42.1", Phase: apiv1.ExtensionPending},
+				{Name: "esbenp.prettier-vscode", Phase: apiv1.ExtensionPending},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extensionStatuses(extensions, tt.results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extensionStatuses() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
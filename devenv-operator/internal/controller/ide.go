@@ -0,0 +1,202 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+	"github.com/adityajoshi12/devenv-operator/internal/catalog"
+)
+
+// ideType describes how to run a type of IDE with the capabilities of its
+// catalog entry
+type ideType struct {
+	catalog.IDE
+	// image runs the IDE when no LanguageRuntime provides one
+	image string
+	port  int32
+	// workspacePath is where the workspace volume is mounted
+	workspacePath string
+	// fsGroup owns the volumes, for images that do not start as root
+	fsGroup *int64
+	// env configures the IDE, reading the password from the IDE password Secret
+	env func(secret string) []corev1.EnvVar
+	// probe checks that the IDE serves on its port
+	probe corev1.ProbeHandler
+	// settingsPath is where the IDE reads the settings rendered by
+	// renderSettings, for the types that have settings
+	settingsPath   string
+	renderSettings func(settings map[string]string) (string, error)
+	// syncSettings merges the settings into the user settings while the IDE
+	// runs, leaving alone those the developer changed. Other types read them
+	// from the ConfigMap, and are restarted when they change.
+	syncSettings bool
+	// owner owns the files the init containers create for the IDE, as uid:gid
+	owner string
+	// installExtension is the command installing the extension $name, at
+	// $version when it is pinned, for the types that have extensions. The
+	// types with VSIX install it from the .vsix file $file when there is one.
+	installExtension string
+	// extensionsPath is where installExtension installs the extensions
+	extensionsPath string
+	// registryEnv points the IDE at an Open VSX registry, for the types that can use one
+	registryEnv func(registry string) ([]corev1.EnvVar, error)
+}
+
+var ideTypes = map[apiv1.IDEType]ideType{
+	apiv1.IDETypeVSCode: {
+		IDE:           catalog.IDETypes[apiv1.IDETypeVSCode],
+		image:         defaultIDEImage,
+		port:          8443,
+		workspacePath: "/config/workspace",
+		env: func(secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				{Name: "PUID", Value: "1000"},
+				{Name: "PGID", Value: "1000"},
+				secretEnv("PASSWORD", secret, defaultPasswordKey),
+				secretEnv("SUDO_PASSWORD", secret, defaultPasswordKey),
+			}
+		},
+		probe: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt32(8443)},
+		},
+		settingsPath:   "/config/data/User/settings.json",
+		renderSettings: renderJSONSettings,
+		syncSettings:   true,
+		owner:          "1000:1000",
+		installExtension: `/app/code-server/bin/code-server --extensions-dir /config/extensions --force ` +
+			`--install-extension "${file:-$name${version:+@$version}}"`,
+		extensionsPath: "/config/extensions",
+		registryEnv:    openVSXGallery,
+	},
+	apiv1.IDETypeJupyterLab: {
+		IDE:           catalog.IDETypes[apiv1.IDETypeJupyterLab],
+		image:         "quay.io/jupyter/base-notebook:lab-4.2.5",
+		port:          8888,
+		workspacePath: "/home/jovyan/work",
+		fsGroup:       Ptr(int64(100)),
+		env: func(secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				secretEnv("JUPYTER_TOKEN", secret, defaultPasswordKey),
+			}
+		},
+		probe: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/api", Port: intstr.FromInt32(8888)},
+		},
+		settingsPath:   "/opt/conda/share/jupyter/lab/settings/overrides.json",
+		renderSettings: renderJupyterSettings,
+		owner:          "1000:100",
+		// Installed for the IDE user rather than root, which runs the init container
+		installExtension: `PYTHONUSERBASE=/home/jovyan/.local pip install --user --no-cache-dir "$name${version:+==$version}"`,
+		extensionsPath:   "/home/jovyan/.local",
+	},
+}
+
+// ideKind returns how to run the IDE of an environment. Environments created
+// while the webhooks were disabled may not have a type, and run code-server.
+func ideKind(devEnv *apiv1.DeveloperEnvironment) (ideType, error) {
+	if devEnv.Spec.IDE.Type == "" {
+		return ideTypes[apiv1.IDETypeVSCode], nil
+	}
+	kind, ok := ideTypes[devEnv.Spec.IDE.Type]
+	if !ok {
+		return ideType{}, fmt.Errorf("unsupported IDE type %q", devEnv.Spec.IDE.Type)
+	}
+	return kind, nil
+}
+
+// runtimeIDE is the IDE type the images of a LanguageRuntime run
+func runtimeIDE(runtime apiv1.LanguageRuntime) apiv1.IDEType {
+	if runtime.Spec.IDE == "" {
+		return apiv1.IDETypeVSCode
+	}
+	return runtime.Spec.IDE
+}
+
+// ideProbes returns the readiness and liveness probes of the IDE. The IDE
+// is only restarted after failing for two minutes, so a slow startup script
+// does not get it killed.
+func ideProbes(kind ideType) (*corev1.Probe, *corev1.Probe) {
+	readiness := &corev1.Probe{
+		ProbeHandler:     kind.probe,
+		PeriodSeconds:    10,
+		FailureThreshold: 3,
+	}
+	liveness := &corev1.Probe{
+		ProbeHandler:     kind.probe,
+		PeriodSeconds:    20,
+		FailureThreshold: 6,
+	}
+	return readiness, liveness
+}
+
+// settingValue reads a setting as JSON, falling back to a string
+func settingValue(value string) any {
+	var parsed any
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return value
+	}
+	return parsed
+}
+
+// renderJSONSettings renders VS Code settings, which are keyed by their full name
+func renderJSONSettings(settings map[string]string) (string, error) {
+	values := map[string]any{}
+	for key, value := range settings {
+		values[key] = settingValue(value)
+	}
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render IDE settings: %w", err)
+	}
+	return string(data), nil
+}
+
+// renderJupyterSettings renders JupyterLab settings named <plugin>.<setting>
+// into the overrides of each plugin
+func renderJupyterSettings(settings map[string]string) (string, error) {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	plugins := map[string]map[string]any{}
+	for _, key := range keys {
+		i := strings.LastIndex(key, ".")
+		if i <= 0 || i == len(key)-1 {
+			return "", fmt.Errorf("JupyterLab setting %q is not of the form <plugin>.<setting>", key)
+		}
+		plugin, name := key[:i], key[i+1:]
+		if plugins[plugin] == nil {
+			plugins[plugin] = map[string]any{}
+		}
+		plugins[plugin][name] = settingValue(settings[key])
+	}
+	data, err := json.MarshalIndent(plugins, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render IDE settings: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/adityajoshi12/devenv-operator/internal/catalog"
+)
+
+func TestRenderSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		render   func(map[string]string) (string, error)
+		settings map[string]string
+		want     map[string]any
+		wantErr  bool
+	}{
+		{
+			name:   "VS Code settings are read as JSON",
+			render: renderJSONSettings,
+			settings: map[string]string{
+				"editor.fontSize":      "14",
+				"editor.formatOnSave":  "true",
+				"workbench.colorTheme": "Default Dark Modern",
+				"files.exclude":        `{"**/.git": true}`,
+			},
+			want: map[string]any{
+				"editor.fontSize":      float64(14),
+				"editor.formatOnSave":  true,
+				"workbench.colorTheme": "Default Dark Modern",
+				"files.exclude":        map[string]any{"**/.git": true},
+			},
+		},
+		{
+			name:   "JupyterLab settings are grouped by plugin",
+			render: renderJupyterSettings,
+			settings: map[string]string{
+				"@jupyterlab/apputils-extension:themes.theme":           "JupyterLab Dark",
+				"@jupyterlab/notebook-extension:tracker.codeCellConfig": `{"lineNumbers": true}`,
+				"@jupyterlab/notebook-extension:tracker.kernelShutdown": "true",
+			},
+			want: map[string]any{
+				"@jupyterlab/apputils-extension:themes": map[string]any{"theme": "JupyterLab Dark"},
+				"@jupyterlab/notebook-extension:tracker": map[string]any{
+					"codeCellConfig": map[string]any{"lineNumbers": true},
+					"kernelShutdown": true,
+				},
+			},
+		},
+		{
+			name:     "JupyterLab setting without a plugin",
+			render:   renderJupyterSettings,
+			settings: map[string]string{"theme": "JupyterLab Dark"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := tt.render(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var got map[string]any
+			if err := json.Unmarshal([]byte(rendered), &got); err != nil {
+				t.Fatalf("render() = %q is not JSON: %v", rendered, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("render() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDETypesCoverCatalog(t *testing.T) {
+	for ide := range catalog.IDETypes {
+		if _, ok := ideTypes[ide]; !ok {
+			t.Errorf("IDE type %s of the catalog cannot be run", ide)
+		}
+	}
+	for ide := range ideTypes {
+		if _, ok := catalog.IDETypes[ide]; !ok {
+			t.Errorf("IDE type %s is missing from the catalog", ide)
+		}
+	}
+}
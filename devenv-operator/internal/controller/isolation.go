@@ -0,0 +1,367 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// setupIsolation fences the environment off from its neighbours: a ResourceQuota
+// and LimitRange for dedicated namespaces, and NetworkPolicies in every mode.
+func (r *DeveloperEnvironmentReconciler) setupIsolation(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	// A quota applies to the whole namespace, so it is only meaningful when the environment has one to itself
+	if environmentNamespace(devEnv) != devEnv.Namespace {
+		if err := r.setupQuota(ctx, devEnv); err != nil {
+			return err
+		}
+	}
+	return r.setupNetworkPolicies(ctx, devEnv)
+}
+
+// effectiveQuota layers the environment's quota settings over the operator defaults
+func (r *DeveloperEnvironmentReconciler) effectiveQuota(devEnv *apiv1.DeveloperEnvironment) apiv1.QuotaSpec {
+	quota := apiv1.QuotaSpec{
+		Hard:           mergeResourceLists(r.DefaultQuota.Hard, nil),
+		DefaultRequest: mergeResourceLists(r.DefaultQuota.DefaultRequest, nil),
+		Default:        mergeResourceLists(r.DefaultQuota.Default, nil),
+	}
+	if devEnv.Spec.Quota != nil {
+		quota.Hard = mergeResourceLists(quota.Hard, devEnv.Spec.Quota.Hard)
+		quota.DefaultRequest = mergeResourceLists(quota.DefaultRequest, devEnv.Spec.Quota.DefaultRequest)
+		quota.Default = mergeResourceLists(quota.Default, devEnv.Spec.Quota.Default)
+	}
+	return quota
+}
+
+// mergeResourceLists returns a copy of base with the entries of overrides replacing its own
+func mergeResourceLists(base, overrides corev1.ResourceList) corev1.ResourceList {
+	if len(base) == 0 && len(overrides) == 0 {
+		return nil
+	}
+	merged := corev1.ResourceList{}
+	for name, quantity := range base {
+		merged[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range overrides {
+		merged[name] = quantity.DeepCopy()
+	}
+	return merged
+}
+
+func (r *DeveloperEnvironmentReconciler) setupQuota(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	quota := r.effectiveQuota(devEnv)
+
+	if len(quota.Hard) > 0 {
+		resourceQuota := &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-quota", devEnv.Name),
+				Namespace: environmentNamespace(devEnv),
+			},
+			Spec: corev1.ResourceQuotaSpec{
+				Hard: quota.Hard,
+			},
+		}
+		if err := r.apply(ctx, devEnv, resourceQuota); err != nil {
+			return fmt.Errorf("failed to apply resource quota: %w", err)
+		}
+	}
+
+	if len(quota.DefaultRequest) > 0 || len(quota.Default) > 0 {
+		limitRange := &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-limits", devEnv.Name),
+				Namespace: environmentNamespace(devEnv),
+			},
+			Spec: corev1.LimitRangeSpec{
+				Limits: []corev1.LimitRangeItem{
+					{
+						Type:           corev1.LimitTypeContainer,
+						DefaultRequest: quota.DefaultRequest,
+						Default:        quota.Default,
+					},
+				},
+			},
+		}
+		if err := r.apply(ctx, devEnv, limitRange); err != nil {
+			return fmt.Errorf("failed to apply limit range: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *DeveloperEnvironmentReconciler) setupNetworkPolicies(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	kind, err := ideKind(devEnv)
+	if err != nil {
+		return err
+	}
+	ideSelector := metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"app":           "vscode-server",
+			"developer-env": devEnv.Name,
+		},
+	}
+
+	policies := []*networkingv1.NetworkPolicy{
+		// Deny all ingress to the environment's pods unless allowed below
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-default-deny", devEnv.Name),
+				Namespace: environmentNamespace(devEnv),
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"developer-env": devEnv.Name,
+					},
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		},
+		// The ingress controller may reach the IDE, and so may the operator to check for activity
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-allow-ide-ingress", devEnv.Name),
+				Namespace: environmentNamespace(devEnv),
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: ideSelector,
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						From: []networkingv1.NetworkPolicyPeer{
+							{
+								NamespaceSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{
+										corev1.LabelMetadataName: r.IngressNamespace,
+									},
+								},
+							},
+							{
+								NamespaceSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{
+										corev1.LabelMetadataName: r.OperatorNamespace,
+									},
+								},
+								PodSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{
+										"control-plane": "controller-manager",
+									},
+								},
+							},
+						},
+						Ports: []networkingv1.NetworkPolicyPort{
+							{
+								Protocol: Ptr(corev1.ProtocolTCP),
+								Port:     Ptr(intstr.FromInt32(kind.port)),
+							},
+						},
+					},
+				},
+			},
+		},
+		// code-server and the seed and backup Jobs may reach the environment's backing services
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-allow-database-from-ide", devEnv.Name),
+				Namespace: environmentNamespace(devEnv),
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"app":           "database",
+						"developer-env": devEnv.Name,
+					},
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						From: []networkingv1.NetworkPolicyPeer{
+							{
+								PodSelector: &ideSelector,
+							},
+							{
+								PodSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{
+										"developer-env": devEnv.Name,
+									},
+									MatchExpressions: []metav1.LabelSelectorRequirement{
+										{
+											Key:      "app",
+											Operator: metav1.LabelSelectorOpIn,
+											Values:   []string{"database-seed", "database-backup"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, policy := range policies {
+		if err := r.apply(ctx, devEnv, policy); err != nil {
+			return fmt.Errorf("failed to apply network policy %s: %w", policy.Name, err)
+		}
+	}
+	return nil
+}
+
+// quotaCondition reports whether the environment has hit its quota, either
+// because the ResourceQuota of its namespace is used up or because one of its
+// Deployments or StatefulSets failed to create pods for exceeding a quota.
+func (r *DeveloperEnvironmentReconciler) quotaCondition(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (apiv1.Condition, error) {
+	var exhausted []string
+
+	deployments := []string{fmt.Sprintf("%s-vscode-server", devEnv.Name)}
+	var statefulSets []string
+	for _, service := range devEnv.Spec.EffectiveServices() {
+		if serviceTypes[service.Type].statefulSet {
+			statefulSets = append(statefulSets, serviceObjectName(devEnv, service.Name))
+		} else {
+			deployments = append(deployments, serviceObjectName(devEnv, service.Name))
+		}
+	}
+	for _, name := range deployments {
+		deployment := &appsv1.Deployment{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: environmentNamespace(devEnv)}, deployment); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return apiv1.Condition{}, fmt.Errorf("failed to get deployment %s: %w", name, err)
+		}
+		for _, c := range deployment.Status.Conditions {
+			if c.Type == appsv1.DeploymentReplicaFailure && c.Status == corev1.ConditionTrue &&
+				strings.Contains(c.Message, "exceeded quota") {
+				return conditionFromBool(apiv1.ConditionQuotaExceeded, true, "PodCreationRejected", c.Message), nil
+			}
+		}
+	}
+	for _, name := range statefulSets {
+		message, err := r.statefulSetQuotaFailure(ctx, types.NamespacedName{Name: name, Namespace: environmentNamespace(devEnv)})
+		if err != nil {
+			return apiv1.Condition{}, err
+		}
+		if message != "" {
+			return conditionFromBool(apiv1.ConditionQuotaExceeded, true, "PodCreationRejected", message), nil
+		}
+	}
+
+	if environmentNamespace(devEnv) != devEnv.Namespace {
+		resourceQuota := &corev1.ResourceQuota{}
+		err := r.Get(ctx, types.NamespacedName{
+			Name:      fmt.Sprintf("%s-quota", devEnv.Name),
+			Namespace: environmentNamespace(devEnv),
+		}, resourceQuota)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return apiv1.Condition{}, fmt.Errorf("failed to get resource quota: %w", err)
+		}
+		for name, hard := range resourceQuota.Status.Hard {
+			used, ok := resourceQuota.Status.Used[name]
+			if ok && used.Cmp(hard) >= 0 {
+				exhausted = append(exhausted, fmt.Sprintf("%s (%s/%s)", name, used.String(), hard.String()))
+			}
+		}
+	}
+
+	if len(exhausted) > 0 {
+		sort.Strings(exhausted)
+		return conditionFromBool(apiv1.ConditionQuotaExceeded, true, "QuotaExhausted",
+			fmt.Sprintf("Quota used up for %s", strings.Join(exhausted, ", "))), nil
+	}
+	return conditionFromBool(apiv1.ConditionQuotaExceeded, false, "WithinQuota", ""), nil
+}
+
+// statefulSetQuotaFailure returns why a StatefulSet missing pods failed to
+// create them for exceeding a quota, if it did. Unlike Deployments,
+// StatefulSets have no ReplicaFailure condition and only report it in events.
+func (r *DeveloperEnvironmentReconciler) statefulSetQuotaFailure(ctx context.Context, key types.NamespacedName) (string, error) {
+	statefulSet := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, key, statefulSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get statefulset %s: %w", key.Name, err)
+	}
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	if statefulSet.Status.Replicas >= replicas {
+		return "", nil
+	}
+
+	events := &corev1.EventList{}
+	err := r.List(ctx, events, client.InNamespace(key.Namespace), client.MatchingFields{
+		"involvedObject.kind": "StatefulSet",
+		"involvedObject.name": key.Name,
+		"involvedObject.uid":  string(statefulSet.UID),
+		"reason":              "FailedCreate",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list events of statefulset %s: %w", key.Name, err)
+	}
+	return quotaFailureMessage(events.Items), nil
+}
+
+// quotaFailureMessage returns the message of the latest event about
+// exceeding a quota, if any
+func quotaFailureMessage(events []corev1.Event) string {
+	var latest *corev1.Event
+	for i, event := range events {
+		if !strings.Contains(event.Message, "exceeded quota") {
+			continue
+		}
+		if latest == nil || eventTime(event).After(eventTime(*latest)) {
+			latest = &events[i]
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Message
+}
+
+// eventTime is when an event was last seen
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestQuotaFailureMessage(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	event := func(message string, age time.Duration) corev1.Event {
+		return corev1.Event{Message: message, LastTimestamp: metav1.NewTime(now.Add(-age))}
+	}
+
+	tests := []struct {
+		name   string
+		events []corev1.Event
+		want   string
+	}{
+		{
+			name: "no events",
+		},
+		{
+			name:   "failure unrelated to the quota",
+			events: []corev1.Event{event(`create Pod kafka-0 failed: serviceaccount "kafka" not found`, 0)},
+		},
+		{
+			name: "latest quota failure",
+			events: []corev1.Event{
+				event(`exceeded quota: env-quota, requested: cpu=1, used: cpu=4, limited: cpu=4`, time.Hour),
+				event(`exceeded quota: env-quota, requested: memory=2Gi, used: memory=8Gi, limited: memory=8Gi`, time.Minute),
+				event(`create Pod kafka-0 failed: serviceaccount "kafka" not found`, 0),
+			},
+			want: `exceeded quota: env-quota, requested: memory=2Gi, used: memory=8Gi, limited: memory=8Gi`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quotaFailureMessage(tt.events); got != tt.want {
+				t.Errorf("quotaFailureMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,485 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+const (
+	// labelEnvironment and labelEnvironmentNamespace identify the DeveloperEnvironment a child object belongs to.
+	// Owner references cannot cross namespaces, so these labels are what maps child objects back to their environment.
+	labelEnvironment          = "developer-env"
+	labelEnvironmentNamespace = "developer-env-namespace"
+
+	// labelManagedBy marks the objects created by the operator. Only those are
+	// cached, rather than every object of their kinds in the cluster.
+	labelManagedBy = "managed-by"
+	managedBy      = "devenv-operator"
+)
+
+// environmentNamespace is the namespace the environment's child objects live in
+func environmentNamespace(devEnv *apiv1.DeveloperEnvironment) string {
+	if devEnv.Status.Namespace != "" {
+		return devEnv.Status.Namespace
+	}
+	return devEnv.Namespace
+}
+
+func dedicatedNamespaceName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("devenv-%s", devEnv.Name)
+}
+
+func workspacePVCName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-vscode-workspace", devEnv.Name)
+}
+
+func databasePVCName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-db-pvc", devEnv.Name)
+}
+
+// desiredNamespace resolves the namespace the environment should live in.
+//
+// An explicit spec.namespaceMode always wins. Otherwise an environment stays
+// where it already is, so that changing the operator-wide mode only affects
+// new environments; environments created before the namespace was tracked in
+// status are detected by their code-server Deployment in the CR namespace.
+func (r *DeveloperEnvironmentReconciler) desiredNamespace(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (string, error) {
+	mode := devEnv.Spec.NamespaceMode
+	if mode == "" {
+		if devEnv.Status.Namespace != "" {
+			return devEnv.Status.Namespace, nil
+		}
+
+		legacy := &appsv1.Deployment{}
+		err := r.getChild(ctx, types.NamespacedName{
+			Name:      fmt.Sprintf("%s-vscode-server", devEnv.Name),
+			Namespace: devEnv.Namespace,
+		}, legacy)
+		if err == nil {
+			return devEnv.Namespace, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to look up existing environment: %w", err)
+		}
+		mode = r.NamespaceMode
+	}
+
+	if mode == apiv1.NamespaceModeDedicated {
+		return dedicatedNamespaceName(devEnv), nil
+	}
+	return devEnv.Namespace, nil
+}
+
+// ownsNamespace reports whether the namespace was created by the operator for this environment
+func ownsNamespace(devEnv *apiv1.DeveloperEnvironment, namespace *corev1.Namespace) bool {
+	labels := namespace.Labels
+	if labels[labelManagedBy] != managedBy || labels["environment"] != devEnv.Name {
+		return false
+	}
+	// Namespaces created before the CR namespace was recorded carry no namespace label
+	owner, ok := labels[labelEnvironmentNamespace]
+	return !ok || owner == devEnv.Namespace
+}
+
+// Namespace creation
+func (r *DeveloperEnvironmentReconciler) ensureNamespace(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	ns string,
+) error {
+	if ns == devEnv.Namespace {
+		return nil
+	}
+
+	// Refuse to take over a namespace belonging to someone else
+	existing := &corev1.Namespace{}
+	err := r.Get(ctx, client.ObjectKey{Name: ns}, existing)
+	if err == nil && !ownsNamespace(devEnv, existing) {
+		return fmt.Errorf("namespace %s already exists and does not belong to this environment", ns)
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get namespace: %w", err)
+	}
+
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ns,
+			Labels: map[string]string{
+				labelManagedBy: managedBy,
+				"environment":  devEnv.Name,
+			},
+		},
+	}
+	if err := r.apply(ctx, devEnv, namespace); err != nil {
+		return fmt.Errorf("failed to apply namespace: %w", err)
+	}
+	return nil
+}
+
+// deleteEnvironmentNamespace deletes a dedicated namespace, provided it belongs to the environment
+func (r *DeveloperEnvironmentReconciler) deleteEnvironmentNamespace(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	ns string,
+) error {
+	if ns == devEnv.Namespace {
+		return nil
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: ns}, namespace); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get namespace: %w", err)
+	}
+	if !ownsNamespace(devEnv, namespace) {
+		return nil
+	}
+	if err := r.Delete(ctx, namespace); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete namespace: %w", err)
+	}
+	return nil
+}
+
+// reconcileNamespace places the environment in its desired namespace, migrating
+// it from the previous one if needed. It reports false while a migration is
+// still in progress.
+func (r *DeveloperEnvironmentReconciler) reconcileNamespace(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (bool, error) {
+	ns, err := r.desiredNamespace(ctx, devEnv)
+	if err != nil {
+		return false, err
+	}
+	if err := r.ensureNamespace(ctx, devEnv, ns); err != nil {
+		return false, err
+	}
+
+	current := devEnv.Status.Namespace
+	if current == "" || current == ns {
+		devEnv.Status.Namespace = ns
+		return true, nil
+	}
+
+	done, err := r.migrateNamespace(ctx, devEnv, current, ns)
+	if err != nil {
+		setCondition(devEnv, conditionFromBool(apiv1.ConditionNamespaceMigrated, false, "MigrationFailed", err.Error()))
+		return false, err
+	}
+	if !done {
+		setCondition(devEnv, conditionFromBool(apiv1.ConditionNamespaceMigrated, false, "MigrationInProgress",
+			fmt.Sprintf("Moving environment from namespace %s to %s", current, ns)))
+		return false, nil
+	}
+
+	setCondition(devEnv, conditionFromBool(apiv1.ConditionNamespaceMigrated, true, "MigrationSucceeded",
+		fmt.Sprintf("Environment moved from namespace %s to %s", current, ns)))
+	devEnv.Status.Namespace = ns
+	return true, nil
+}
+
+// migrateNamespace moves an environment between namespaces. Workloads and
+// configuration in the old namespace are deleted and recreated by the normal
+// reconcile afterwards; the workspace and database volumes are carried over by
+// rebinding their PersistentVolumes to claims of the same name in the new namespace.
+func (r *DeveloperEnvironmentReconciler) migrateNamespace(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	from, to string,
+) (bool, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("Migrating developer environment", "from", from, "to", to)
+
+	if err := r.carryConnectionSecrets(ctx, devEnv, from, to); err != nil {
+		return false, err
+	}
+	if err := r.deleteEnvironmentObjects(ctx, devEnv, from); err != nil {
+		return false, err
+	}
+
+	pvcNames := []string{workspacePVCName(devEnv)}
+	for _, service := range devEnv.Spec.EffectiveServices() {
+		pvcNames = append(pvcNames, servicePVCName(devEnv, service.Name))
+	}
+	done := true
+	for _, pvcName := range pvcNames {
+		moved, err := r.migrateVolume(ctx, devEnv, pvcName, from, to)
+		if err != nil {
+			return false, err
+		}
+		done = done && moved
+	}
+	if !done {
+		return false, nil
+	}
+
+	if err := r.deleteEnvironmentNamespace(ctx, devEnv, from); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// carryConnectionSecrets copies the connection Secrets of the backing services
+// into the new namespace. They hold the passwords the services initialised
+// their volumes with, which the volumes keep when they are carried over.
+func (r *DeveloperEnvironmentReconciler) carryConnectionSecrets(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	from, to string,
+) error {
+	for _, service := range devEnv.Spec.EffectiveServices() {
+		name := serviceConnectionSecretName(devEnv, service.Name)
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: from}, secret); err != nil {
+			// Already carried over by an earlier attempt, or never created
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to get connection secret %s/%s: %w", from, name, err)
+		}
+		carried := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: to,
+				Labels:    maps.Clone(secret.Labels),
+			},
+			Type: secret.Type,
+			Data: secret.Data,
+		}
+		if err := r.apply(ctx, devEnv, carried); err != nil {
+			return fmt.Errorf("failed to carry connection secret %s to namespace %s: %w", name, to, err)
+		}
+	}
+	return nil
+}
+
+// deleteEnvironmentObjects removes every child object of the environment from a namespace, except its volumes
+func (r *DeveloperEnvironmentReconciler) deleteEnvironmentObjects(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	ns string,
+) error {
+	lists := []client.ObjectList{
+		&appsv1.DeploymentList{},
+		&appsv1.StatefulSetList{},
+		&batchv1.JobList{},
+		&corev1.ServiceList{},
+		&corev1.SecretList{},
+		&corev1.ConfigMapList{},
+		&networkingv1.IngressList{},
+		&certmanagerv1.CertificateList{},
+		&certmanagerv1.IssuerList{},
+		&networkingv1.NetworkPolicyList{},
+		&corev1.ServiceAccountList{},
+		&rbacv1.RoleList{},
+		&rbacv1.RoleBindingList{},
+	}
+	// A generated IDE password is only kept next to the DeveloperEnvironment
+	keep := ""
+	if ns == devEnv.Namespace && devEnv.Spec.IDE.PasswordSecretRef == nil {
+		keep = idePasswordSecretName(devEnv)
+	}
+	for _, list := range lists {
+		if err := r.List(ctx, list, client.InNamespace(ns), client.MatchingLabels{labelEnvironment: devEnv.Name}); err != nil {
+			return fmt.Errorf("failed to list objects in namespace %s: %w", ns, err)
+		}
+		if err := forEachObject(list, func(obj client.Object) error {
+			if _, ok := obj.(*corev1.Secret); ok && obj.GetName() == keep {
+				return nil
+			}
+			if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete %s/%s: %w", ns, obj.GetName(), err)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	// The TLS Secret is created by cert-manager and carries none of our labels
+	tlsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.ideHost(devEnv),
+			Namespace: ns,
+		},
+	}
+	if err := r.Delete(ctx, tlsSecret); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete TLS secret: %w", err)
+	}
+	return nil
+}
+
+// migrateVolume rebinds the PersistentVolume behind a claim to a claim of the
+// same name in another namespace. It reports true once the new claim exists.
+func (r *DeveloperEnvironmentReconciler) migrateVolume(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	pvcName, from, to string,
+) (bool, error) {
+	oldPVC := &corev1.PersistentVolumeClaim{}
+	err := r.getChild(ctx, types.NamespacedName{Name: pvcName, Namespace: from}, oldPVC)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get PVC %s/%s: %w", from, pvcName, err)
+	}
+
+	if err == nil {
+		if oldPVC.Spec.VolumeName != "" {
+			// Keep the volume around once its claim is gone
+			pv := &corev1.PersistentVolume{}
+			if err := r.Get(ctx, client.ObjectKey{Name: oldPVC.Spec.VolumeName}, pv); err != nil {
+				return false, fmt.Errorf("failed to get PV %s: %w", oldPVC.Spec.VolumeName, err)
+			}
+			if pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+				patch := client.MergeFrom(pv.DeepCopy())
+				if pv.Annotations == nil {
+					pv.Annotations = map[string]string{}
+				}
+				pv.Annotations[annotationReclaimPolicy] = string(pv.Spec.PersistentVolumeReclaimPolicy)
+				pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+				if err := r.Patch(ctx, pv, patch); err != nil {
+					return false, fmt.Errorf("failed to retain PV %s: %w", pv.Name, err)
+				}
+			}
+		}
+		if oldPVC.DeletionTimestamp == nil {
+			if err := r.Delete(ctx, oldPVC); err != nil && !apierrors.IsNotFound(err) {
+				return false, fmt.Errorf("failed to delete PVC %s/%s: %w", from, pvcName, err)
+			}
+		}
+		// The claim is released once the pods using it are gone
+		return false, nil
+	}
+
+	newPVC := &corev1.PersistentVolumeClaim{}
+	err = r.Get(ctx, types.NamespacedName{Name: pvcName, Namespace: to}, newPVC)
+	if err == nil {
+		return true, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get PVC %s/%s: %w", to, pvcName, err)
+	}
+
+	pvs := &corev1.PersistentVolumeList{}
+	if err := r.List(ctx, pvs); err != nil {
+		return false, fmt.Errorf("failed to list PVs: %w", err)
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		ref := pv.Spec.ClaimRef
+		if ref == nil || ref.Name != pvcName || (ref.Namespace != from && ref.Namespace != to) {
+			continue
+		}
+
+		// Reserve the volume for the new claim and restore its reclaim policy
+		patch := client.MergeFrom(pv.DeepCopy())
+		pv.Spec.ClaimRef = &corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Name:       pvcName,
+			Namespace:  to,
+		}
+		if policy, ok := pv.Annotations[annotationReclaimPolicy]; ok {
+			pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimPolicy(policy)
+			delete(pv.Annotations, annotationReclaimPolicy)
+		}
+		if err := r.Patch(ctx, pv, patch); err != nil {
+			return false, fmt.Errorf("failed to rebind PV %s: %w", pv.Name, err)
+		}
+
+		newPVC = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pvcName,
+				Namespace: to,
+				Labels: map[string]string{
+					labelEnvironment:          devEnv.Name,
+					labelEnvironmentNamespace: devEnv.Namespace,
+					labelManagedBy:            managedBy,
+				},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      pv.Spec.AccessModes,
+				StorageClassName: Ptr(pv.Spec.StorageClassName),
+				VolumeName:       pv.Name,
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceStorage: pv.Spec.Capacity[corev1.ResourceStorage],
+					},
+				},
+			},
+		}
+		if err := r.Create(ctx, newPVC); err != nil && !apierrors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("failed to create PVC %s/%s: %w", to, pvcName, err)
+		}
+		return true, nil
+	}
+
+	// Nothing to carry over, the volume will be provisioned fresh
+	return true, nil
+}
+
+// annotationReclaimPolicy remembers the reclaim policy of a volume while it is retained for a migration
+const annotationReclaimPolicy = "devenv.adityajoshi.online/reclaim-policy"
+
+// environmentForObject maps a child object back to its DeveloperEnvironment through its labels
+func (r *DeveloperEnvironmentReconciler) environmentForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name, ns := labels[labelEnvironment], labels[labelEnvironmentNamespace]
+	if name == "" || ns == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: ns}}}
+}
+
+func forEachObject(list client.ObjectList, fn func(client.Object) error) error {
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		obj, ok := item.(client.Object)
+		if !ok {
+			continue
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
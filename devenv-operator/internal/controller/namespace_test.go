@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// applyAsCreateOrUpdate stands in for server-side apply, which the fake
+// client does not support
+func applyAsCreateOrUpdate(
+	ctx context.Context,
+	c client.WithWatch,
+	obj client.Object,
+	patch client.Patch,
+	opts ...client.PatchOption,
+) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Patch(ctx, obj, patch, opts...)
+	}
+	existing := obj.DeepCopyObject().(client.Object)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		return c.Create(ctx, obj)
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	return c.Update(ctx, obj)
+}
+
+// fakeReconciler returns a reconciler backed by a fake client holding objs
+func fakeReconciler(t *testing.T, objs ...client.Object) *DeveloperEnvironmentReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme,
+		apiv1.AddToScheme,
+		certmanagerv1.AddToScheme,
+	} {
+		if err := add(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&apiv1.DeveloperEnvironment{}).
+		WithInterceptorFuncs(interceptor.Funcs{Patch: applyAsCreateOrUpdate}).
+		Build()
+	return &DeveloperEnvironmentReconciler{Client: c, APIReader: c, Scheme: scheme, ResourceURL: "dev.example.com"}
+}
+
+func TestMigrateNamespace(t *testing.T) {
+	ctx := context.Background()
+	devEnv := &apiv1.DeveloperEnvironment{
+		ObjectMeta: metav1.ObjectMeta{Name: "golang-env", Namespace: "team", UID: "1"},
+		Spec: apiv1.DeveloperEnvironmentSpec{
+			NamespaceMode: apiv1.NamespaceModeDedicated,
+			Services: []apiv1.ServiceSpec{
+				{Name: "db", DatabaseSpec: apiv1.DatabaseSpec{Type: "postgres", Version: "16"}},
+			},
+		},
+		Status: apiv1.DeveloperEnvironmentStatus{Namespace: "team"},
+	}
+	labels := map[string]string{labelEnvironment: "golang-env", labelEnvironmentNamespace: "team"}
+	connection := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "golang-env-db-connection", Namespace: "team", Labels: labels},
+		Data:       map[string][]byte{"password": []byte("initialised")},
+	}
+	idePassword := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: idePasswordSecretName(devEnv), Namespace: "team", Labels: labels},
+		Data:       map[string][]byte{defaultPasswordKey: []byte("generated")},
+	}
+	ide := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "golang-env-vscode-server", Namespace: "team", Labels: labels},
+	}
+	r := fakeReconciler(t, devEnv, connection, idePassword, ide)
+
+	to := dedicatedNamespaceName(devEnv)
+	done, err := r.migrateNamespace(ctx, devEnv, "team", to)
+	if err != nil || !done {
+		t.Fatalf("migrateNamespace() = %v, %v, want done", done, err)
+	}
+
+	if err := r.Get(ctx, client.ObjectKeyFromObject(ide), &corev1.Service{}); !apierrors.IsNotFound(err) {
+		t.Errorf("IDE Service left in the old namespace: %v", err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(connection), &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("connection Secret left in the old namespace: %v", err)
+	}
+
+	// The database volume keeps the password it was initialised with
+	devEnv.Status.Namespace = to
+	password, err := r.servicePassword(ctx, devEnv, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "initialised" {
+		t.Errorf("servicePassword() after migration = %q, want the password the database was initialised with", password)
+	}
+
+	// The generated IDE password has no other copy
+	ideSecret, err := r.idePassword(ctx, devEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ideSecret) != "generated" {
+		t.Errorf("idePassword() after migration = %q, want the generated password", ideSecret)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// annotationPasswordChecksum rolls the IDE when its password changes, since
+// the password is only read from the environment when code-server starts
+const annotationPasswordChecksum = "devenv.adityajoshi.online/password-checksum"
+
+// defaultPasswordKey is the key of the password in the Secrets holding it
+const defaultPasswordKey = "password"
+
+// idePasswordSecretName names the Secret the IDE reads its password from.
+// A generated password is kept in a Secret of the same name next to the
+// environment, which is the same Secret in the Shared namespace mode.
+func idePasswordSecretName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-vscode-password", devEnv.Name)
+}
+
+// passwordChecksum identifies a password in the pod template without
+// revealing it
+func passwordChecksum(devEnv *apiv1.DeveloperEnvironment, password []byte) string {
+	return checksum(map[string]string{"uid": string(devEnv.UID), defaultPasswordKey: string(password)})
+}
+
+// setupIDEPassword copies the password of the IDE next to it and returns its
+// checksum. A password still given in plain text is moved into the Secret of
+// a generated one and cleared from the spec.
+func (r *DeveloperEnvironmentReconciler) setupIDEPassword(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) (string, error) {
+	password, err := r.idePassword(ctx, devEnv)
+	if err != nil {
+		return "", err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      idePasswordSecretName(devEnv),
+			Namespace: environmentNamespace(devEnv),
+			Labels: map[string]string{
+				"app":           "vscode-server",
+				"developer-env": devEnv.Name,
+			},
+		},
+		Data: map[string][]byte{
+			defaultPasswordKey: password,
+		},
+	}
+	if err := r.apply(ctx, devEnv, secret); err != nil {
+		return "", fmt.Errorf("failed to apply VS Code server secret: %w", err)
+	}
+
+	if devEnv.Spec.IDE.PasswordSecret != "" {
+		err := r.patchEnvironment(ctx, devEnv, func() { devEnv.Spec.IDE.PasswordSecret = "" })
+		if err != nil {
+			return "", fmt.Errorf("failed to clear the plain text password: %w", err)
+		}
+		log.FromContext(ctx).Info("Moved the plain text IDE password into a Secret", "secret", idePasswordSecretName(devEnv))
+	}
+	return passwordChecksum(devEnv, password), nil
+}
+
+// idePassword reads the password of the IDE from the Secret referenced by
+// the environment, or from the Secret of the generated password, generating
+// it the first time
+func (r *DeveloperEnvironmentReconciler) idePassword(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) ([]byte, error) {
+	if ref := devEnv.Spec.IDE.PasswordSecretRef; ref != nil {
+		key := ref.Key
+		if key == "" {
+			key = defaultPasswordKey
+		}
+		source := &corev1.Secret{}
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: devEnv.Namespace}, source); err != nil {
+			return nil, fmt.Errorf("failed to get IDE password secret %s: %w", ref.Name, err)
+		}
+		password := source.Data[key]
+		if len(password) == 0 {
+			return nil, fmt.Errorf("IDE password secret %s has no key %s", ref.Name, key)
+		}
+		return password, nil
+	}
+
+	key := types.NamespacedName{Name: idePasswordSecretName(devEnv), Namespace: devEnv.Namespace}
+	secret := &corev1.Secret{}
+	err := r.getChild(ctx, key, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get IDE password secret: %w", err)
+	}
+	if password := secret.Data[defaultPasswordKey]; err == nil && len(password) > 0 {
+		return password, nil
+	}
+
+	password := devEnv.Spec.IDE.PasswordSecret
+	if password == "" {
+		if password, err = randomPassword(); err != nil {
+			return nil, err
+		}
+	}
+	// In a dedicated namespace the IDE gets a copy, while this one stays
+	// readable by the owner of the environment
+	if key.Namespace != environmentNamespace(devEnv) {
+		generated := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels: map[string]string{
+					"app":           "vscode-server",
+					"developer-env": devEnv.Name,
+				},
+			},
+			Data: map[string][]byte{
+				defaultPasswordKey: []byte(password),
+			},
+		}
+		if err := r.apply(ctx, devEnv, generated); err != nil {
+			return nil, fmt.Errorf("failed to apply IDE password secret: %w", err)
+		}
+	}
+	return []byte(password), nil
+}
+
+// userSecretResync is how often environments reading a Secret of the user
+// read it again, since only the Secrets of the operator are watched
+const userSecretResync = 5 * time.Minute
+
+// readsUserSecret tells whether the IDE password or certificate of an
+// environment comes from a Secret of the user
+func (r *DeveloperEnvironmentReconciler) readsUserSecret(devEnv *apiv1.DeveloperEnvironment) bool {
+	return devEnv.Spec.IDE.PasswordSecretRef != nil || r.tlsSpec(devEnv).Mode == apiv1.TLSModeSecret
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestPasswordChecksum(t *testing.T) {
+	environment := func(uid string) *apiv1.DeveloperEnvironment {
+		return &apiv1.DeveloperEnvironment{ObjectMeta: metav1.ObjectMeta{Name: "golang-env", UID: types.UID(uid)}}
+	}
+
+	sum := passwordChecksum(environment("1"), []byte("hunter2"))
+	if sum != passwordChecksum(environment("1"), []byte("hunter2")) {
+		t.Error("passwordChecksum() is not deterministic")
+	}
+	if sum == passwordChecksum(environment("1"), []byte("hunter3")) {
+		t.Error("passwordChecksum() does not change with the password, so the IDE would not roll")
+	}
+	if sum == passwordChecksum(environment("2"), []byte("hunter2")) {
+		t.Error("passwordChecksum() is the same for the same password in another environment")
+	}
+	if strings.Contains(sum, "hunter2") {
+		t.Errorf("passwordChecksum() = %q reveals the password", sum)
+	}
+}
+
+// The cache only holds the Secrets labeled as managed, so the Secrets of the
+// user and those of earlier versions are read from the API server
+func TestIDEPasswordUncached(t *testing.T) {
+	tests := []struct {
+		name   string
+		ref    *apiv1.SecretKeyReference
+		secret *corev1.Secret
+	}{
+		{
+			name: "secret of the user",
+			ref:  &apiv1.SecretKeyReference{Name: "my-password"},
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-password", Namespace: "team-a"},
+				Data:       map[string][]byte{defaultPasswordKey: []byte("hunter2")},
+			},
+		},
+		{
+			name: "generated by an earlier version",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "golang-env-vscode-password",
+					Namespace: "team-a",
+					Labels:    map[string]string{"app": "vscode-server", labelEnvironment: "golang-env"},
+				},
+				Data: map[string][]byte{defaultPasswordKey: []byte("hunter2")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := &apiv1.DeveloperEnvironment{
+				ObjectMeta: metav1.ObjectMeta{Name: "golang-env", Namespace: "team-a"},
+			}
+			devEnv.Spec.IDE.PasswordSecretRef = tt.ref
+			r := fakeReconciler(t)
+			r.APIReader = fakeReconciler(t, tt.secret).Client
+
+			password, err := r.idePassword(context.Background(), devEnv)
+			if err != nil {
+				t.Fatalf("idePassword() error = %v", err)
+			}
+			if string(password) != "hunter2" {
+				t.Errorf("idePassword() = %q, want the password of the uncached Secret", password)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// cloneContainerName is the init container cloning spec.repositories into the workspace
+const cloneContainerName = "clone-repositories"
+
+// gitImage runs the clone script
+const gitImage = "alpine/git:2.45.2"
+
+// gitCredentialsPath is where the clone container finds the repository credentials
+const gitCredentialsPath = "/etc/git-credentials"
+
+// Keys of a repository credentials Secret copied for the clone container
+var gitCredentialKeys = []string{"ssh-privatekey", "known_hosts", "token", "username"}
+
+// cloneScriptTemplate clones every repository that is not in the workspace
+// yet, and reports the commit each one has checked out through the
+// termination message of the clone container. Failures are reported rather
+// than failing the container, so that a broken repository does not keep the
+// IDE from starting.
+var cloneScriptTemplate = template.Must(template.New("clone-repositories").Parse(`set -u
+export HOME=/tmp
+git config --global --add safe.directory '*'
+results=/dev/termination-log
+echo "checksum {{ .Checksum }}" > $results
+
+# clone <index> <url> <ref> <path>
+clone() {
+    local index=$1 url=$2 ref=$3 dest=/config/workspace/$4 top=/config/workspace/${4%%/*} owned user
+    set --
+    unset GIT_SSH_COMMAND
+    if [ -f {{ .Credentials }}/$index-ssh-privatekey ]; then
+        install -m 600 {{ .Credentials }}/$index-ssh-privatekey /tmp/id-$index
+        if [ -f {{ .Credentials }}/$index-known_hosts ]; then
+            export GIT_SSH_COMMAND="ssh -i /tmp/id-$index -o IdentitiesOnly=yes -o UserKnownHostsFile={{ .Credentials }}/$index-known_hosts"
+        else
+            export GIT_SSH_COMMAND="ssh -i /tmp/id-$index -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=/tmp/known_hosts"
+        fi
+    fi
+    if [ -f {{ .Credentials }}/$index-token ]; then
+        user=git
+        [ -f {{ .Credentials }}/$index-username ] && user=$(cat {{ .Credentials }}/$index-username)
+        # The token is passed on the command line only, so it is not stored in the clone
+        set -- -c "http.extraHeader=Authorization: Basic $(printf '%s:%s' "$user" "$(cat {{ .Credentials }}/$index-token)" | base64 | tr -d '\n')"
+    fi
+
+    if [ -e "$dest/.git" ]; then
+        echo "Keeping the existing clone in $dest"
+    elif [ -n "$(ls -A "$dest" 2>/dev/null)" ]; then
+        echo "$index ! $4 exists and is not a git repository" >> $results
+        return
+    else
+        # Hand everything the clone creates to the IDE user, 1000:1000
+        owned=$dest
+        [ -e "$top" ] || owned=$top
+        if ! git "$@" clone "$url" "$dest"; then
+            echo "$index ! git clone failed" >> $results
+            return
+        fi
+        if [ -n "$ref" ] && ! git -C "$dest" checkout "$ref"; then
+            chown -R 1000:1000 "$owned"
+            echo "$index ! git checkout $ref failed" >> $results
+            return
+        fi
+        chown -R 1000:1000 "$owned"
+    fi
+    echo "$index $(git -C "$dest" rev-parse HEAD)" >> $results
+}
+{{ range $i, $repo := .Repositories }}
+clone {{ $i }} {{ $repo.URL }} {{ $repo.Ref }} {{ $repo.Path }}
+{{- end }}
+exit 0
+`))
+
+func gitCredentialsSecretName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-git-credentials", devEnv.Name)
+}
+
+// repositoryChecksum identifies a repository list, so results can be matched to it
+func repositoryChecksum(repos []apiv1.RepositorySpec) string {
+	data := map[string]string{}
+	for i, repo := range repos {
+		data[strconv.Itoa(i)] = strings.Join([]string{repo.URL, repo.Ref, repo.Path}, " ")
+	}
+	return checksum(data)
+}
+
+// renderCloneScript renders the script of the clone container
+func renderCloneScript(devEnv *apiv1.DeveloperEnvironment) (string, error) {
+	data := struct {
+		Checksum     string
+		Credentials  string
+		Repositories []apiv1.RepositorySpec
+	}{
+		Checksum:    repositoryChecksum(devEnv.Spec.Repositories),
+		Credentials: gitCredentialsPath,
+	}
+	for _, repo := range devEnv.Spec.Repositories {
+		data.Repositories = append(data.Repositories, apiv1.RepositorySpec{
+			URL:  shellQuote(repo.URL),
+			Ref:  shellQuote(repo.Ref),
+			Path: shellQuote(repo.Path),
+		})
+	}
+
+	var script bytes.Buffer
+	if err := cloneScriptTemplate.Execute(&script, data); err != nil {
+		return "", fmt.Errorf("failed to render clone script: %w", err)
+	}
+	return script.String(), nil
+}
+
+// setupGitCredentials copies the credentials of every repository into a
+// single Secret next to the IDE, which may live in another namespace than
+// the Secrets referenced by the environment. It returns whether there are
+// any credentials to mount.
+func (r *DeveloperEnvironmentReconciler) setupGitCredentials(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (bool, error) {
+	data := map[string][]byte{}
+	for i, repo := range devEnv.Spec.Repositories {
+		if repo.CredentialsSecret == "" {
+			continue
+		}
+		source := &corev1.Secret{}
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Name: repo.CredentialsSecret, Namespace: devEnv.Namespace}, source); err != nil {
+			return false, fmt.Errorf("failed to get credentials of repository %s: %w", repo.Path, err)
+		}
+		for _, key := range gitCredentialKeys {
+			if value, ok := source.Data[key]; ok {
+				data[fmt.Sprintf("%d-%s", i, key)] = value
+			}
+		}
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gitCredentialsSecretName(devEnv),
+			Namespace: environmentNamespace(devEnv),
+			Labels: map[string]string{
+				"app":           "vscode-server",
+				"developer-env": devEnv.Name,
+			},
+		},
+		Data: data,
+	}
+	if len(data) == 0 {
+		if err := r.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to delete git credentials secret: %w", err)
+		}
+		return false, nil
+	}
+	if err := r.apply(ctx, devEnv, secret); err != nil {
+		return false, fmt.Errorf("failed to apply git credentials secret: %w", err)
+	}
+	return true, nil
+}
+
+// cloneContainer returns the init container cloning spec.repositories, mounting the credentials if there are any
+func cloneContainer(script string, credentials bool) corev1.Container {
+	container := corev1.Container{
+		Name:            cloneContainerName,
+		Image:           gitImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c", script},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "workspace",
+				MountPath: "/config/workspace",
+			},
+		},
+	}
+	if credentials {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "git-credentials",
+			MountPath: gitCredentialsPath,
+			ReadOnly:  true,
+		})
+	}
+	return container
+}
+
+// gitIdentityEnv configures the author and committer of commits made in the IDE
+func gitIdentityEnv(devEnv *apiv1.DeveloperEnvironment) []corev1.EnvVar {
+	if devEnv.Spec.Git == nil {
+		return nil
+	}
+	var env []corev1.EnvVar
+	if devEnv.Spec.Git.Name != "" {
+		env = append(env,
+			corev1.EnvVar{Name: "GIT_AUTHOR_NAME", Value: devEnv.Spec.Git.Name},
+			corev1.EnvVar{Name: "GIT_COMMITTER_NAME", Value: devEnv.Spec.Git.Name},
+		)
+	}
+	if devEnv.Spec.Git.Email != "" {
+		env = append(env,
+			corev1.EnvVar{Name: "GIT_AUTHOR_EMAIL", Value: devEnv.Spec.Git.Email},
+			corev1.EnvVar{Name: "GIT_COMMITTER_EMAIL", Value: devEnv.Spec.Git.Email},
+		)
+	}
+	return env
+}
+
+// initContainerResults returns the termination message of an init
+// container of the newest IDE pod where it has finished, if any. The clone
+// and extensions init containers report their results this way.
+func (r *DeveloperEnvironmentReconciler) initContainerResults(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	container string,
+) (string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(environmentNamespace(devEnv)), client.MatchingLabels{
+		"app":           "vscode-server",
+		"developer-env": devEnv.Name,
+	}); err != nil {
+		return "", fmt.Errorf("failed to list IDE pods: %w", err)
+	}
+
+	var message string
+	var newest metav1.Time
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name != container || status.State.Terminated == nil {
+				continue
+			}
+			if message == "" || newest.Before(&pod.CreationTimestamp) {
+				message, newest = status.State.Terminated.Message, pod.CreationTimestamp
+			}
+		}
+	}
+	return message, nil
+}
+
+// repositoryStatuses matches the results reported by the clone container to spec.repositories
+func repositoryStatuses(repos []apiv1.RepositorySpec, results string) []apiv1.RepositoryStatus {
+	if len(repos) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(results), "\n")
+	reported := map[string]string{}
+	// Results of an earlier repository list say nothing about the current one
+	if lines[0] == "checksum "+repositoryChecksum(repos) {
+		for _, line := range lines[1:] {
+			if index, result, ok := strings.Cut(line, " "); ok {
+				reported[index] = result
+			}
+		}
+	}
+
+	statuses := make([]apiv1.RepositoryStatus, 0, len(repos))
+	for i, repo := range repos {
+		status := apiv1.RepositoryStatus{Path: repo.Path, URL: repo.URL}
+		result, ok := reported[strconv.Itoa(i)]
+		switch {
+		case !ok:
+		case strings.HasPrefix(result, "! "):
+			status.Message = strings.TrimPrefix(result, "! ")
+		default:
+			status.Commit = result
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// repositoryCondition summarises the repository statuses
+func repositoryCondition(statuses []apiv1.RepositoryStatus) apiv1.Condition {
+	var pending, failed []string
+	for _, status := range statuses {
+		switch {
+		case status.Message != "":
+			failed = append(failed, status.Path)
+		case status.Commit == "":
+			pending = append(pending, status.Path)
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		return conditionFromBool(apiv1.ConditionRepositoriesCloned, false, "CloneFailed",
+			fmt.Sprintf("Failed to clone %s", strings.Join(failed, ", ")))
+	case len(pending) > 0:
+		return conditionFromBool(apiv1.ConditionRepositoriesCloned, false, "Cloning",
+			fmt.Sprintf("Waiting for %s", strings.Join(pending, ", ")))
+	default:
+		return conditionFromBool(apiv1.ConditionRepositoriesCloned, true, "Cloned",
+			fmt.Sprintf("%d repositories cloned", len(statuses)))
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestRepositoryStatuses(t *testing.T) {
+	repos := []apiv1.RepositorySpec{
+		{URL: "https://github.com/example/api.git", Path: "api"},
+		{URL: "git@github.com:example/web.git", Ref: "develop", Path: "web"},
+	}
+	header := "checksum " + repositoryChecksum(repos) + "\n"
+
+	tests := []struct {
+		name    string
+		results string
+		want    []apiv1.RepositoryStatus
+	}{
+		{
+			name: "not cloned yet",
+			want: []apiv1.RepositoryStatus{
+				{Path: "api", URL: repos[0].URL},
+				{Path: "web", URL: repos[1].URL},
+			},
+		},
+		{
+			name:    "cloned and failed",
+			results: header + "0 3f786850e387550fdab836ed7e6dc881de23001b\n1 ! git clone failed\n",
+			want: []apiv1.RepositoryStatus{
+				{Path: "api", URL: repos[0].URL, Commit: "3f786850e387550fdab836ed7e6dc881de23001b"},
+				{Path: "web", URL: repos[1].URL, Message: "git clone failed"},
+			},
+		},
+		{
+			name:    "results of another repository list",
+			results: "checksum 0000000000000000\n0 3f786850e387550fdab836ed7e6dc881de23001b\n",
+			want: []apiv1.RepositoryStatus{
+				{Path: "api", URL: repos[0].URL},
+				{Path: "web", URL: repos[1].URL},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repositoryStatuses(repos, tt.results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("repositoryStatuses() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// EnvironmentDefaults are the operator-wide settings used where an environment leaves them unset
+type EnvironmentDefaults struct {
+	IDEResources      corev1.ResourceRequirements
+	DatabaseResources corev1.ResourceRequirements
+	WorkspaceSize     resource.Quantity
+	DatabaseSize      resource.Quantity
+	// StorageClassName of new volumes; empty uses the cluster default StorageClass
+	StorageClassName string
+	// IDESettings are the VS Code settings of the team, as JSON values by name,
+	// which code-server environments get under their own settings
+	IDESettings map[string]string
+	// ExtensionRegistry is the URL of the Open VSX registry code-server
+	// installs extensions from; empty uses the registry of the image
+	ExtensionRegistry string
+	// ProvisioningTimeout fails environments that take longer to become ready; zero never does
+	ProvisioningTimeout time.Duration
+}
+
+// resolveResources layers the requested resources over the defaults. Limits
+// that only come from the defaults are raised to match larger requests, while
+// a request above an explicitly requested limit is rejected.
+func resolveResources(
+	requested *corev1.ResourceRequirements,
+	defaults corev1.ResourceRequirements,
+) (corev1.ResourceRequirements, error) {
+	resolved := corev1.ResourceRequirements{
+		Requests: mergeResourceLists(defaults.Requests, nil),
+		Limits:   mergeResourceLists(defaults.Limits, nil),
+	}
+	if requested == nil {
+		return resolved, nil
+	}
+	resolved.Requests = mergeResourceLists(resolved.Requests, requested.Requests)
+	resolved.Limits = mergeResourceLists(resolved.Limits, requested.Limits)
+
+	for name, request := range resolved.Requests {
+		limit, ok := resolved.Limits[name]
+		if !ok || request.Cmp(limit) <= 0 {
+			continue
+		}
+		if _, explicit := requested.Limits[name]; explicit {
+			return corev1.ResourceRequirements{}, fmt.Errorf("%s request %s exceeds limit %s", name, request.String(), limit.String())
+		}
+		resolved.Limits[name] = request.DeepCopy()
+	}
+	return resolved, nil
+}
+
+func (r *DeveloperEnvironmentReconciler) ideResources(devEnv *apiv1.DeveloperEnvironment) (corev1.ResourceRequirements, error) {
+	resources, err := resolveResources(devEnv.Spec.IDE.Resources, r.Defaults.IDEResources)
+	if err != nil {
+		return resources, fmt.Errorf("invalid IDE resources: %w", err)
+	}
+	return resources, nil
+}
+
+func (r *DeveloperEnvironmentReconciler) serviceResources(service apiv1.ServiceSpec) (corev1.ResourceRequirements, error) {
+	resources, err := resolveResources(service.Resources, r.Defaults.DatabaseResources)
+	if err != nil {
+		return resources, fmt.Errorf("invalid resources of service %s: %w", service.Name, err)
+	}
+	return resources, nil
+}
+
+// resolvedStorage is the size and class a volume should have
+type resolvedStorage struct {
+	Size             resource.Quantity
+	StorageClassName *string
+}
+
+func (r *DeveloperEnvironmentReconciler) resolveStorage(
+	requested *apiv1.StorageSpec,
+	defaultSize resource.Quantity,
+) (resolvedStorage, error) {
+	storage := resolvedStorage{Size: defaultSize.DeepCopy()}
+	if r.Defaults.StorageClassName != "" {
+		storage.StorageClassName = Ptr(r.Defaults.StorageClassName)
+	}
+	if requested != nil {
+		if requested.Size != nil {
+			storage.Size = requested.Size.DeepCopy()
+		}
+		if requested.StorageClassName != nil {
+			storage.StorageClassName = requested.StorageClassName
+		}
+	}
+	if storage.Size.Sign() <= 0 {
+		return storage, fmt.Errorf("storage size must be positive, got %s", storage.Size.String())
+	}
+	return storage, nil
+}
+
+func (r *DeveloperEnvironmentReconciler) workspaceStorage(devEnv *apiv1.DeveloperEnvironment) (resolvedStorage, error) {
+	var requested *apiv1.StorageSpec
+	if devEnv.Spec.Workspace != nil {
+		requested = devEnv.Spec.Workspace.Storage
+	}
+	storage, err := r.resolveStorage(requested, r.Defaults.WorkspaceSize)
+	if err != nil {
+		return storage, fmt.Errorf("invalid workspace storage: %w", err)
+	}
+	return storage, nil
+}
+
+func (r *DeveloperEnvironmentReconciler) serviceStorage(service apiv1.ServiceSpec) (resolvedStorage, error) {
+	storage, err := r.resolveStorage(service.Storage, r.Defaults.DatabaseSize)
+	if err != nil {
+		return storage, fmt.Errorf("invalid storage of service %s: %w", service.Name, err)
+	}
+	return storage, nil
+}
+
+// applyPVC applies a claim of the resolved size and class. An existing claim
+// is only ever grown, and keeps its StorageClass, volume and data source since
+// those are immutable; the StorageReady condition reports such differences.
+func (r *DeveloperEnvironmentReconciler) applyPVC(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	pvc *corev1.PersistentVolumeClaim,
+	storage resolvedStorage,
+) error {
+	pvc.Spec.Resources.Requests = corev1.ResourceList{
+		corev1.ResourceStorage: storage.Size,
+	}
+	pvc.Spec.StorageClassName = storage.StorageClassName
+
+	existing := &corev1.PersistentVolumeClaim{}
+	err := r.getChild(ctx, types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get PVC %s: %w", pvc.Name, err)
+	}
+	if err == nil {
+		pvc.Spec.StorageClassName = existing.Spec.StorageClassName
+		pvc.Spec.VolumeName = existing.Spec.VolumeName
+		pvc.Spec.DataSource = existing.Spec.DataSource
+		current := existing.Spec.Resources.Requests[corev1.ResourceStorage]
+		if storage.Size.Cmp(current) < 0 {
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = current
+		}
+	}
+
+	return r.apply(ctx, devEnv, pvc)
+}
+
+// storageCondition compares the environment volumes with the requested storage
+func (r *DeveloperEnvironmentReconciler) storageCondition(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (apiv1.Condition, error) {
+	workspace, err := r.workspaceStorage(devEnv)
+	if err != nil {
+		return conditionFromBool(apiv1.ConditionStorageReady, false, "InvalidStorage", err.Error()), nil
+	}
+	volumes := map[string]resolvedStorage{
+		workspacePVCName(devEnv): workspace,
+	}
+	for _, service := range devEnv.Spec.EffectiveServices() {
+		storage, err := r.serviceStorage(service)
+		if err != nil {
+			return conditionFromBool(apiv1.ConditionStorageReady, false, "InvalidStorage", err.Error()), nil
+		}
+		volumes[servicePVCName(devEnv, service.Name)] = storage
+	}
+
+	var problems []string
+	reason := "Bound"
+	for name, storage := range volumes {
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: environmentNamespace(devEnv)}, pvc); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return apiv1.Condition{}, fmt.Errorf("failed to get PVC %s: %w", name, err)
+		}
+
+		requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		capacity, bound := pvc.Status.Capacity[corev1.ResourceStorage]
+		switch {
+		case storage.Size.Cmp(requested) < 0:
+			reason = "ShrinkRejected"
+			problems = append(problems, fmt.Sprintf("%s cannot shrink from %s to %s", name, requested.String(), storage.Size.String()))
+		case bound && capacity.Cmp(requested) < 0:
+			if reason == "Bound" {
+				reason = "Resizing"
+			}
+			problems = append(problems, fmt.Sprintf("%s is being resized from %s to %s", name, capacity.String(), requested.String()))
+		}
+		if storage.StorageClassName != nil && pvc.Spec.StorageClassName != nil &&
+			*storage.StorageClassName != *pvc.Spec.StorageClassName {
+			reason = "StorageClassImmutable"
+			problems = append(problems, fmt.Sprintf("%s keeps StorageClass %s, requested %s",
+				name, *pvc.Spec.StorageClassName, *storage.StorageClassName))
+		}
+		for _, c := range pvc.Status.Conditions {
+			if c.Type == corev1.PersistentVolumeClaimFileSystemResizePending && c.Status == corev1.ConditionTrue {
+				if reason == "Bound" {
+					reason = "Resizing"
+				}
+				problems = append(problems, fmt.Sprintf("%s waits for its pod to restart to finish resizing", name))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return conditionFromBool(apiv1.ConditionStorageReady, false, reason, strings.Join(problems, "; ")), nil
+	}
+	return conditionFromBool(apiv1.ConditionStorageReady, true, reason, ""), nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func resourceList(cpu, memory string) corev1.ResourceList {
+	list := corev1.ResourceList{}
+	if cpu != "" {
+		list[corev1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		list[corev1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return list
+}
+
+func TestResolveResources(t *testing.T) {
+	defaults := corev1.ResourceRequirements{
+		Requests: resourceList("500m", "512Mi"),
+		Limits:   resourceList("1", "1Gi"),
+	}
+	tests := []struct {
+		name      string
+		requested *corev1.ResourceRequirements
+		want      corev1.ResourceRequirements
+		wantErr   bool
+	}{
+		{
+			name: "defaults only",
+			want: defaults,
+		},
+		{
+			name:      "partial override",
+			requested: &corev1.ResourceRequirements{Requests: resourceList("", "768Mi")},
+			want: corev1.ResourceRequirements{
+				Requests: resourceList("500m", "768Mi"),
+				Limits:   resourceList("1", "1Gi"),
+			},
+		},
+		{
+			name:      "request above default limit raises it",
+			requested: &corev1.ResourceRequirements{Requests: resourceList("2", "")},
+			want: corev1.ResourceRequirements{
+				Requests: resourceList("2", "512Mi"),
+				Limits:   resourceList("2", "1Gi"),
+			},
+		},
+		{
+			name: "request above explicit limit",
+			requested: &corev1.ResourceRequirements{
+				Requests: resourceList("2", ""),
+				Limits:   resourceList("1500m", ""),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveResources(tt.requested, defaults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, pair := range []struct{ got, want corev1.ResourceList }{
+				{got.Requests, tt.want.Requests},
+				{got.Limits, tt.want.Limits},
+			} {
+				if len(pair.got) != len(pair.want) {
+					t.Fatalf("resolveResources() = %v, want %v", got, tt.want)
+				}
+				for name, want := range pair.want {
+					if q := pair.got[name]; q.Cmp(want) != 0 {
+						t.Errorf("%s = %s, want %s", name, q.String(), want.String())
+					}
+				}
+			}
+		})
+	}
+}
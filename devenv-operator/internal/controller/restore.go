@@ -0,0 +1,504 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// restoreName names the objects restoring a volume of an environment
+func restoreName(devEnv *apiv1.DeveloperEnvironment, volume string) string {
+	return fmt.Sprintf("%s-%s-restore", devEnv.Name, volume)
+}
+
+// restoreVolumes selects the volumes of a snapshot the environment has: the
+// workspace, and the backing services of the same name and type
+func restoreVolumes(devEnv *apiv1.DeveloperEnvironment, volumes []apiv1.SnapshotVolume) []apiv1.SnapshotVolume {
+	var matched []apiv1.SnapshotVolume
+	for _, volume := range volumes {
+		if volume.Phase != apiv1.SnapshotPhaseReady {
+			continue
+		}
+		if volume.Name == workspaceVolume && volume.Type == "" {
+			matched = append(matched, volume)
+			continue
+		}
+		for _, service := range devEnv.Spec.EffectiveServices() {
+			if service.Name == volume.Name && service.Type == volume.Type {
+				matched = append(matched, volume)
+			}
+		}
+	}
+	return matched
+}
+
+// isDump tells the database dumps of a snapshot, which are loaded by a seed
+// Job once the service runs, from the volume archives and VolumeSnapshots,
+// which are restored before anything mounts the volume
+func isDump(volume apiv1.SnapshotVolume) bool {
+	return volume.Key != "" && !strings.HasSuffix(volume.Key, archiveExt)
+}
+
+// restoreSnapshot creates the volumes of an environment from the snapshot of
+// spec.restoreFrom. It returns false while volumes are being restored, which
+// must happen before the IDE and the backing services mount them.
+func (r *DeveloperEnvironmentReconciler) restoreSnapshot(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) (bool, error) {
+	if devEnv.Spec.RestoreFrom == "" {
+		devEnv.Status.Restore = nil
+		return true, nil
+	}
+	status := devEnv.Status.Restore
+	if status == nil || status.Snapshot != devEnv.Spec.RestoreFrom {
+		status = &apiv1.RestoreStatus{Snapshot: devEnv.Spec.RestoreFrom, Phase: apiv1.RestorePhasePending}
+		devEnv.Status.Restore = status
+	}
+	if status.Phase == apiv1.RestorePhaseSucceeded || status.Phase == apiv1.RestorePhaseFailed {
+		return true, nil
+	}
+
+	snapshot := &apiv1.DeveloperEnvironmentSnapshot{}
+	err := r.Get(ctx, types.NamespacedName{Name: devEnv.Spec.RestoreFrom, Namespace: devEnv.Namespace}, snapshot)
+	if apierrors.IsNotFound(err) {
+		status.Phase = apiv1.RestorePhaseFailed
+		status.Message = fmt.Sprintf("DeveloperEnvironmentSnapshot %s not found", devEnv.Spec.RestoreFrom)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get snapshot %s: %w", devEnv.Spec.RestoreFrom, err)
+	}
+	switch snapshot.Status.Phase {
+	case apiv1.SnapshotPhaseReady:
+	case apiv1.SnapshotPhaseFailed:
+		status.Phase = apiv1.RestorePhaseFailed
+		status.Message = fmt.Sprintf("DeveloperEnvironmentSnapshot %s failed", snapshot.Name)
+		return true, nil
+	default:
+		status.Message = fmt.Sprintf("Waiting for DeveloperEnvironmentSnapshot %s to be ready", snapshot.Name)
+		return false, nil
+	}
+
+	passwords := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: snapshotPasswordsName(snapshot), Namespace: snapshot.Namespace}, passwords)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get passwords of snapshot %s: %w", snapshot.Name, err)
+	}
+
+	status.Method = snapshot.Status.Method
+	status.Phase = apiv1.RestorePhaseRunning
+	status.Message = ""
+	var restored, pending, failed []string
+	mounted := true
+	for _, volume := range restoreVolumes(devEnv, snapshot.Status.Volumes) {
+		// A restored data directory only accepts the password it was initialised
+		// with, while a dump is loaded into a database of the environment
+		if password, ok := passwords.Data[volume.Name]; ok && !isDump(volume) {
+			if err := r.seedServicePassword(ctx, devEnv, volume.Name, password); err != nil {
+				return false, err
+			}
+		}
+
+		var phase apiv1.RestorePhase
+		switch {
+		case volume.VolumeSnapshotContentName != "":
+			phase, err = r.restoreVolumeSnapshot(ctx, devEnv, volume)
+		case isDump(volume):
+			// Dumps are loaded by the seed Job of the service, see restoreSeed
+			phase = apiv1.RestorePhaseRunning
+			if seed := serviceSeedStatus(devEnv, volume.Name); seed != nil {
+				switch seed.Phase {
+				case apiv1.SeedPhaseSucceeded:
+					phase = apiv1.RestorePhaseSucceeded
+				case apiv1.SeedPhaseFailed:
+					phase = apiv1.RestorePhaseFailed
+				}
+			}
+		default:
+			phase, err = r.restoreArchive(ctx, devEnv, snapshot, volume)
+			if phase == apiv1.RestorePhaseRunning {
+				mounted = false
+			}
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to restore volume %s: %w", volume.Name, err)
+		}
+		switch phase {
+		case apiv1.RestorePhaseSucceeded:
+			restored = append(restored, volume.Name)
+		case apiv1.RestorePhaseFailed:
+			failed = append(failed, volume.Name)
+		default:
+			pending = append(pending, volume.Name)
+		}
+	}
+
+	status.Volumes = restored
+	switch {
+	case len(pending) > 0:
+		status.Message = fmt.Sprintf("Restoring %s", strings.Join(pending, ", "))
+	case len(failed) > 0:
+		status.Phase = apiv1.RestorePhaseFailed
+		status.Message = fmt.Sprintf("Failed to restore %s", strings.Join(failed, ", "))
+	default:
+		status.Phase = apiv1.RestorePhaseSucceeded
+	}
+	return mounted, nil
+}
+
+// serviceSeedStatus returns the seed progress of a backing service, if any
+func serviceSeedStatus(devEnv *apiv1.DeveloperEnvironment, service string) *apiv1.SeedStatus {
+	for _, status := range devEnv.Status.Services {
+		if status.Name == service {
+			return status.Seed
+		}
+	}
+	return nil
+}
+
+// volumeClaim returns the claim of a volume of a snapshot and its requested
+// storage, grown to the size of the volume when it was captured
+func (r *DeveloperEnvironmentReconciler) volumeClaim(
+	devEnv *apiv1.DeveloperEnvironment,
+	volume apiv1.SnapshotVolume,
+) (*corev1.PersistentVolumeClaim, resolvedStorage, error) {
+	var (
+		pvc     *corev1.PersistentVolumeClaim
+		storage resolvedStorage
+		err     error
+	)
+	if volume.Name == workspaceVolume && volume.Type == "" {
+		pvc = workspacePVC(devEnv)
+		storage, err = r.workspaceStorage(devEnv)
+	} else {
+		for _, service := range devEnv.Spec.EffectiveServices() {
+			if service.Name == volume.Name {
+				pvc = servicePVC(devEnv, service.Name)
+				storage, err = r.serviceStorage(service)
+			}
+		}
+	}
+	if err != nil {
+		return nil, storage, err
+	}
+	if volume.Size != nil && storage.Size.Cmp(*volume.Size) < 0 {
+		storage.Size = volume.Size.DeepCopy()
+	}
+	return pvc, storage, nil
+}
+
+// restoreVolumeSnapshot creates the claim of a volume from its CSI snapshot.
+// The VolumeSnapshotContent of the snapshot is bound to a VolumeSnapshot in
+// the namespace of the captured environment, so a retained copy of it is
+// bound to a VolumeSnapshot next to the claim.
+func (r *DeveloperEnvironmentReconciler) restoreVolumeSnapshot(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	volume apiv1.SnapshotVolume,
+) (apiv1.RestorePhase, error) {
+	pvc, storage, err := r.volumeClaim(devEnv, volume)
+	if err != nil {
+		return "", err
+	}
+	err = r.Get(ctx, types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, &corev1.PersistentVolumeClaim{})
+	if err == nil {
+		return apiv1.RestorePhaseSucceeded, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get PVC %s: %w", pvc.Name, err)
+	}
+
+	original := &unstructured.Unstructured{}
+	original.SetGroupVersionKind(volumeSnapshotContentGVK)
+	if err := r.Get(ctx, types.NamespacedName{Name: volume.VolumeSnapshotContentName}, original); err != nil {
+		return "", fmt.Errorf("failed to get VolumeSnapshotContent %s: %w", volume.VolumeSnapshotContentName, err)
+	}
+	driver, _, _ := unstructured.NestedString(original.Object, "spec", "driver")
+	class, _, _ := unstructured.NestedString(original.Object, "spec", "volumeSnapshotClassName")
+	handle, _, _ := unstructured.NestedString(original.Object, "status", "snapshotHandle")
+	if handle == "" {
+		return "", fmt.Errorf("VolumeSnapshotContent %s has no snapshot handle", original.GetName())
+	}
+
+	name := restoreName(devEnv, volume.Name)
+	content := &unstructured.Unstructured{}
+	content.SetGroupVersionKind(volumeSnapshotContentGVK)
+	content.SetName(fmt.Sprintf("%s-%s", environmentNamespace(devEnv), name))
+	content.Object["spec"] = map[string]interface{}{
+		// Deleting the copy must leave the snapshot to the original
+		"deletionPolicy": "Retain",
+		"driver":         driver,
+		"source": map[string]interface{}{
+			"snapshotHandle": handle,
+		},
+		"volumeSnapshotRef": map[string]interface{}{
+			"name":      name,
+			"namespace": environmentNamespace(devEnv),
+		},
+	}
+	volumeSnapshot := &unstructured.Unstructured{}
+	volumeSnapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	volumeSnapshot.SetName(name)
+	volumeSnapshot.SetNamespace(environmentNamespace(devEnv))
+	volumeSnapshot.Object["spec"] = map[string]interface{}{
+		"source": map[string]interface{}{
+			"volumeSnapshotContentName": content.GetName(),
+		},
+	}
+	if class != "" {
+		_ = unstructured.SetNestedField(content.Object, class, "spec", "volumeSnapshotClassName")
+		_ = unstructured.SetNestedField(volumeSnapshot.Object, class, "spec", "volumeSnapshotClassName")
+	}
+	if err := r.apply(ctx, devEnv, content); err != nil {
+		return "", fmt.Errorf("failed to apply VolumeSnapshotContent %s: %w", content.GetName(), err)
+	}
+	if err := r.apply(ctx, devEnv, volumeSnapshot); err != nil {
+		return "", fmt.Errorf("failed to apply VolumeSnapshot %s: %w", name, err)
+	}
+
+	pvc.Spec.DataSource = &corev1.TypedLocalObjectReference{
+		APIGroup: Ptr(volumeSnapshotGVK.Group),
+		Kind:     volumeSnapshotGVK.Kind,
+		Name:     name,
+	}
+	if err := r.applyPVC(ctx, devEnv, pvc, storage); err != nil {
+		return "", err
+	}
+	return apiv1.RestorePhaseSucceeded, nil
+}
+
+// restoreArchive extracts the archive of a volume into its claim with a
+// one-shot Job
+func (r *DeveloperEnvironmentReconciler) restoreArchive(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	snapshot *apiv1.DeveloperEnvironmentSnapshot,
+	volume apiv1.SnapshotVolume,
+) (apiv1.RestorePhase, error) {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: restoreName(devEnv, volume.Name), Namespace: environmentNamespace(devEnv)}, job)
+	if err == nil {
+		switch seedJobStatus(job, "").Phase {
+		case apiv1.SeedPhaseSucceeded:
+			return apiv1.RestorePhaseSucceeded, nil
+		case apiv1.SeedPhaseFailed:
+			return apiv1.RestorePhaseFailed, nil
+		}
+		return apiv1.RestorePhaseRunning, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get restore job: %w", err)
+	}
+
+	pvc, storage, err := r.volumeClaim(devEnv, volume)
+	if err != nil {
+		return "", err
+	}
+	if err := r.applyPVC(ctx, devEnv, pvc, storage); err != nil {
+		return "", err
+	}
+
+	source := &corev1.Secret{}
+	if err := r.APIReader.Get(ctx, types.NamespacedName{Name: snapshot.Spec.S3.CredentialsSecret, Namespace: devEnv.Namespace}, source); err != nil {
+		return "", fmt.Errorf("failed to get snapshot credentials: %w", err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restoreName(devEnv, "s3"),
+			Namespace: environmentNamespace(devEnv),
+		},
+		Data: map[string][]byte{
+			"accesskey": source.Data["accesskey"],
+			"secretkey": source.Data["secretkey"],
+		},
+	}
+	if err := r.apply(ctx, devEnv, secret); err != nil {
+		return "", fmt.Errorf("failed to apply snapshot credentials: %w", err)
+	}
+
+	if err := r.apply(ctx, devEnv, restoreJob(devEnv, snapshot, volume, pvc.Name)); err != nil {
+		return "", fmt.Errorf("failed to apply restore job: %w", err)
+	}
+	return apiv1.RestorePhaseRunning, nil
+}
+
+// restoreJob returns the Job extracting the archive of a volume into its claim
+func restoreJob(
+	devEnv *apiv1.DeveloperEnvironment,
+	snapshot *apiv1.DeveloperEnvironmentSnapshot,
+	volume apiv1.SnapshotVolume,
+	claim string,
+) *batchv1.Job {
+	s3 := &apiv1.S3Location{
+		Endpoint: snapshot.Spec.S3.Endpoint,
+		Bucket:   snapshot.Spec.S3.Bucket,
+		Key:      volume.Key,
+	}
+	labels := map[string]string{
+		"app":           "volume-restore",
+		"developer-env": devEnv.Name,
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      restoreName(devEnv, volume.Name),
+			Namespace: environmentNamespace(devEnv),
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: Ptr(int32(2)),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:  corev1.RestartPolicyNever,
+					InitContainers: []corev1.Container{downloadContainer(s3, restoreName(devEnv, "s3"))},
+					Containers: []corev1.Container{
+						{
+							Name:    "extract",
+							Image:   archiveImage,
+							Command: []string{"tar", "xzf", seedPath + "/" + path.Base(volume.Key), "-C", "/data"},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "seed", MountPath: seedPath},
+								{Name: "data", MountPath: "/data"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name:         "seed",
+							VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+						},
+						{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: claim,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// restoreSeed loads the dump of a backing service from the snapshot the
+// environment is being restored from, in place of the seed of the service
+func (r *DeveloperEnvironmentReconciler) restoreSeed(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	service apiv1.ServiceSpec,
+) (apiv1.ServiceSpec, error) {
+	restore := devEnv.Status.Restore
+	if restore == nil || restore.Phase != apiv1.RestorePhaseRunning || restore.Method != apiv1.SnapshotMethodS3 {
+		return service, nil
+	}
+	snapshot := &apiv1.DeveloperEnvironmentSnapshot{}
+	if err := r.Get(ctx, types.NamespacedName{Name: restore.Snapshot, Namespace: devEnv.Namespace}, snapshot); err != nil {
+		return service, fmt.Errorf("failed to get snapshot %s: %w", restore.Snapshot, err)
+	}
+	for _, volume := range restoreVolumes(devEnv, snapshot.Status.Volumes) {
+		if volume.Name == service.Name && isDump(volume) {
+			service.Seed = &apiv1.SeedSpec{S3: &apiv1.S3Location{
+				Endpoint:          snapshot.Spec.S3.Endpoint,
+				Bucket:            snapshot.Spec.S3.Bucket,
+				Key:               volume.Key,
+				CredentialsSecret: snapshot.Spec.S3.CredentialsSecret,
+			}}
+		}
+	}
+	return service, nil
+}
+
+// restoreCondition summarises the restore of spec.restoreFrom. It returns
+// false when the environment is not restored from a snapshot.
+func restoreCondition(restore *apiv1.RestoreStatus) (apiv1.Condition, bool) {
+	switch {
+	case restore == nil:
+		return apiv1.Condition{}, false
+	case restore.Phase == apiv1.RestorePhaseSucceeded:
+		return conditionFromBool(apiv1.ConditionRestored, true, "Restored",
+			fmt.Sprintf("Restored %s from %s", strings.Join(restore.Volumes, ", "), restore.Snapshot)), true
+	case restore.Phase == apiv1.RestorePhaseFailed:
+		return conditionFromBool(apiv1.ConditionRestored, false, "RestoreFailed", restore.Message), true
+	default:
+		return conditionFromBool(apiv1.ConditionRestored, false, "Restoring", restore.Message), true
+	}
+}
+
+// deleteRestoredContents deletes the copies of VolumeSnapshotContents the
+// environment was restored from. They are retained, so the snapshots on the
+// storage backend stay with the DeveloperEnvironmentSnapshot.
+func (r *DeveloperEnvironmentReconciler) deleteRestoredContents(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	contents := &unstructured.UnstructuredList{}
+	contents.SetGroupVersionKind(volumeSnapshotContentGVK.GroupVersion().WithKind(volumeSnapshotContentGVK.Kind + "List"))
+	err := r.List(ctx, contents, client.MatchingLabels{
+		labelEnvironment:          devEnv.Name,
+		labelEnvironmentNamespace: devEnv.Namespace,
+	})
+	if meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list restored VolumeSnapshotContents: %w", err)
+	}
+	for i := range contents.Items {
+		if err := r.Delete(ctx, &contents.Items[i]); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete VolumeSnapshotContent %s: %w", contents.Items[i].GetName(), err)
+		}
+	}
+	return nil
+}
+
+// environmentsForSnapshot maps a DeveloperEnvironmentSnapshot to the environments restored from it, and to the environment it captures
+func (r *DeveloperEnvironmentReconciler) environmentsForSnapshot(ctx context.Context, obj client.Object) []reconcile.Request {
+	snapshot, ok := obj.(*apiv1.DeveloperEnvironmentSnapshot)
+	if !ok {
+		return nil
+	}
+	devEnvs := &apiv1.DeveloperEnvironmentList{}
+	if err := r.List(ctx, devEnvs, client.InNamespace(snapshot.Namespace)); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list developer environments for snapshot", "snapshot", snapshot.Name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, devEnv := range devEnvs.Items {
+		// An environment deleted with the Snapshot policy waits for its snapshot
+		if devEnv.Spec.RestoreFrom == snapshot.Name || devEnv.Name == snapshot.Spec.EnvironmentName {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&devEnv)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestRestoreVolumes(t *testing.T) {
+	devEnv := &apiv1.DeveloperEnvironment{
+		ObjectMeta: metav1.ObjectMeta{Name: "copy", Namespace: "default"},
+		Spec: apiv1.DeveloperEnvironmentSpec{
+			Services: []apiv1.ServiceSpec{
+				{Name: "database", DatabaseSpec: apiv1.DatabaseSpec{Type: "postgres"}},
+				{Name: "cache", DatabaseSpec: apiv1.DatabaseSpec{Type: "redis"}},
+			},
+		},
+	}
+	volumes := []apiv1.SnapshotVolume{
+		{Name: "workspace", Phase: apiv1.SnapshotPhaseReady},
+		{Name: "database", Type: "postgres", Phase: apiv1.SnapshotPhaseReady},
+		{Name: "cache", Type: "valkey", Phase: apiv1.SnapshotPhaseReady},
+		{Name: "events", Type: "kafka", Phase: apiv1.SnapshotPhaseReady},
+	}
+
+	var got []string
+	for _, volume := range restoreVolumes(devEnv, volumes) {
+		got = append(got, volume.Name)
+	}
+	if want := []string{"workspace", "database"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restoreVolumes() = %v, want %v", got, want)
+	}
+}
+
+func TestIsDump(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "", want: false},
+		{key: "envs/monday/workspace.tar.gz", want: false},
+		{key: "envs/monday/database.dump", want: true},
+		{key: "envs/monday/db.sql.gz", want: true},
+	}
+	for _, tt := range tests {
+		if got := isDump(apiv1.SnapshotVolume{Key: tt.key}); got != tt.want {
+			t.Errorf("isDump(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestRestoreCondition(t *testing.T) {
+	tests := []struct {
+		name       string
+		restore    *apiv1.RestoreStatus
+		wantOK     bool
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{
+			name: "not restored",
+		},
+		{
+			name:       "waiting for the snapshot",
+			restore:    &apiv1.RestoreStatus{Snapshot: "monday", Phase: apiv1.RestorePhasePending},
+			wantOK:     true,
+			wantStatus: metav1.ConditionFalse,
+			wantReason: "Restoring",
+		},
+		{
+			name:       "restored",
+			restore:    &apiv1.RestoreStatus{Snapshot: "monday", Phase: apiv1.RestorePhaseSucceeded, Volumes: []string{"workspace"}},
+			wantOK:     true,
+			wantStatus: metav1.ConditionTrue,
+			wantReason: "Restored",
+		},
+		{
+			name:       "failed",
+			restore:    &apiv1.RestoreStatus{Snapshot: "monday", Phase: apiv1.RestorePhaseFailed, Message: "Failed to restore database"},
+			wantOK:     true,
+			wantStatus: metav1.ConditionFalse,
+			wantReason: "RestoreFailed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := restoreCondition(tt.restore)
+			if ok != tt.wantOK {
+				t.Fatalf("restoreCondition() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (got.Status != string(tt.wantStatus) || got.Reason != tt.wantReason) {
+				t.Errorf("restoreCondition() = %s/%s, want %s/%s", got.Status, got.Reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRestoreSnapshotPasswords(t *testing.T) {
+	ctx := context.Background()
+	services := []apiv1.ServiceSpec{
+		{Name: "database", DatabaseSpec: apiv1.DatabaseSpec{Type: "postgres", Version: "16"}},
+		{Name: "documents", DatabaseSpec: apiv1.DatabaseSpec{Type: "mongodb", Version: "7"}},
+	}
+	source := &apiv1.DeveloperEnvironment{
+		ObjectMeta: metav1.ObjectMeta{Name: "golang-env", Namespace: "team"},
+		Spec:       apiv1.DeveloperEnvironmentSpec{Services: services},
+		Status:     apiv1.DeveloperEnvironmentStatus{Namespace: "team"},
+	}
+	snapshot := &apiv1.DeveloperEnvironmentSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "monday", Namespace: "team"},
+		Spec:       apiv1.DeveloperEnvironmentSnapshotSpec{EnvironmentName: source.Name},
+		Status: apiv1.DeveloperEnvironmentSnapshotStatus{
+			Phase:     apiv1.SnapshotPhaseReady,
+			Namespace: "team",
+			Volumes: []apiv1.SnapshotVolume{
+				{Name: "database", Type: "postgres", Phase: apiv1.SnapshotPhaseReady, VolumeSnapshotContentName: "content"},
+				{Name: "documents", Type: "mongodb", Phase: apiv1.SnapshotPhaseReady, Key: "envs/monday/documents.archive.gz"},
+			},
+		},
+	}
+	connection := func(devEnv *apiv1.DeveloperEnvironment, service, password string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: serviceConnectionSecretName(devEnv, service), Namespace: "team"},
+			Data:       map[string][]byte{"password": []byte(password)},
+		}
+	}
+	target := &apiv1.DeveloperEnvironment{
+		ObjectMeta: metav1.ObjectMeta{Name: "golang-env-copy", Namespace: "team"},
+		Spec:       apiv1.DeveloperEnvironmentSpec{Services: services, RestoreFrom: snapshot.Name},
+		Status:     apiv1.DeveloperEnvironmentStatus{Namespace: "team"},
+	}
+	// The claim of the database was already created from its VolumeSnapshot
+	restored := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: servicePVCName(target, "database"), Namespace: "team"},
+	}
+	r := fakeReconciler(t, snapshot, restored,
+		connection(source, "database", "source"), connection(source, "documents", "source"),
+		connection(target, "documents", "target"))
+	r.Defaults.DatabaseSize = resource.MustParse("1Gi")
+
+	snapshots := &DeveloperEnvironmentSnapshotReconciler{Client: r.Client, APIReader: r.APIReader, Scheme: r.Scheme}
+	if err := snapshots.capturePasswords(ctx, snapshot, source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.restoreSnapshot(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+
+	for service, want := range map[string]string{
+		// The data directory of the database was initialised with the password of the source
+		"database": "source",
+		// The dump is loaded into the database of the target
+		"documents": "target",
+	} {
+		password, err := r.servicePassword(ctx, target, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if password != want {
+			t.Errorf("servicePassword(%s) after restore = %q, want %q", service, password, want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+const (
+	// labelRetainedOwner, labelRetainedVolume and labelRetainedType mark a
+	// PersistentVolume retained by a deleted environment, for the next
+	// environment of the same owner to adopt. Owners are user names, which
+	// are not valid label values, so the owner label holds a hash of it.
+	labelRetainedOwner  = "devenv.adityajoshi.online/retained-owner"
+	labelRetainedVolume = "devenv.adityajoshi.online/retained-volume"
+	labelRetainedType   = "devenv.adityajoshi.online/retained-type"
+
+	// annotationRetainedOwner holds the owner of a retained volume as is
+	annotationRetainedOwner = "devenv.adityajoshi.online/retained-owner"
+)
+
+// retainedOwner identifies the owner of an environment in the labels of its
+// retained volumes. Environments without an owner, created while the
+// webhooks were disabled, are owned by their namespaced name.
+func retainedOwner(devEnv *apiv1.DeveloperEnvironment) string {
+	owner := devEnv.Spec.Owner
+	if owner == "" {
+		owner = devEnv.Namespace + "/" + devEnv.Name
+	}
+	return checksum(map[string]string{"owner": owner})
+}
+
+// deletionSnapshotName names the snapshot taken by the Snapshot deletion
+// policy, within the 30 characters allowed for snapshot names
+func deletionSnapshotName(devEnv *apiv1.DeveloperEnvironment) string {
+	name := devEnv.Name
+	if len(name) > 21 {
+		name = name[:21]
+	}
+	return fmt.Sprintf("%s-%s", name, checksum(map[string]string{"uid": string(devEnv.UID)})[:8])
+}
+
+// takeDeletionSnapshot takes a snapshot of an environment being deleted with
+// the Snapshot deletion policy and returns its phase
+func (r *DeveloperEnvironmentReconciler) takeDeletionSnapshot(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (apiv1.SnapshotPhase, error) {
+	snapshot := &apiv1.DeveloperEnvironmentSnapshot{}
+	key := types.NamespacedName{Name: deletionSnapshotName(devEnv), Namespace: devEnv.Namespace}
+	err := r.Get(ctx, key, snapshot)
+	if err == nil {
+		return snapshot.Status.Phase, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get deletion snapshot: %w", err)
+	}
+	if devEnv.Status.DeletionSnapshot == key.Name {
+		// Deleted before it was ready
+		return apiv1.SnapshotPhaseFailed, nil
+	}
+
+	// The snapshot outlives the environment, so it is not owned by it
+	snapshot = &apiv1.DeveloperEnvironmentSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		},
+		Spec: apiv1.DeveloperEnvironmentSnapshotSpec{
+			EnvironmentName: devEnv.Name,
+		},
+	}
+	if options := devEnv.Spec.DeletionSnapshot; options != nil {
+		snapshot.Spec.SnapshotOptions = *options
+	}
+	if err := r.Create(ctx, snapshot); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("failed to create deletion snapshot: %w", err)
+	}
+	devEnv.Status.DeletionSnapshot = key.Name
+	if err := r.Status().Update(ctx, devEnv); err != nil {
+		return "", err
+	}
+	return apiv1.SnapshotPhasePending, nil
+}
+
+// retainVolumes keeps the volumes of an environment when their claims are
+// deleted with it, labeled for the next environment of the same owner in the
+// same namespace
+func (r *DeveloperEnvironmentReconciler) retainVolumes(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	for _, volume := range snapshotVolumes(devEnv) {
+		pvc := &corev1.PersistentVolumeClaim{}
+		err := r.getChild(ctx, types.NamespacedName{Name: volume.ClaimName, Namespace: environmentNamespace(devEnv)}, pvc)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get PVC %s: %w", volume.ClaimName, err)
+		}
+		if pvc.Spec.VolumeName == "" {
+			continue
+		}
+
+		pv := &corev1.PersistentVolume{}
+		if err := r.Get(ctx, client.ObjectKey{Name: pvc.Spec.VolumeName}, pv); err != nil {
+			return fmt.Errorf("failed to get PV %s: %w", pvc.Spec.VolumeName, err)
+		}
+		patch := client.MergeFrom(pv.DeepCopy())
+		if pv.Labels == nil {
+			pv.Labels = map[string]string{}
+		}
+		if pv.Annotations == nil {
+			pv.Annotations = map[string]string{}
+		}
+		if pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+			pv.Annotations[annotationReclaimPolicy] = string(pv.Spec.PersistentVolumeReclaimPolicy)
+			pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+		}
+		pv.Labels[labelRetainedOwner] = retainedOwner(devEnv)
+		pv.Labels[labelEnvironmentNamespace] = devEnv.Namespace
+		pv.Labels[labelRetainedVolume] = volume.Name
+		if volume.Type != "" {
+			pv.Labels[labelRetainedType] = volume.Type
+		}
+		pv.Annotations[annotationRetainedOwner] = devEnv.Spec.Owner
+		if err := r.Patch(ctx, pv, patch); err != nil {
+			return fmt.Errorf("failed to retain PV %s: %w", pv.Name, err)
+		}
+		if err := r.retainPassword(ctx, devEnv, volume, pv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retainedPasswordSecretName names the Secret keeping the password a retained
+// database volume was initialised with
+func retainedPasswordSecretName(pv *corev1.PersistentVolume) string {
+	return pv.Name + "-password"
+}
+
+// retainPassword keeps the password of the service a retained volume belongs
+// to next to the environment, as the database in it only accepts that one.
+// The Secret is not owned by the environment, so it outlives it.
+func (r *DeveloperEnvironmentReconciler) retainPassword(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	volume apiv1.SnapshotVolume,
+	pv *corev1.PersistentVolume,
+) error {
+	if !serviceTypes[volume.Type].password {
+		return nil
+	}
+	connection := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      serviceConnectionSecretName(devEnv, volume.Name),
+		Namespace: environmentNamespace(devEnv),
+	}, connection)
+	if apierrors.IsNotFound(err) || len(connection.Data["password"]) == 0 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get connection secret of service %s: %w", volume.Name, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      retainedPasswordSecretName(pv),
+			Namespace: devEnv.Namespace,
+			Labels: map[string]string{
+				labelRetainedOwner:        retainedOwner(devEnv),
+				labelRetainedVolume:       volume.Name,
+				labelEnvironmentNamespace: devEnv.Namespace,
+				labelManagedBy:            managedBy,
+			},
+		},
+		Data: map[string][]byte{"password": connection.Data["password"]},
+	}
+	err = r.Create(ctx, secret)
+	if apierrors.IsAlreadyExists(err) {
+		err = r.Update(ctx, secret)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retain the password of service %s: %w", volume.Name, err)
+	}
+	return nil
+}
+
+// adoptRetainedVolumes binds the claims the environment does not have yet to
+// the volumes a deleted environment of the same owner and namespace retained,
+// provided they belong to a volume of the same name and type. Volumes
+// restored from a snapshot take precedence.
+func (r *DeveloperEnvironmentReconciler) adoptRetainedVolumes(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	if devEnv.Spec.RestoreFrom != "" {
+		return nil
+	}
+	for _, volume := range snapshotVolumes(devEnv) {
+		err := r.getChild(ctx, types.NamespacedName{Name: volume.ClaimName, Namespace: environmentNamespace(devEnv)},
+			&corev1.PersistentVolumeClaim{})
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get PVC %s: %w", volume.ClaimName, err)
+		}
+
+		pvs := &corev1.PersistentVolumeList{}
+		if err := r.List(ctx, pvs, client.MatchingLabels{
+			labelRetainedOwner:        retainedOwner(devEnv),
+			labelRetainedVolume:       volume.Name,
+			labelEnvironmentNamespace: devEnv.Namespace,
+		}); err != nil {
+			return fmt.Errorf("failed to list retained PVs: %w", err)
+		}
+		var pv *corev1.PersistentVolume
+		for i := range pvs.Items {
+			// A volume is released once the claim of the deleted environment is gone
+			if pvs.Items[i].Labels[labelRetainedType] == volume.Type && pvs.Items[i].Status.Phase == corev1.VolumeReleased {
+				pv = &pvs.Items[i]
+				break
+			}
+		}
+		if pv == nil {
+			continue
+		}
+
+		pvc, _, err := r.volumeClaim(devEnv, volume)
+		if err != nil {
+			return err
+		}
+
+		// The database in the volume keeps the password it was initialised with
+		password := &corev1.Secret{}
+		passwordKey := types.NamespacedName{Name: retainedPasswordSecretName(pv), Namespace: devEnv.Namespace}
+		err = r.Get(ctx, passwordKey, password)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get retained password of PV %s: %w", pv.Name, err)
+		}
+		if err == nil {
+			if err := r.seedServicePassword(ctx, devEnv, volume.Name, password.Data["password"]); err != nil {
+				return err
+			}
+		}
+
+		// Reserve the volume for the new claim and restore its reclaim policy
+		patch := client.MergeFrom(pv.DeepCopy())
+		pv.Spec.ClaimRef = &corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Name:       pvc.Name,
+			Namespace:  pvc.Namespace,
+		}
+		if policy, ok := pv.Annotations[annotationReclaimPolicy]; ok {
+			pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimPolicy(policy)
+			delete(pv.Annotations, annotationReclaimPolicy)
+		}
+		delete(pv.Labels, labelRetainedOwner)
+		delete(pv.Labels, labelRetainedVolume)
+		delete(pv.Labels, labelRetainedType)
+		delete(pv.Labels, labelEnvironmentNamespace)
+		delete(pv.Annotations, annotationRetainedOwner)
+		if err := r.Patch(ctx, pv, patch); err != nil {
+			return fmt.Errorf("failed to adopt PV %s: %w", pv.Name, err)
+		}
+
+		// The claim binds to the volume as it is, and is grown afterwards if requested
+		pvc.Spec.VolumeName = pv.Name
+		storage := resolvedStorage{
+			Size:             pv.Spec.Capacity[corev1.ResourceStorage],
+			StorageClassName: Ptr(pv.Spec.StorageClassName),
+		}
+		if err := r.applyPVC(ctx, devEnv, pvc, storage); err != nil {
+			return err
+		}
+		if password.Name != "" {
+			if err := r.Delete(ctx, password); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("failed to delete retained password of PV %s: %w", pv.Name, err)
+			}
+		}
+		log.FromContext(ctx).Info("Adopted retained volume", "volume", volume.Name, "pv", pv.Name)
+		if !slices.Contains(devEnv.Status.AdoptedVolumes, volume.Name) {
+			devEnv.Status.AdoptedVolumes = append(devEnv.Status.AdoptedVolumes, volume.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestRetainedVolumeKeepsPassword(t *testing.T) {
+	ctx := context.Background()
+	environment := func(uid string) *apiv1.DeveloperEnvironment {
+		return &apiv1.DeveloperEnvironment{
+			ObjectMeta: metav1.ObjectMeta{Name: "golang-env", Namespace: "team", UID: types.UID(uid)},
+			Spec: apiv1.DeveloperEnvironmentSpec{
+				Owner:          "alice",
+				DeletionPolicy: apiv1.DeletionPolicyRetain,
+				Services: []apiv1.ServiceSpec{
+					{Name: "db", DatabaseSpec: apiv1.DatabaseSpec{Type: "postgres", Version: "16"}},
+				},
+			},
+			Status: apiv1.DeveloperEnvironmentStatus{Namespace: "team"},
+		}
+	}
+	deleted := environment("1")
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: servicePVCName(deleted, "db"), Namespace: "team"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-db"},
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-db"},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+		},
+	}
+	connection := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: serviceConnectionSecretName(deleted, "db"), Namespace: "team"},
+		Data:       map[string][]byte{"password": []byte("initialised")},
+	}
+	r := fakeReconciler(t, pvc, pv, connection)
+	r.Defaults.DatabaseSize = resource.MustParse("1Gi")
+
+	if err := r.retainVolumes(ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	// The claim and connection Secret go with the environment, releasing the volume
+	if err := r.Delete(ctx, pvc); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(ctx, connection); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pv), pv); err != nil {
+		t.Fatal(err)
+	}
+	pv.Status.Phase = corev1.VolumeReleased
+	if err := r.Status().Update(ctx, pv); err != nil {
+		t.Fatal(err)
+	}
+
+	adopting := environment("2")
+	if err := r.adoptRetainedVolumes(ctx, adopting); err != nil {
+		t.Fatal(err)
+	}
+	if len(adopting.Status.AdoptedVolumes) != 1 {
+		t.Fatalf("adoptRetainedVolumes() adopted %v, want the db volume", adopting.Status.AdoptedVolumes)
+	}
+
+	password, err := r.servicePassword(ctx, adopting, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "initialised" {
+		t.Errorf("servicePassword() after adoption = %q, want the password the database was initialised with", password)
+	}
+	retained := &corev1.Secret{}
+	retained.Name, retained.Namespace = retainedPasswordSecretName(pv), "team"
+	if err := r.Get(ctx, client.ObjectKeyFromObject(retained), retained); !apierrors.IsNotFound(err) {
+		t.Errorf("retained password left after adoption: %v", err)
+	}
+}
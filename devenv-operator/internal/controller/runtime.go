@@ -0,0 +1,185 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// defaultIDEImage is the code-server image the install script runs in
+const defaultIDEImage = "linuxserver/code-server:4.95.3"
+
+// installScriptLanguages are the languages the install script can provision
+var installScriptLanguages = map[string]bool{
+	"nodejs": true,
+	"go":     true,
+	"python": true,
+	"java":   true,
+	"rust":   true,
+}
+
+var (
+	errNoRuntimeImage      = errors.New("no LanguageRuntime image")
+	errUnsupportedLanguage = errors.New("unsupported language")
+)
+
+// Runtime condition reasons
+const (
+	reasonRuntimeLookupFailed = "LookupFailed"
+	reasonNoRuntimeImage      = "NoRuntimeImage"
+	reasonUnsupportedLanguage = "UnsupportedLanguage"
+)
+
+// resolveRuntime looks up a prebuilt image for the environment's languages in
+// the LanguageRuntime catalog of its IDE type. The install script provisions
+// every language the image does not provide, on the image of the IDE type if
+// none matches, provided that fallback is enabled and the IDE can run it.
+func (r *DeveloperEnvironmentReconciler) resolveRuntime(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (apiv1.RuntimeStatus, error) {
+	runtimes := &apiv1.LanguageRuntimeList{}
+	if err := r.List(ctx, runtimes); err != nil {
+		return apiv1.RuntimeStatus{}, fmt.Errorf("failed to list language runtimes: %w", err)
+	}
+	ide := devEnv.Spec.IDE.Type
+	if ide == "" {
+		ide = apiv1.IDETypeVSCode
+	}
+	return r.matchRuntime(runtimes.Items, ide, devEnv.Spec.EffectiveLanguages())
+}
+
+func (r *DeveloperEnvironmentReconciler) matchRuntime(
+	runtimes []apiv1.LanguageRuntime,
+	ide apiv1.IDEType,
+	languages []apiv1.LanguageSpec,
+) (apiv1.RuntimeStatus, error) {
+	kind, ok := ideTypes[ide]
+	if !ok {
+		return apiv1.RuntimeStatus{}, fmt.Errorf("unsupported IDE type %q", ide)
+	}
+	status := apiv1.RuntimeStatus{
+		Image:  kind.image,
+		Source: apiv1.RuntimeSourceInstallScript,
+	}
+	if !kind.Scripts {
+		status.Source = apiv1.RuntimeSourceIDEImage
+	}
+
+	// A single image can only provide one toolchain. The first language with
+	// an image wins, and several runtimes may provide it; the first by name wins.
+	sort.Slice(runtimes, func(i, j int) bool { return runtimes[i].Name < runtimes[j].Name })
+	matched := -1
+	for i, language := range languages {
+		for _, runtime := range runtimes {
+			if runtime.Spec.Language != language.Name || runtimeIDE(runtime) != ide {
+				continue
+			}
+			for _, image := range runtime.Spec.Images {
+				if matched < 0 && image.Version == language.Version {
+					status = apiv1.RuntimeStatus{
+						Image:           image.Image,
+						Source:          apiv1.RuntimeSourceCatalog,
+						LanguageRuntime: runtime.Name,
+					}
+					matched = i
+				}
+			}
+		}
+	}
+
+	for i, language := range languages {
+		// Catalog images are built on the image of the IDE type, so they come with its languages too
+		if i == matched || slices.Contains(kind.Languages, language.Name) {
+			continue
+		}
+		if !kind.Scripts {
+			return apiv1.RuntimeStatus{}, fmt.Errorf("%w %q: no LanguageRuntime provides version %q for the %s IDE, which cannot install it at startup",
+				errUnsupportedLanguage, language.Name, language.Version, ide)
+		}
+		if r.DisableInstallScript {
+			return apiv1.RuntimeStatus{}, fmt.Errorf("%w: none provides %s %s and the install script is disabled",
+				errNoRuntimeImage, language.Name, language.Version)
+		}
+		if !installScriptLanguages[language.Name] {
+			return apiv1.RuntimeStatus{}, fmt.Errorf("%w %q: no LanguageRuntime provides version %q and the install script cannot provision it",
+				errUnsupportedLanguage, language.Name, language.Version)
+		}
+		status.ScriptedLanguages = append(status.ScriptedLanguages, language.Name)
+	}
+	return status, nil
+}
+
+// runtimeCondition reports the outcome of resolveRuntime
+func runtimeCondition(runtime apiv1.RuntimeStatus, err error) apiv1.Condition {
+	switch {
+	case errors.Is(err, errUnsupportedLanguage):
+		return conditionFromBool(apiv1.ConditionRuntimeReady, false, reasonUnsupportedLanguage, err.Error())
+	case errors.Is(err, errNoRuntimeImage):
+		return conditionFromBool(apiv1.ConditionRuntimeReady, false, reasonNoRuntimeImage, err.Error())
+	case err != nil:
+		return conditionFromBool(apiv1.ConditionRuntimeReady, false, reasonRuntimeLookupFailed, err.Error())
+	case runtime.Source == apiv1.RuntimeSourceCatalog && len(runtime.ScriptedLanguages) > 0:
+		return conditionFromBool(apiv1.ConditionRuntimeReady, true, string(runtime.Source),
+			fmt.Sprintf("Using image %s from LanguageRuntime %s, installing %s at startup",
+				runtime.Image, runtime.LanguageRuntime, strings.Join(runtime.ScriptedLanguages, ", ")))
+	case runtime.Source == apiv1.RuntimeSourceCatalog:
+		return conditionFromBool(apiv1.ConditionRuntimeReady, true, string(runtime.Source),
+			fmt.Sprintf("Using image %s from LanguageRuntime %s", runtime.Image, runtime.LanguageRuntime))
+	case runtime.Source == apiv1.RuntimeSourceIDEImage:
+		return conditionFromBool(apiv1.ConditionRuntimeReady, true, string(runtime.Source),
+			fmt.Sprintf("Using image %s", runtime.Image))
+	default:
+		return conditionFromBool(apiv1.ConditionRuntimeReady, true, string(runtime.Source),
+			fmt.Sprintf("Installing %s on %s at startup", strings.Join(runtime.ScriptedLanguages, ", "), runtime.Image))
+	}
+}
+
+// environmentsForRuntime maps a LanguageRuntime to the environments of its language
+func (r *DeveloperEnvironmentReconciler) environmentsForRuntime(ctx context.Context, obj client.Object) []reconcile.Request {
+	runtime, ok := obj.(*apiv1.LanguageRuntime)
+	if !ok {
+		return nil
+	}
+	devEnvs := &apiv1.DeveloperEnvironmentList{}
+	if err := r.List(ctx, devEnvs); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list developer environments for language runtime", "runtime", runtime.Name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, devEnv := range devEnvs.Items {
+		for _, language := range devEnv.Spec.EffectiveLanguages() {
+			if language.Name == runtime.Spec.Language {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&devEnv)})
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func languageRuntime(name, language string, images ...apiv1.RuntimeImage) apiv1.LanguageRuntime {
+	return apiv1.LanguageRuntime{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       apiv1.LanguageRuntimeSpec{Language: language, Images: images},
+	}
+}
+
+func TestMatchRuntime(t *testing.T) {
+	runtimes := []apiv1.LanguageRuntime{
+		languageRuntime("go-mirror", "go", apiv1.RuntimeImage{Version: "1.22.0", Image: "mirror/go:1.22.0"}),
+		languageRuntime("go", "go",
+			apiv1.RuntimeImage{Version: "1.21.5", Image: "example/go:1.21.5"},
+			apiv1.RuntimeImage{Version: "1.22.0", Image: "example/go:1.22.0"},
+		),
+		languageRuntime("nodejs", "nodejs", apiv1.RuntimeImage{Version: "20", Image: "example/node:20"}),
+	}
+	jupyterGo := languageRuntime("go-jupyter", "go", apiv1.RuntimeImage{Version: "1.22.0", Image: "example/jupyter-go:1.22.0"})
+	jupyterGo.Spec.IDE = apiv1.IDETypeJupyterLab
+	runtimes = append(runtimes, jupyterGo)
+
+	tests := []struct {
+		name          string
+		ide           apiv1.IDEType
+		languages     []apiv1.LanguageSpec
+		disableScript bool
+		want          apiv1.RuntimeStatus
+		wantErr       bool
+	}{
+		{
+			name:      "catalog match",
+			languages: []apiv1.LanguageSpec{{Name: "go", Version: "1.21.5"}},
+			want:      apiv1.RuntimeStatus{Image: "example/go:1.21.5", Source: apiv1.RuntimeSourceCatalog, LanguageRuntime: "go"},
+		},
+		{
+			name:      "first runtime by name wins",
+			languages: []apiv1.LanguageSpec{{Name: "go", Version: "1.22.0"}},
+			want:      apiv1.RuntimeStatus{Image: "example/go:1.22.0", Source: apiv1.RuntimeSourceCatalog, LanguageRuntime: "go"},
+		},
+		{
+			name:      "falls back to the install script",
+			languages: []apiv1.LanguageSpec{{Name: "python", Version: "3.12"}},
+			want: apiv1.RuntimeStatus{Image: defaultIDEImage, Source: apiv1.RuntimeSourceInstallScript,
+				ScriptedLanguages: []string{"python"}},
+		},
+		{
+			name:      "java is provisioned by the install script",
+			languages: []apiv1.LanguageSpec{{Name: "java", Version: "21"}},
+			want: apiv1.RuntimeStatus{Image: defaultIDEImage, Source: apiv1.RuntimeSourceInstallScript,
+				ScriptedLanguages: []string{"java"}},
+		},
+		{
+			name: "polyglot environment scripts the languages the image lacks",
+			languages: []apiv1.LanguageSpec{
+				{Name: "python", Version: "3.12"},
+				{Name: "nodejs", Version: "20"},
+				{Name: "go", Version: "1.22.0"},
+			},
+			want: apiv1.RuntimeStatus{Image: "example/node:20", Source: apiv1.RuntimeSourceCatalog,
+				LanguageRuntime: "nodejs", ScriptedLanguages: []string{"python", "go"}},
+		},
+		{
+			name:      "language the install script does not know",
+			languages: []apiv1.LanguageSpec{{Name: "", Version: "1"}},
+			wantErr:   true,
+		},
+		{
+			name:          "no match without the install script",
+			languages:     []apiv1.LanguageSpec{{Name: "nodejs", Version: "18"}},
+			disableScript: true,
+			wantErr:       true,
+		},
+		{
+			name:      "catalog of another IDE",
+			ide:       apiv1.IDETypeJupyterLab,
+			languages: []apiv1.LanguageSpec{{Name: "go", Version: "1.22.0"}, {Name: "python", Version: "3.12"}},
+			want: apiv1.RuntimeStatus{Image: "example/jupyter-go:1.22.0", Source: apiv1.RuntimeSourceCatalog,
+				LanguageRuntime: "go-jupyter"},
+		},
+		{
+			name:      "language that comes with the IDE image",
+			ide:       apiv1.IDETypeJupyterLab,
+			languages: []apiv1.LanguageSpec{{Name: "python", Version: "3.12"}},
+			want:      apiv1.RuntimeStatus{Image: ideTypes[apiv1.IDETypeJupyterLab].image, Source: apiv1.RuntimeSourceIDEImage},
+		},
+		{
+			name:      "IDE that cannot run the install script",
+			ide:       apiv1.IDETypeJupyterLab,
+			languages: []apiv1.LanguageSpec{{Name: "go", Version: "1.21.5"}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ide := tt.ide
+			if ide == "" {
+				ide = apiv1.IDETypeVSCode
+			}
+			r := &DeveloperEnvironmentReconciler{DisableInstallScript: tt.disableScript}
+			got, err := r.matchRuntime(runtimes, ide, tt.languages)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchRuntime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchRuntime() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// annotationSeedChecksum records the seed source a seed Job loads
+const annotationSeedChecksum = "devenv.adityajoshi.online/seed-checksum"
+
+// mcImage downloads seed dumps from S3-compatible buckets
+const mcImage = "quay.io/minio/mc:latest"
+
+// seedPath is where the seed Job finds the seed files
+const seedPath = "/seed"
+
+// seedScript runs every seed file in lexical order, stopping at the first failure
+const seedScript = `set -eu
+cd ` + seedPath + `
+for f in $(ls | sort); do
+    echo "Seeding from $f"
+    case "$f" in
+    %s
+    *.sh) sh "$f" ;;
+    *) echo "Skipping $f" ;;
+    esac
+done
+`
+
+// seedName names the seed Job of a service, and the copy of its seed source next to it
+func seedName(devEnv *apiv1.DeveloperEnvironment, service string) string {
+	return fmt.Sprintf("%s-%s-seed", devEnv.Name, service)
+}
+
+// setupSeed seeds a backing service from its seed source with a one-shot Job
+// once the service is ready, and again whenever the source changes. The
+// progress is recorded in the service status.
+func (r *DeveloperEnvironmentReconciler) setupSeed(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	service apiv1.ServiceSpec,
+	connection databaseConnection,
+	status *apiv1.ServiceStatus,
+) error {
+	if service.Seed == nil {
+		status.Seed = nil
+		return r.removeSeed(ctx, devEnv, service.Name)
+	}
+	kind := serviceTypes[service.Type]
+	if !kind.Seedable {
+		status.Seed = &apiv1.SeedStatus{Phase: apiv1.SeedPhaseFailed,
+			Message: fmt.Sprintf("%s cannot be seeded", service.Type)}
+		return nil
+	}
+
+	// The seed source is copied next to the service
+	meta := metav1.ObjectMeta{
+		Name:      seedName(devEnv, service.Name),
+		Namespace: environmentNamespace(devEnv),
+		Labels:    serviceLabels(devEnv, service.Name),
+	}
+	sum, err := r.copySource(ctx, devEnv, meta, service.Seed.ConfigMap, service.Seed.S3)
+	if err != nil {
+		return fmt.Errorf("failed to copy seed source of service %s: %w", service.Name, err)
+	}
+	if status.Seed != nil && status.Seed.Checksum == sum && status.Seed.Phase == apiv1.SeedPhaseSucceeded {
+		return nil
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Name: seedName(devEnv, service.Name), Namespace: environmentNamespace(devEnv)}, job)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get seed job of service %s: %w", service.Name, err)
+	}
+	exists := err == nil
+
+	// A Job of an earlier source makes way for one of the current source
+	if exists && job.Annotations[annotationSeedChecksum] != sum {
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete seed job of service %s: %w", service.Name, err)
+		}
+		exists = false
+	}
+	if exists {
+		status.Seed = seedJobStatus(job, sum)
+		return nil
+	}
+
+	ready, err := r.serviceCondition(ctx, devEnv, service)
+	if err != nil {
+		return err
+	}
+	if ready.Status != string(metav1.ConditionTrue) {
+		status.Seed = &apiv1.SeedStatus{Checksum: sum, Phase: apiv1.SeedPhasePending,
+			Message: fmt.Sprintf("Waiting for %s to become ready", service.Name)}
+		return nil
+	}
+
+	if err := r.apply(ctx, devEnv, seedJob(devEnv, service, connection, sum)); err != nil {
+		return fmt.Errorf("failed to apply seed job of service %s: %w", service.Name, err)
+	}
+	status.Seed = &apiv1.SeedStatus{Checksum: sum, Phase: apiv1.SeedPhaseRunning}
+	return nil
+}
+
+// copySource copies a ConfigMap next to the DeveloperEnvironment, or the
+// credentials of an S3 location, to meta, which may live in another namespace
+// than the environment. The copy of the other kind is deleted. It returns the
+// checksum of the source.
+func (r *DeveloperEnvironmentReconciler) copySource(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	meta metav1.ObjectMeta,
+	configMap string,
+	s3 *apiv1.S3Location,
+) (string, error) {
+	if s3 != nil {
+		source := &corev1.Secret{}
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Name: s3.CredentialsSecret, Namespace: devEnv.Namespace}, source); err != nil {
+			return "", fmt.Errorf("failed to get bucket credentials %s: %w", s3.CredentialsSecret, err)
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: meta,
+			Data: map[string][]byte{
+				"accesskey": source.Data["accesskey"],
+				"secretkey": source.Data["secretkey"],
+			},
+		}
+		if err := r.Delete(ctx, &corev1.ConfigMap{ObjectMeta: meta}); err != nil && !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to delete ConfigMap %s: %w", meta.Name, err)
+		}
+		if err := r.apply(ctx, devEnv, secret); err != nil {
+			return "", fmt.Errorf("failed to apply bucket credentials %s: %w", meta.Name, err)
+		}
+		return checksum(map[string]string{"endpoint": s3.Endpoint, "bucket": s3.Bucket, "key": s3.Key}), nil
+	}
+
+	source := &corev1.ConfigMap{}
+	if err := r.APIReader.Get(ctx, types.NamespacedName{Name: configMap, Namespace: devEnv.Namespace}, source); err != nil {
+		return "", fmt.Errorf("failed to get ConfigMap %s: %w", configMap, err)
+	}
+	files := &corev1.ConfigMap{
+		ObjectMeta: meta,
+		Data:       source.Data,
+		BinaryData: source.BinaryData,
+	}
+	if err := r.Delete(ctx, &corev1.Secret{ObjectMeta: meta}); err != nil && !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to delete bucket credentials %s: %w", meta.Name, err)
+	}
+	if err := r.apply(ctx, devEnv, files); err != nil {
+		return "", fmt.Errorf("failed to apply ConfigMap %s: %w", meta.Name, err)
+	}
+
+	data := map[string]string{}
+	for key, value := range source.Data {
+		data[key] = value
+	}
+	for key, value := range source.BinaryData {
+		data[key] = string(value)
+	}
+	return checksum(data), nil
+}
+
+// removeSeed deletes the seed Job of a service that no longer has a seed, and the copy of its source
+func (r *DeveloperEnvironmentReconciler) removeSeed(ctx context.Context, devEnv *apiv1.DeveloperEnvironment, service string) error {
+	meta := metav1.ObjectMeta{
+		Name:      seedName(devEnv, service),
+		Namespace: environmentNamespace(devEnv),
+	}
+	for _, obj := range []client.Object{
+		&batchv1.Job{ObjectMeta: meta},
+		&corev1.ConfigMap{ObjectMeta: meta},
+		&corev1.Secret{ObjectMeta: meta},
+	} {
+		if err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete seed %s of service %s: %w", obj.GetName(), service, err)
+		}
+	}
+	return nil
+}
+
+// seedJob returns the Job loading the seed files of a service
+func seedJob(
+	devEnv *apiv1.DeveloperEnvironment,
+	service apiv1.ServiceSpec,
+	connection databaseConnection,
+	sum string,
+) *batchv1.Job {
+	kind := serviceTypes[service.Type]
+	name := seedName(devEnv, service.Name)
+	secret := serviceConnectionSecretName(devEnv, service.Name)
+
+	env := []corev1.EnvVar{secretEnv("DATABASE_URL", secret, "dsn")}
+	if kind.seedEnv != nil {
+		env = append(env, kind.seedEnv(connection, secret)...)
+	}
+
+	volume := corev1.Volume{Name: "seed"}
+	var initContainers []corev1.Container
+	if s3 := service.Seed.S3; s3 != nil {
+		volume.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		initContainers = append(initContainers, downloadContainer(s3, name))
+	} else {
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		}
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: environmentNamespace(devEnv),
+			Labels:    serviceLabels(devEnv, service.Name),
+			Annotations: map[string]string{
+				annotationSeedChecksum: sum,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: Ptr(int32(2)),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					// Not app=database, which would put the Job behind the Service of the database
+					Labels: map[string]string{
+						"app":           "database-seed",
+						"developer-env": devEnv.Name,
+						labelService:    service.Name,
+					},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:  corev1.RestartPolicyNever,
+					InitContainers: initContainers,
+					Containers: []corev1.Container{
+						{
+							Name:    "seed",
+							Image:   serviceImage(service),
+							Command: []string{"/bin/sh", "-c", fmt.Sprintf(seedScript, kind.seed)},
+							Env:     env,
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "seed",
+									MountPath: seedPath,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{volume},
+				},
+			},
+		},
+	}
+}
+
+// downloadContainer fetches a seed dump with the MinIO client
+func downloadContainer(s3 *apiv1.S3Location, secret string) corev1.Container {
+	return corev1.Container{
+		Name:    "download",
+		Image:   mcImage,
+		Command: []string{"mc"},
+		Args:    []string{"cp", s3Path("source", s3.Bucket, s3.Key), seedPath + "/" + path.Base(s3.Key)},
+		Env:     mcHostEnv("source", s3.Endpoint, secret),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "seed",
+				MountPath: seedPath,
+			},
+		},
+	}
+}
+
+// mcHostEnv points an alias of the MinIO client at an S3 endpoint, with the
+// accesskey and secretkey of a Secret
+func mcHostEnv(alias, endpoint, secret string) []corev1.EnvVar {
+	u, _ := url.Parse(endpoint)
+	return []corev1.EnvVar{
+		secretEnv("S3_ACCESS_KEY", secret, "accesskey"),
+		secretEnv("S3_SECRET_KEY", secret, "secretkey"),
+		{
+			Name:  "MC_HOST_" + alias,
+			Value: fmt.Sprintf("%s://$(S3_ACCESS_KEY):$(S3_SECRET_KEY)@%s", u.Scheme, u.Host),
+		},
+	}
+}
+
+// s3Path is the path of an object for the MinIO client
+func s3Path(alias, bucket, key string) string {
+	return fmt.Sprintf("%s/%s/%s", alias, bucket, strings.TrimPrefix(key, "/"))
+}
+
+// seedJobStatus reads the progress of a seed Job
+func seedJobStatus(job *batchv1.Job, sum string) *apiv1.SeedStatus {
+	if job.Status.Succeeded > 0 {
+		return &apiv1.SeedStatus{Checksum: sum, Phase: apiv1.SeedPhaseSucceeded}
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return &apiv1.SeedStatus{Checksum: sum, Phase: apiv1.SeedPhaseFailed, Message: c.Message}
+		}
+	}
+	return &apiv1.SeedStatus{Checksum: sum, Phase: apiv1.SeedPhaseRunning}
+}
+
+// seedCondition summarises the seeding of every service with a seed. It
+// returns false when no service has a seed.
+func seedCondition(statuses []apiv1.ServiceStatus) (apiv1.Condition, bool) {
+	var pending, failed []string
+	seeds := 0
+	for _, status := range statuses {
+		switch {
+		case status.Seed == nil:
+			continue
+		case status.Seed.Phase == apiv1.SeedPhaseFailed:
+			failed = append(failed, status.Name)
+		case status.Seed.Phase != apiv1.SeedPhaseSucceeded:
+			pending = append(pending, status.Name)
+		}
+		seeds++
+	}
+
+	switch {
+	case seeds == 0:
+		return apiv1.Condition{}, false
+	case len(failed) > 0:
+		return conditionFromBool(apiv1.ConditionDatabasesSeeded, false, "SeedFailed",
+			fmt.Sprintf("Failed to seed %s", strings.Join(failed, ", "))), true
+	case len(pending) > 0:
+		return conditionFromBool(apiv1.ConditionDatabasesSeeded, false, "Seeding",
+			fmt.Sprintf("Waiting for %s", strings.Join(pending, ", "))), true
+	default:
+		return conditionFromBool(apiv1.ConditionDatabasesSeeded, true, "Seeded",
+			fmt.Sprintf("%d database(s) seeded", seeds)), true
+	}
+}
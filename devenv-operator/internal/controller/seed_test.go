@@ -0,0 +1,77 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestSeedCondition(t *testing.T) {
+	seeded := &apiv1.SeedStatus{Checksum: "a", Phase: apiv1.SeedPhaseSucceeded}
+	running := &apiv1.SeedStatus{Checksum: "b", Phase: apiv1.SeedPhaseRunning}
+	failed := &apiv1.SeedStatus{Checksum: "c", Phase: apiv1.SeedPhaseFailed, Message: "BackoffLimitExceeded"}
+
+	tests := []struct {
+		name       string
+		statuses   []apiv1.ServiceStatus
+		wantOK     bool
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{
+			name:     "no seeds",
+			statuses: []apiv1.ServiceStatus{{Name: "cache"}},
+		},
+		{
+			name:       "seeded",
+			statuses:   []apiv1.ServiceStatus{{Name: "database", Seed: seeded}, {Name: "cache"}},
+			wantOK:     true,
+			wantStatus: metav1.ConditionTrue,
+			wantReason: "Seeded",
+		},
+		{
+			name:       "seeding",
+			statuses:   []apiv1.ServiceStatus{{Name: "database", Seed: seeded}, {Name: "documents", Seed: running}},
+			wantOK:     true,
+			wantStatus: metav1.ConditionFalse,
+			wantReason: "Seeding",
+		},
+		{
+			name:       "failure wins",
+			statuses:   []apiv1.ServiceStatus{{Name: "database", Seed: failed}, {Name: "documents", Seed: running}},
+			wantOK:     true,
+			wantStatus: metav1.ConditionFalse,
+			wantReason: "SeedFailed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := seedCondition(tt.statuses)
+			if ok != tt.wantOK {
+				t.Fatalf("seedCondition() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (got.Status != string(tt.wantStatus) || got.Reason != tt.wantReason) {
+				t.Errorf("seedCondition() = %s/%s, want %s/%s", got.Status, got.Reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,917 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"maps"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+	"github.com/adityajoshi12/devenv-operator/internal/catalog"
+)
+
+// labelService names the backing service an object belongs to
+const labelService = "devenv.adityajoshi.online/service"
+
+// serviceType describes how to run a type of backing service with the
+// capabilities of its catalog entry
+type serviceType struct {
+	catalog.Service
+	// image is tagged with the version of the service
+	image string
+	port  int32
+	// dataPath is where the volume is mounted. Services that refuse to start
+	// on a volume holding lost+found keep their data in subPath of it.
+	dataPath string
+	subPath  string
+	// statefulSet runs the service as a StatefulSet, for services that need a stable network identity
+	statefulSet bool
+	// fsGroup owns the volume, for images that do not start as root
+	fsGroup *int64
+	// credentials the service is set up with, besides its password
+	credentials databaseConnection
+	// password protects the service with a password generated for the environment
+	password bool
+	// args are passed to the service in place of the default arguments of the image
+	args []string
+	// env configures the service, reading credentials from the connection Secret
+	env func(connection databaseConnection, secret string) []corev1.EnvVar
+	// dsn renders a connection URL understood by the usual client libraries
+	dsn func(connection databaseConnection) string
+	// probe checks that the service answers in its own protocol, with the credentials of its env
+	probe corev1.ProbeHandler
+	// seed holds the case branches of the seed script loading each kind of
+	// seed file, for the Seedable types
+	seed string
+	// seedEnv configures the client tools the seed and dump commands run, besides DATABASE_URL
+	seedEnv func(connection databaseConnection, secret string) []corev1.EnvVar
+	// dump writes a logical backup to $BACKUP_FILE, which the seed script
+	// can load, for the types that have a dump tool. Other types are backed
+	// up as an archive of their volume.
+	dump    string
+	dumpExt string
+}
+
+var serviceTypes = map[string]serviceType{
+	"postgres": {
+		Service:     catalog.ServiceTypes["postgres"],
+		image:       "postgres",
+		port:        5432,
+		dataPath:    "/var/lib/postgresql/data",
+		credentials: databaseConnection{User: "postgres", Database: "postgres"},
+		password:    true,
+		env: func(connection databaseConnection, secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				{Name: "POSTGRES_DB", Value: connection.Database},
+				{Name: "POSTGRES_USER", Value: connection.User},
+				secretEnv("POSTGRES_PASSWORD", secret, "password"),
+				{Name: "PGDATA", Value: "/var/lib/postgresql/data/pgdata"},
+			}
+		},
+		probe: execProbe(`pg_isready --host 127.0.0.1 --username "$POSTGRES_USER" --dbname "$POSTGRES_DB"`),
+		dsn: func(connection databaseConnection) string {
+			return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
+				url.QueryEscape(connection.User), url.QueryEscape(connection.Password),
+				connection.Host, connection.Port, connection.Database)
+		},
+		seed: `*.sql) psql -v ON_ERROR_STOP=1 -f "$f" ;;
+    *.sql.gz) gunzip -c "$f" | psql -v ON_ERROR_STOP=1 ;;
+    *.dump) pg_restore --no-owner --dbname "$PGDATABASE" "$f" ;;`,
+		dump:    `pg_dump --format=custom --no-owner --file "$BACKUP_FILE"`,
+		dumpExt: ".dump",
+		seedEnv: func(connection databaseConnection, secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				{Name: "PGHOST", Value: connection.Host},
+				{Name: "PGPORT", Value: strconv.Itoa(int(connection.Port))},
+				{Name: "PGUSER", Value: connection.User},
+				{Name: "PGDATABASE", Value: connection.Database},
+				secretEnv("PGPASSWORD", secret, "password"),
+			}
+		},
+	},
+	"redis": {
+		Service:  catalog.ServiceTypes["redis"],
+		image:    "redis",
+		port:     6379,
+		dataPath: "/data",
+		password: true,
+		args:     []string{"redis-server", "--requirepass", "$(REDIS_PASSWORD)"},
+		env: func(connection databaseConnection, secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				secretEnv("REDIS_PASSWORD", secret, "password"),
+			}
+		},
+		probe: execProbe(`redis-cli -a "$REDIS_PASSWORD" --no-auth-warning ping | grep -q PONG`),
+		dsn: func(connection databaseConnection) string {
+			if connection.Password != "" {
+				return fmt.Sprintf("redis://:%s@%s:%d/0", url.QueryEscape(connection.Password), connection.Host, connection.Port)
+			}
+			return fmt.Sprintf("redis://%s:%d/0", connection.Host, connection.Port)
+		},
+	},
+	"mysql": {
+		Service:     catalog.ServiceTypes["mysql"],
+		image:       "mysql",
+		port:        3306,
+		dataPath:    "/var/lib/mysql",
+		subPath:     "mysql",
+		credentials: databaseConnection{User: "devenv", Database: "devenv"},
+		password:    true,
+		env: func(connection databaseConnection, secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				{Name: "MYSQL_DATABASE", Value: connection.Database},
+				{Name: "MYSQL_USER", Value: connection.User},
+				secretEnv("MYSQL_PASSWORD", secret, "password"),
+				secretEnv("MYSQL_ROOT_PASSWORD", secret, "password"),
+			}
+		},
+		// The server of the first start only listens on its socket while it initialises
+		probe:   execProbe(`MYSQL_PWD="$MYSQL_ROOT_PASSWORD" mysqladmin ping --host 127.0.0.1 --user root`),
+		dsn:     mysqlDSN,
+		seed:    mysqlSeed("mysql"),
+		seedEnv: mysqlSeedEnv,
+		dump:    mysqlDump("mysqldump"),
+		dumpExt: ".sql.gz",
+	},
+	"mariadb": {
+		Service:     catalog.ServiceTypes["mariadb"],
+		image:       "mariadb",
+		port:        3306,
+		dataPath:    "/var/lib/mysql",
+		subPath:     "mysql",
+		credentials: databaseConnection{User: "devenv", Database: "devenv"},
+		password:    true,
+		env: func(connection databaseConnection, secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				{Name: "MARIADB_DATABASE", Value: connection.Database},
+				{Name: "MARIADB_USER", Value: connection.User},
+				secretEnv("MARIADB_PASSWORD", secret, "password"),
+				secretEnv("MARIADB_ROOT_PASSWORD", secret, "password"),
+			}
+		},
+		probe:   execProbe(`MYSQL_PWD="$MARIADB_ROOT_PASSWORD" mariadb-admin ping --host 127.0.0.1 --user root`),
+		dsn:     mysqlDSN,
+		seed:    mysqlSeed("mariadb"),
+		seedEnv: mysqlSeedEnv,
+		dump:    mysqlDump("mariadb-dump"),
+		dumpExt: ".sql.gz",
+	},
+	"mongodb": {
+		Service:     catalog.ServiceTypes["mongodb"],
+		image:       "mongo",
+		port:        27017,
+		dataPath:    "/data/db",
+		credentials: databaseConnection{User: "devenv"},
+		password:    true,
+		env: func(connection databaseConnection, secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				{Name: "MONGO_INITDB_ROOT_USERNAME", Value: connection.User},
+				secretEnv("MONGO_INITDB_ROOT_PASSWORD", secret, "password"),
+			}
+		},
+		// Images before MongoDB 6 only come with the legacy mongo shell
+		probe: execProbe(`$(command -v mongosh || echo mongo) --quiet --eval "db.adminCommand('ping').ok" | grep -q 1`),
+		dsn: func(connection databaseConnection) string {
+			return fmt.Sprintf("mongodb://%s:%s@%s:%d/",
+				url.QueryEscape(connection.User), url.QueryEscape(connection.Password), connection.Host, connection.Port)
+		},
+		seed: `*.js) mongosh --quiet "$DATABASE_URL" "$f" ;;
+    *.archive) mongorestore --uri "$DATABASE_URL" --archive="$f" ;;
+    *.archive.gz) mongorestore --uri "$DATABASE_URL" --gzip --archive="$f" ;;`,
+		dump:    `mongodump --uri "$DATABASE_URL" --gzip --archive="$BACKUP_FILE"`,
+		dumpExt: ".archive.gz",
+	},
+	"rabbitmq": {
+		Service:     catalog.ServiceTypes["rabbitmq"],
+		image:       "rabbitmq",
+		port:        5672,
+		dataPath:    "/var/lib/rabbitmq",
+		fsGroup:     Ptr(int64(999)),
+		credentials: databaseConnection{User: "devenv"},
+		password:    true,
+		env: func(connection databaseConnection, secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				{Name: "RABBITMQ_DEFAULT_USER", Value: connection.User},
+				secretEnv("RABBITMQ_DEFAULT_PASS", secret, "password"),
+			}
+		},
+		probe: execProbe("rabbitmq-diagnostics -q ping"),
+		dsn: func(connection databaseConnection) string {
+			return fmt.Sprintf("amqp://%s:%s@%s:%d/",
+				url.QueryEscape(connection.User), url.QueryEscape(connection.Password), connection.Host, connection.Port)
+		},
+	},
+	// A single node acting as both KRaft controller and broker, advertised
+	// under its Service name so that clients in the IDE can reach it
+	"kafka": {
+		Service:     catalog.ServiceTypes["kafka"],
+		image:       "apache/kafka",
+		port:        9092,
+		dataPath:    "/var/lib/kafka/data",
+		statefulSet: true,
+		fsGroup:     Ptr(int64(1000)),
+		env: func(connection databaseConnection, secret string) []corev1.EnvVar {
+			return []corev1.EnvVar{
+				{Name: "KAFKA_NODE_ID", Value: "1"},
+				{Name: "KAFKA_PROCESS_ROLES", Value: "broker,controller"},
+				{Name: "KAFKA_LISTENERS", Value: "PLAINTEXT://:9092,CONTROLLER://:9093"},
+				{Name: "KAFKA_ADVERTISED_LISTENERS", Value: fmt.Sprintf("PLAINTEXT://%s:%d", connection.Host, connection.Port)},
+				{Name: "KAFKA_CONTROLLER_LISTENER_NAMES", Value: "CONTROLLER"},
+				{Name: "KAFKA_LISTENER_SECURITY_PROTOCOL_MAP", Value: "CONTROLLER:PLAINTEXT,PLAINTEXT:PLAINTEXT"},
+				{Name: "KAFKA_CONTROLLER_QUORUM_VOTERS", Value: "1@localhost:9093"},
+				{Name: "KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR", Value: "1"},
+				{Name: "KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR", Value: "1"},
+				{Name: "KAFKA_TRANSACTION_STATE_LOG_MIN_ISR", Value: "1"},
+				// Kafka takes every directory in its log dir for a partition, lost+found included
+				{Name: "KAFKA_LOG_DIRS", Value: "/var/lib/kafka/data/logs"},
+				{Name: "KAFKA_HEAP_OPTS", Value: "-Xms512m -Xmx512m"},
+			}
+		},
+		probe: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(9092)},
+		},
+		dsn: func(connection databaseConnection) string {
+			return fmt.Sprintf("%s:%d", connection.Host, connection.Port)
+		},
+	},
+}
+
+// execProbe runs a check in the shell of the service container
+func execProbe(command string) corev1.ProbeHandler {
+	return corev1.ProbeHandler{
+		Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", command}},
+	}
+}
+
+// serviceProbes returns the startup, readiness and liveness probes of a
+// backing service. The startup probe gives a service five minutes to
+// initialise its volume on the first start before liveness checks begin.
+func serviceProbes(kind serviceType) (*corev1.Probe, *corev1.Probe, *corev1.Probe) {
+	startup := &corev1.Probe{
+		ProbeHandler:     kind.probe,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 30,
+	}
+	readiness := &corev1.Probe{
+		ProbeHandler:     kind.probe,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+	liveness := &corev1.Probe{
+		ProbeHandler:     kind.probe,
+		PeriodSeconds:    20,
+		TimeoutSeconds:   5,
+		FailureThreshold: 6,
+	}
+	return startup, readiness, liveness
+}
+
+// mysqlSeed loads seed files with the client of the MySQL flavour, as root
+// so that scripts may create databases and users
+func mysqlSeed(client string) string {
+	return fmt.Sprintf(`*.sql) %[1]s --user=root "$MYSQL_DATABASE" < "$f" ;;
+    *.sql.gz) gunzip -c "$f" | %[1]s --user=root "$MYSQL_DATABASE" ;;`, client)
+}
+
+// mysqlDump dumps the database with the dump tool of the MySQL flavour. The
+// dump is compressed afterwards, since a pipe would hide a failing dump.
+func mysqlDump(tool string) string {
+	return fmt.Sprintf(`%s --user=root --single-transaction --routines "$MYSQL_DATABASE" > "${BACKUP_FILE%%.gz}" && gzip "${BACKUP_FILE%%.gz}"`, tool)
+}
+
+func mysqlSeedEnv(connection databaseConnection, secret string) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{Name: "MYSQL_HOST", Value: connection.Host},
+		{Name: "MYSQL_TCP_PORT", Value: strconv.Itoa(int(connection.Port))},
+		{Name: "MYSQL_DATABASE", Value: connection.Database},
+		secretEnv("MYSQL_PWD", secret, "password"),
+	}
+}
+
+// serviceImage is the image of a backing service at its version
+func serviceImage(service apiv1.ServiceSpec) string {
+	version := service.Version
+	if version == "" {
+		version = "latest"
+	}
+	return fmt.Sprintf("%s:%s", serviceTypes[service.Type].image, version)
+}
+
+// secretEnv reads an environment variable from a key of a Secret
+func secretEnv(name, secret, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+func mysqlDSN(connection databaseConnection) string {
+	return fmt.Sprintf("mysql://%s:%s@%s:%d/%s",
+		url.QueryEscape(connection.User), url.QueryEscape(connection.Password),
+		connection.Host, connection.Port, connection.Database)
+}
+
+// serviceObjectName is the name of the workload and Service of a backing service
+func serviceObjectName(devEnv *apiv1.DeveloperEnvironment, service string) string {
+	return fmt.Sprintf("%s-%s", devEnv.Name, service)
+}
+
+func servicePVCName(devEnv *apiv1.DeveloperEnvironment, service string) string {
+	// The volume of spec.database keeps the name it had before services were introduced
+	if service == apiv1.DefaultServiceName {
+		return databasePVCName(devEnv)
+	}
+	return fmt.Sprintf("%s-%s-pvc", devEnv.Name, service)
+}
+
+func serviceConnectionSecretName(devEnv *apiv1.DeveloperEnvironment, service string) string {
+	return fmt.Sprintf("%s-%s-connection", devEnv.Name, service)
+}
+
+// serviceLabels select the objects of a backing service. Every service is
+// labeled app=database, which the network policies let the IDE reach.
+func serviceLabels(devEnv *apiv1.DeveloperEnvironment, service string) map[string]string {
+	return map[string]string{
+		"app":           "database",
+		"developer-env": devEnv.Name,
+		labelService:    service,
+	}
+}
+
+// servicePVC returns the claim of the volume of a backing service, to be sized by applyPVC
+func servicePVC(devEnv *apiv1.DeveloperEnvironment, service string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      servicePVCName(devEnv, service),
+			Namespace: environmentNamespace(devEnv),
+			Labels:    serviceLabels(devEnv, service),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+		},
+	}
+}
+
+// setupServices provisions every backing service of the environment and
+// removes the ones it no longer has.
+func (r *DeveloperEnvironmentReconciler) setupServices(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	services := devEnv.Spec.EffectiveServices()
+
+	statuses := make([]apiv1.ServiceStatus, 0, len(services))
+	devEnv.Status.Database = nil
+	for _, service := range services {
+		connection, err := r.setupService(ctx, devEnv, service)
+		if err != nil {
+			return err
+		}
+		// Readiness is filled in when the status is updated
+		status := apiv1.ServiceStatus{Name: service.Name}
+		for _, previous := range devEnv.Status.Services {
+			if previous.Name == service.Name {
+				status = previous
+			}
+		}
+		status.Type = service.Type
+		status.DatabaseStatus = apiv1.DatabaseStatus{
+			Host:             connection.Host,
+			Port:             connection.Port,
+			User:             connection.User,
+			Name:             connection.Database,
+			ConnectionSecret: serviceConnectionSecretName(devEnv, service.Name),
+		}
+		seeded, err := r.restoreSeed(ctx, devEnv, service)
+		if err != nil {
+			return err
+		}
+		if err := r.setupSeed(ctx, devEnv, seeded, connection, &status); err != nil {
+			return err
+		}
+		if service.Name == apiv1.DefaultServiceName {
+			devEnv.Status.Database = status.DatabaseStatus.DeepCopy()
+		}
+		statuses = append(statuses, status)
+	}
+	devEnv.Status.Services = statuses
+
+	return r.removeStaleServices(ctx, devEnv, services)
+}
+
+func (r *DeveloperEnvironmentReconciler) setupService(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	service apiv1.ServiceSpec,
+) (databaseConnection, error) {
+	kind, ok := serviceTypes[service.Type]
+	if !ok {
+		return databaseConnection{}, fmt.Errorf("service %s has unsupported type %q", service.Name, service.Type)
+	}
+	name := serviceObjectName(devEnv, service.Name)
+	labels := serviceLabels(devEnv, service.Name)
+
+	resources, err := r.serviceResources(service)
+	if err != nil {
+		return databaseConnection{}, err
+	}
+	storage, err := r.serviceStorage(service)
+	if err != nil {
+		return databaseConnection{}, err
+	}
+
+	// Looks for the database of an earlier version before its volume is created
+	if err := r.seedLegacyPassword(ctx, devEnv, service); err != nil {
+		return databaseConnection{}, err
+	}
+
+	pvc := servicePVC(devEnv, service.Name)
+	if err := r.applyPVC(ctx, devEnv, pvc, storage); err != nil {
+		return databaseConnection{}, fmt.Errorf("failed to apply PVC of service %s: %w", service.Name, err)
+	}
+
+	connection := kind.credentials
+	connection.Host = fmt.Sprintf("%s.%s.svc.cluster.local", name, environmentNamespace(devEnv))
+	connection.Port = kind.port
+	if kind.password {
+		password, err := r.servicePassword(ctx, devEnv, service.Name)
+		if err != nil {
+			return databaseConnection{}, err
+		}
+		connection.Password = password
+	}
+	connection.DSN = kind.dsn(connection)
+
+	// The service reads its password from the connection Secret, so it goes first
+	if err := r.ensureDatabaseConnectionSecret(ctx, devEnv, service.Name, connection); err != nil {
+		return databaseConnection{}, err
+	}
+
+	startup, readiness, liveness := serviceProbes(kind)
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: labels,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  service.Type,
+					Image: serviceImage(service),
+					Ports: []corev1.ContainerPort{
+						{
+							Name:          "db",
+							ContainerPort: kind.port,
+						},
+					},
+					Args:           kind.args,
+					Env:            kind.env(connection, serviceConnectionSecretName(devEnv, service.Name)),
+					StartupProbe:   startup,
+					ReadinessProbe: readiness,
+					LivenessProbe:  liveness,
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:      "db-data",
+							MountPath: kind.dataPath,
+							SubPath:   kind.subPath,
+						},
+					},
+					Resources: resources,
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "db-data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+							ClaimName: pvc.Name,
+						},
+					},
+				},
+			},
+		},
+	}
+	if kind.fsGroup != nil {
+		template.Spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: kind.fsGroup}
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: environmentNamespace(devEnv),
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "db",
+					Port:       kind.port,
+					TargetPort: intstr.FromInt32(kind.port),
+				},
+			},
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	if kind.statefulSet {
+		// The governing Service of a StatefulSet is headless, like in the kafka-kraft-mode manifests
+		svc.Spec.ClusterIP = corev1.ClusterIPNone
+		statefulSet := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: environmentNamespace(devEnv),
+				Labels:    labels,
+			},
+			Spec: appsv1.StatefulSetSpec{
+				ServiceName: name,
+				Replicas:    desiredReplicas(devEnv),
+				Selector:    &metav1.LabelSelector{MatchLabels: labels},
+				Template:    template,
+			},
+		}
+		if err := r.apply(ctx, devEnv, statefulSet); err != nil {
+			return databaseConnection{}, fmt.Errorf("failed to apply StatefulSet of service %s: %w", service.Name, err)
+		}
+	} else {
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: environmentNamespace(devEnv),
+				Labels:    labels,
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: desiredReplicas(devEnv),
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: template,
+			},
+		}
+		if err := r.applyDeployment(ctx, devEnv, deployment); err != nil {
+			return databaseConnection{}, fmt.Errorf("failed to apply Deployment of service %s: %w", service.Name, err)
+		}
+	}
+
+	if err := r.apply(ctx, devEnv, svc); err != nil {
+		return databaseConnection{}, fmt.Errorf("failed to apply Service of service %s: %w", service.Name, err)
+	}
+	return connection, nil
+}
+
+// applyDeployment applies a Deployment, replacing an existing one whose
+// selector differs, since selectors are immutable. Before services were
+// introduced the database was selected by its environment alone.
+func (r *DeveloperEnvironmentReconciler) applyDeployment(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	deployment *appsv1.Deployment,
+) error {
+	existing := &appsv1.Deployment{}
+	err := r.getChild(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && !equality.Semantic.DeepEqual(existing.Spec.Selector, deployment.Spec.Selector) {
+		if err := r.Delete(ctx, existing); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return r.apply(ctx, devEnv, deployment)
+}
+
+// removeStaleServices deletes the objects of backing services the environment no longer has, including their data
+func (r *DeveloperEnvironmentReconciler) removeStaleServices(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	services []apiv1.ServiceSpec,
+) error {
+	wanted := map[string]bool{}
+	for _, service := range services {
+		wanted[service.Name] = true
+	}
+
+	lists := []client.ObjectList{
+		&appsv1.DeploymentList{},
+		&appsv1.StatefulSetList{},
+		&batchv1.JobList{},
+		&corev1.ServiceList{},
+		&corev1.SecretList{},
+		&corev1.ConfigMapList{},
+		&corev1.PersistentVolumeClaimList{},
+	}
+	for _, list := range lists {
+		if err := r.List(ctx, list, client.InNamespace(environmentNamespace(devEnv)), client.MatchingLabels{
+			"app":                     "database",
+			labelEnvironment:          devEnv.Name,
+			labelEnvironmentNamespace: devEnv.Namespace,
+		}); err != nil {
+			return fmt.Errorf("failed to list service objects: %w", err)
+		}
+		err := forEachObject(list, func(obj client.Object) error {
+			// Objects of the database that predates services are not labeled with its name
+			service := obj.GetLabels()[labelService]
+			if service == "" {
+				service = apiv1.DefaultServiceName
+			}
+			if wanted[service] {
+				return nil
+			}
+			if err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+				!apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete %s of service %s: %w", obj.GetName(), service, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// servicesCondition records the readiness of every backing service in the
+// status and summarises it as the ServicesReady component condition. It
+// returns nil for environments without services.
+func (r *DeveloperEnvironmentReconciler) servicesCondition(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (*componentCondition, error) {
+	services := devEnv.Spec.EffectiveServices()
+	if len(services) == 0 {
+		devEnv.Status.Services = nil
+		return nil, nil
+	}
+
+	var notReady []string
+	exists := false
+	statuses := make([]apiv1.ServiceStatus, 0, len(services))
+	for _, service := range services {
+		condition, err := r.serviceCondition(ctx, devEnv, service)
+		if err != nil {
+			return nil, err
+		}
+
+		status := apiv1.ServiceStatus{Name: service.Name, Type: service.Type}
+		for _, previous := range devEnv.Status.Services {
+			if previous.Name == service.Name {
+				status = previous
+			}
+		}
+		status.Ready = condition.Status == string(metav1.ConditionTrue)
+		status.Message = condition.Message
+		statuses = append(statuses, status)
+
+		exists = exists || condition.exists
+		if !status.Ready {
+			notReady = append(notReady, service.Name)
+		}
+	}
+	devEnv.Status.Services = statuses
+
+	if len(notReady) > 0 {
+		return &componentCondition{
+			Condition: conditionFromBool(apiv1.ConditionServicesReady, false, reasonUnavailable,
+				fmt.Sprintf("Waiting for %s", strings.Join(notReady, ", "))),
+			exists: exists,
+		}, nil
+	}
+	return &componentCondition{
+		Condition: conditionFromBool(apiv1.ConditionServicesReady, true, reasonAvailable,
+			fmt.Sprintf("%d service(s) ready", len(services))),
+		exists: exists,
+	}, nil
+}
+
+// servicePassword returns the password of a backing service, generating it
+// the first time. The password is kept in the connection Secret, since the
+// service only reads it when it initialises its volume.
+func (r *DeveloperEnvironmentReconciler) servicePassword(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	service string,
+) (string, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{
+		Name:      serviceConnectionSecretName(devEnv, service),
+		Namespace: environmentNamespace(devEnv),
+	}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get connection secret of service %s: %w", service, err)
+	}
+	if password := string(secret.Data["password"]); password != "" {
+		return password, nil
+	}
+	return randomPassword()
+}
+
+// legacyPassword is the password spec.database was created with before
+// passwords were generated
+const legacyPassword = "postgres"
+
+// seedLegacyPassword keeps the password of the postgres database of
+// spec.database created by an earlier version, which has a Deployment or a
+// volume but no connection Secret. Its data directory only accepts the
+// password it was initialised with.
+func (r *DeveloperEnvironmentReconciler) seedLegacyPassword(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	service apiv1.ServiceSpec,
+) error {
+	if service.Name != apiv1.DefaultServiceName || service.Type != "postgres" {
+		return nil
+	}
+	namespace := environmentNamespace(devEnv)
+	err := r.Get(ctx, types.NamespacedName{Name: serviceConnectionSecretName(devEnv, service.Name), Namespace: namespace},
+		&corev1.Secret{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get connection secret of service %s: %w", service.Name, err)
+	}
+
+	for _, obj := range []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: serviceObjectName(devEnv, service.Name)}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: databasePVCName(devEnv)}},
+	} {
+		err := r.getChild(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: namespace}, obj)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up existing database %s: %w", obj.GetName(), err)
+		}
+		return r.seedServicePassword(ctx, devEnv, service.Name, []byte(legacyPassword))
+	}
+	return nil
+}
+
+// seedServicePassword makes a backing service use the password its volume
+// was initialised with, for volumes that come from another environment. The
+// password is written into the connection Secret before the service starts.
+func (r *DeveloperEnvironmentReconciler) seedServicePassword(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	service string,
+	password []byte,
+) error {
+	key := types.NamespacedName{Name: serviceConnectionSecretName(devEnv, service), Namespace: environmentNamespace(devEnv)}
+	existing := &corev1.Secret{}
+	if err := r.Get(ctx, key, existing); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get connection secret of service %s: %w", service, err)
+	}
+	if bytes.Equal(existing.Data["password"], password) {
+		return nil
+	}
+
+	// The other connection details are kept until the service is set up again
+	data := maps.Clone(existing.Data)
+	if data == nil {
+		data = map[string][]byte{}
+	}
+	data["password"] = password
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    serviceLabels(devEnv, service),
+		},
+		Data: data,
+	}
+	if err := r.apply(ctx, devEnv, secret); err != nil {
+		return fmt.Errorf("failed to seed the password of service %s: %w", service, err)
+	}
+	return nil
+}
+
+// passwordAlphabet needs no escaping in URLs or shells
+const passwordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randomPassword() (string, error) {
+	password := make([]byte, 24)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(passwordAlphabet))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate password: %w", err)
+		}
+		password[i] = passwordAlphabet[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// serviceEnv exposes every backing service to the IDE as <SERVICE>_URL,
+// _HOST and _PORT, and _USER and _PASSWORD where the service has them, so
+// spec.database becomes DATABASE_URL.
+func serviceEnv(devEnv *apiv1.DeveloperEnvironment) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, service := range devEnv.Spec.EffectiveServices() {
+		kind := serviceTypes[service.Type]
+		prefix := strings.ToUpper(strings.ReplaceAll(service.Name, "-", "_"))
+		secret := serviceConnectionSecretName(devEnv, service.Name)
+		env = append(env,
+			secretEnv(prefix+"_URL", secret, "dsn"),
+			secretEnv(prefix+"_HOST", secret, "host"),
+			secretEnv(prefix+"_PORT", secret, "port"),
+		)
+		if kind.credentials.User != "" {
+			env = append(env, secretEnv(prefix+"_USER", secret, "user"))
+		}
+		if kind.password {
+			env = append(env, secretEnv(prefix+"_PASSWORD", secret, "password"))
+		}
+	}
+	return env
+}
+
+// serviceCondition reports whether the workload of a backing service is ready
+func (r *DeveloperEnvironmentReconciler) serviceCondition(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	service apiv1.ServiceSpec,
+) (componentCondition, error) {
+	key := types.NamespacedName{
+		Name:      serviceObjectName(devEnv, service.Name),
+		Namespace: environmentNamespace(devEnv),
+	}
+	if serviceTypes[service.Type].statefulSet {
+		return r.statefulSetCondition(ctx, apiv1.ConditionServicesReady, key)
+	}
+	return r.deploymentCondition(ctx, apiv1.ConditionServicesReady, key)
+}
+
+// databaseConnection holds everything a client needs to reach a backing service
+type databaseConnection struct {
+	Host     string
+	Port     int32
+	User     string
+	Password string
+	Database string
+	DSN      string
+}
+
+// ensureDatabaseConnectionSecret stores the connection details of a backing service in a Secret for tools and the IDE
+func (r *DeveloperEnvironmentReconciler) ensureDatabaseConnectionSecret(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	service string,
+	connection databaseConnection,
+) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceConnectionSecretName(devEnv, service),
+			Namespace: environmentNamespace(devEnv),
+			Labels:    serviceLabels(devEnv, service),
+		},
+		// Applied as data, which the server stores stringData as, so that the
+		// fields match those of seedServicePassword
+		Data: map[string][]byte{
+			"host":     []byte(connection.Host),
+			"port":     []byte(strconv.Itoa(int(connection.Port))),
+			"user":     []byte(connection.User),
+			"password": []byte(connection.Password),
+			"database": []byte(connection.Database),
+			"dsn":      []byte(connection.DSN),
+		},
+	}
+
+	if err := r.apply(ctx, devEnv, secret); err != nil {
+		return fmt.Errorf("failed to apply connection secret of service %s: %w", service, err)
+	}
+
+	return nil
+}
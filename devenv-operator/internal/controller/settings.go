@@ -0,0 +1,217 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+const (
+	// ideSettingsFile and settingsSyncScript are the keys of the settings ConfigMap
+	ideSettingsFile    = "settings.json"
+	settingsSyncScript = "sync-settings.js"
+	// settingsPath is where the settings ConfigMap is mounted
+	settingsPath = "/config/devenv-settings"
+	// userSettingsSubPath keeps the user settings directory of the IDE on the
+	// workspace volume, so changes made in the IDE survive restarts
+	userSettingsSubPath = ".devenv/user-settings"
+	// settingsSyncContainerName is the sidecar merging the settings into the user settings
+	settingsSyncContainerName = "settings-sync"
+	// settingsSyncNode is the Node.js runtime bundled with code-server
+	settingsSyncNode = "/app/code-server/lib/node"
+)
+
+// annotationSettingsChecksum rolls IDEs that only read their settings at startup
+const annotationSettingsChecksum = "devenv.adityajoshi.online/settings-checksum"
+
+// settingsSyncSource merges the settings managed by the operator into the
+// user settings, every 30 seconds since the ConfigMap volume is updated in
+// place. A setting the developer changed since it was last applied is left
+// alone, as is a settings file with comments, which is not plain JSON.
+const settingsSyncSource = `const fs = require('fs');
+const path = require('path');
+
+const [managedFile, userFile] = process.argv.slice(2);
+const appliedFile = path.join(path.dirname(userFile), '.devenv-applied.json');
+const uid = Number(process.env.PUID || 1000);
+const gid = Number(process.env.PGID || 1000);
+
+function read(file, fallback) {
+  if (!fs.existsSync(file)) return fallback;
+  try {
+    return JSON.parse(fs.readFileSync(file, 'utf8'));
+  } catch (err) {
+    return undefined;
+  }
+}
+
+function write(file, value) {
+  const tmp = file + '.tmp';
+  fs.writeFileSync(tmp, JSON.stringify(value, null, 4) + '\n');
+  fs.chownSync(tmp, uid, gid);
+  fs.renameSync(tmp, file);
+}
+
+const same = (a, b) => JSON.stringify(a) === JSON.stringify(b);
+
+function sync() {
+  const managed = read(managedFile, {});
+  const applied = read(appliedFile, {}) || {};
+  const user = read(userFile, {});
+  if (managed === undefined) return;
+  if (user === undefined) {
+    console.error(userFile + ' is not plain JSON, leaving it alone');
+    return;
+  }
+
+  const merged = { ...user };
+  for (const key of new Set([...Object.keys(managed), ...Object.keys(applied)])) {
+    const changedByUser = key in applied ? !(key in user && same(user[key], applied[key])) : key in user;
+    if (changedByUser) continue;
+    if (key in managed) merged[key] = managed[key];
+    else delete merged[key];
+  }
+  if (!same(merged, user) || !fs.existsSync(userFile)) write(userFile, merged);
+  if (!same(managed, applied)) write(appliedFile, managed);
+}
+
+fs.mkdirSync(path.dirname(userFile), { recursive: true });
+fs.chownSync(path.dirname(userFile), uid, gid);
+sync();
+setInterval(sync, 30000);
+`
+
+func ideSettingsConfigMapName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-ide-settings", devEnv.Name)
+}
+
+// ideSettings renders the settings of the IDE. The settings of code-server
+// are layered over the team defaults of the operator.
+func (r *DeveloperEnvironmentReconciler) ideSettings(devEnv *apiv1.DeveloperEnvironment, kind ideType) (string, error) {
+	if kind.renderSettings == nil {
+		return "", nil
+	}
+	settings := devEnv.Spec.IDE.Settings
+	if kind.syncSettings && len(r.Defaults.IDESettings) > 0 {
+		settings = maps.Clone(r.Defaults.IDESettings)
+		maps.Copy(settings, devEnv.Spec.IDE.Settings)
+	}
+	if len(settings) == 0 {
+		return "", nil
+	}
+	return kind.renderSettings(settings)
+}
+
+// setupIDESettings renders the settings of the IDE into a ConfigMap next to
+// it and returns the rendered settings, or nothing when there are none
+func (r *DeveloperEnvironmentReconciler) setupIDESettings(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	kind ideType,
+) (string, error) {
+	settings, err := r.ideSettings(devEnv, kind)
+	if err != nil {
+		return "", err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ideSettingsConfigMapName(devEnv),
+			Namespace: environmentNamespace(devEnv),
+			Labels: map[string]string{
+				"app":           "vscode-server",
+				"developer-env": devEnv.Name,
+			},
+		},
+		Data: map[string]string{
+			ideSettingsFile: settings,
+		},
+	}
+	if settings == "" {
+		if err := r.Delete(ctx, configMap); err != nil && !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to delete IDE settings ConfigMap: %w", err)
+		}
+		return "", nil
+	}
+	if kind.syncSettings {
+		configMap.Data[settingsSyncScript] = settingsSyncSource
+	}
+	if err := r.apply(ctx, devEnv, configMap); err != nil {
+		return "", fmt.Errorf("failed to apply IDE settings ConfigMap: %w", err)
+	}
+	return settings, nil
+}
+
+// settingsVolume mounts the settings ConfigMap
+func settingsVolume(devEnv *apiv1.DeveloperEnvironment) corev1.Volume {
+	return corev1.Volume{
+		Name: "ide-settings",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: ideSettingsConfigMapName(devEnv),
+				},
+			},
+		},
+	}
+}
+
+// userSettingsMount mounts the user settings directory of the IDE from the workspace volume
+func userSettingsMount(kind ideType) corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      "workspace",
+		MountPath: path.Dir(kind.settingsPath),
+		SubPath:   userSettingsSubPath,
+	}
+}
+
+// settingsSyncContainer runs the settings sync script next to the IDE, in
+// the image of the IDE for its Node.js runtime
+func settingsSyncContainer(devEnv *apiv1.DeveloperEnvironment, kind ideType) corev1.Container {
+	return corev1.Container{
+		Name:            settingsSyncContainerName,
+		Image:           devEnv.Status.Runtime.Image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{
+			settingsSyncNode,
+			path.Join(settingsPath, settingsSyncScript),
+			path.Join(settingsPath, ideSettingsFile),
+			kind.settingsPath,
+		},
+		Env: []corev1.EnvVar{
+			{Name: "PUID", Value: "1000"},
+			{Name: "PGID", Value: "1000"},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "ide-settings",
+				MountPath: settingsPath,
+				ReadOnly:  true,
+			},
+			userSettingsMount(kind),
+		},
+	}
+}
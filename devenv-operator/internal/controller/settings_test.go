@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestIDESettings(t *testing.T) {
+	r := &DeveloperEnvironmentReconciler{Defaults: EnvironmentDefaults{
+		IDESettings: map[string]string{"editor.tabSize": "2", "files.autoSave": `"afterDelay"`},
+	}}
+
+	tests := []struct {
+		name     string
+		ide      apiv1.IDEType
+		settings map[string]string
+		want     map[string]any
+	}{
+		{
+			name: "code-server gets the team defaults",
+			ide:  apiv1.IDETypeVSCode,
+			want: map[string]any{"editor.tabSize": float64(2), "files.autoSave": "afterDelay"},
+		},
+		{
+			name:     "environment settings override the team defaults",
+			ide:      apiv1.IDETypeVSCode,
+			settings: map[string]string{"editor.tabSize": "4", "editor.fontSize": "14"},
+			want:     map[string]any{"editor.tabSize": float64(4), "editor.fontSize": float64(14), "files.autoSave": "afterDelay"},
+		},
+		{
+			name: "JupyterLab does not get the VS Code defaults",
+			ide:  apiv1.IDETypeJupyterLab,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := &apiv1.DeveloperEnvironment{}
+			devEnv.Spec.IDE.Type = tt.ide
+			devEnv.Spec.IDE.Settings = tt.settings
+			rendered, err := r.ideSettings(devEnv, ideTypes[tt.ide])
+			if err != nil {
+				t.Fatalf("ideSettings() error = %v", err)
+			}
+			if tt.want == nil {
+				if rendered != "" {
+					t.Errorf("ideSettings() = %q, want none", rendered)
+				}
+				return
+			}
+			var got map[string]any
+			if err := json.Unmarshal([]byte(rendered), &got); err != nil {
+				t.Fatalf("ideSettings() = %q is not JSON: %v", rendered, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ideSettings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,750 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+const (
+	// labelSnapshot and labelSnapshotNamespace name the DeveloperEnvironmentSnapshot an object belongs to
+	labelSnapshot          = "devenv.adityajoshi.online/snapshot"
+	labelSnapshotNamespace = "devenv.adityajoshi.online/snapshot-namespace"
+
+	// annotationSnapshotKey records the S3 object a backup Job uploads to
+	annotationSnapshotKey = "devenv.adityajoshi.online/snapshot-key"
+)
+
+// workspaceVolume names the workspace among the volumes of a snapshot
+const workspaceVolume = "workspace"
+
+// archiveImage archives and extracts the volumes without a dump tool
+const archiveImage = "alpine:3.20"
+
+// archiveExt is the extension of volume archives, telling them apart from database dumps
+const archiveExt = ".tar.gz"
+
+// backupPath is where backup Jobs write the archive or dump to upload
+const backupPath = "/backup"
+
+var (
+	volumeSnapshotGVK        = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshot"}
+	volumeSnapshotContentGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshotContent"}
+)
+
+// DeveloperEnvironmentSnapshotReconciler captures the volumes of a DeveloperEnvironment
+type DeveloperEnvironmentSnapshotReconciler struct {
+	client.Client
+	// APIReader reads the credentials of the bucket, which belong to the user and are not cached
+	APIReader client.Reader
+	Scheme    *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=api.adityajoshi.online,resources=developerenvironmentsnapshots,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=api.adityajoshi.online,resources=developerenvironmentsnapshots/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=api.adityajoshi.online,resources=developerenvironmentsnapshots/finalizers,verbs=update
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots;volumesnapshotcontents,verbs=get;list;watch;create;update;patch;delete
+func (r *DeveloperEnvironmentSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	snapshot := &apiv1.DeveloperEnvironmentSnapshot{}
+	if err := r.Get(ctx, req.NamespacedName, snapshot); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if snapshot.DeletionTimestamp != nil {
+		if containsString(snapshot.Finalizers, finalizerString) {
+			if err := r.deleteCaptures(ctx, snapshot); err != nil {
+				return ctrl.Result{}, err
+			}
+			snapshot.Finalizers = removeString(snapshot.Finalizers, finalizerString)
+			if err := r.Update(ctx, snapshot); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+	if !containsString(snapshot.Finalizers, finalizerString) {
+		snapshot.Finalizers = append(snapshot.Finalizers, finalizerString)
+		if err := r.Update(ctx, snapshot); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// A snapshot is taken once. The backup Jobs of a failed one are kept to inspect.
+	switch snapshot.Status.Phase {
+	case apiv1.SnapshotPhaseReady:
+		return ctrl.Result{}, r.deleteBackupJobs(ctx, snapshot)
+	case apiv1.SnapshotPhaseFailed:
+		return ctrl.Result{}, nil
+	}
+
+	captureErr := r.capture(ctx, snapshot)
+	if captureErr != nil {
+		snapshot.Status.Message = captureErr.Error()
+	}
+	if err := r.Status().Update(ctx, snapshot); err != nil {
+		return ctrl.Result{}, err
+	}
+	if captureErr != nil {
+		logger.Error(captureErr, "Failed to capture developer environment snapshot")
+		return ctrl.Result{RequeueAfter: time.Minute}, captureErr
+	}
+
+	// Backup Jobs are watched, but the progress of VolumeSnapshots is polled
+	if snapshot.Status.Phase == apiv1.SnapshotPhaseReady || snapshot.Status.Phase == apiv1.SnapshotPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+}
+
+// capture takes the snapshot of every volume of the environment and records
+// their progress in the snapshot status
+func (r *DeveloperEnvironmentSnapshotReconciler) capture(ctx context.Context, snapshot *apiv1.DeveloperEnvironmentSnapshot) error {
+	status := &snapshot.Status
+
+	devEnv := &apiv1.DeveloperEnvironment{}
+	err := r.Get(ctx, types.NamespacedName{Name: snapshot.Spec.EnvironmentName, Namespace: snapshot.Namespace}, devEnv)
+	if apierrors.IsNotFound(err) {
+		status.Phase = apiv1.SnapshotPhaseFailed
+		status.Message = fmt.Sprintf("DeveloperEnvironment %s not found", snapshot.Spec.EnvironmentName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch devEnv.Status.Phase {
+	case "", apiv1.PhasePending, apiv1.PhaseProvisioning:
+		status.Phase = apiv1.SnapshotPhasePending
+		status.Message = fmt.Sprintf("Waiting for DeveloperEnvironment %s to be provisioned", devEnv.Name)
+		return nil
+	case apiv1.PhaseTerminating:
+		// An environment deleted with the Snapshot policy waits for its snapshot
+		if devEnv.Spec.DeletionPolicy == apiv1.DeletionPolicySnapshot {
+			break
+		}
+		status.Phase = apiv1.SnapshotPhaseFailed
+		status.Message = fmt.Sprintf("DeveloperEnvironment %s is being deleted", devEnv.Name)
+		return nil
+	}
+
+	// The method and the volumes are settled when the capture starts
+	if status.Method == "" {
+		method, err := r.snapshotMethod(snapshot)
+		if err != nil {
+			return err
+		}
+		if method == "" {
+			status.Phase = apiv1.SnapshotPhaseFailed
+			status.Message = "The cluster does not support VolumeSnapshots and spec.s3 is not set"
+			return nil
+		}
+		status.Method = method
+		status.Namespace = environmentNamespace(devEnv)
+		status.Volumes = snapshotVolumes(devEnv)
+	}
+	if err := r.capturePasswords(ctx, snapshot, devEnv); err != nil {
+		return err
+	}
+
+	status.Message = ""
+	for i := range status.Volumes {
+		volume := &status.Volumes[i]
+		if volume.Phase == apiv1.SnapshotPhaseReady || volume.Phase == apiv1.SnapshotPhaseFailed {
+			continue
+		}
+		if status.Method == apiv1.SnapshotMethodVolumeSnapshot {
+			err = r.captureVolumeSnapshot(ctx, snapshot, volume)
+		} else {
+			err = r.captureS3(ctx, snapshot, devEnv, volume)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to capture volume %s: %w", volume.Name, err)
+		}
+	}
+
+	status.Phase = snapshotPhase(status.Volumes)
+	if status.Phase == apiv1.SnapshotPhaseReady {
+		status.CompletedAt = &metav1.Time{Time: time.Now()}
+	}
+	return nil
+}
+
+// snapshotMethod resolves the Auto method to VolumeSnapshots when the
+// cluster serves them, and to S3 otherwise. It returns an empty method when
+// neither is possible.
+func (r *DeveloperEnvironmentSnapshotReconciler) snapshotMethod(
+	snapshot *apiv1.DeveloperEnvironmentSnapshot,
+) (apiv1.SnapshotMethod, error) {
+	if snapshot.Spec.Method != "" && snapshot.Spec.Method != apiv1.SnapshotMethodAuto {
+		return snapshot.Spec.Method, nil
+	}
+	_, err := r.RESTMapper().RESTMapping(volumeSnapshotGVK.GroupKind(), volumeSnapshotGVK.Version)
+	switch {
+	case err == nil:
+		return apiv1.SnapshotMethodVolumeSnapshot, nil
+	case !meta.IsNoMatchError(err):
+		return "", err
+	case snapshot.Spec.S3 != nil:
+		return apiv1.SnapshotMethodS3, nil
+	default:
+		return "", nil
+	}
+}
+
+// snapshotVolumes lists the volumes of an environment: its workspace and the
+// volume of every backing service
+func snapshotVolumes(devEnv *apiv1.DeveloperEnvironment) []apiv1.SnapshotVolume {
+	volumes := []apiv1.SnapshotVolume{
+		{
+			Name:      workspaceVolume,
+			ClaimName: workspacePVCName(devEnv),
+			Phase:     apiv1.SnapshotPhasePending,
+		},
+	}
+	for _, service := range devEnv.Spec.EffectiveServices() {
+		volumes = append(volumes, apiv1.SnapshotVolume{
+			Name:      service.Name,
+			Type:      service.Type,
+			ClaimName: servicePVCName(devEnv, service.Name),
+			Phase:     apiv1.SnapshotPhasePending,
+		})
+	}
+	return volumes
+}
+
+// snapshotPhase summarises the capture of every volume
+func snapshotPhase(volumes []apiv1.SnapshotVolume) apiv1.SnapshotPhase {
+	ready := 0
+	for _, volume := range volumes {
+		switch volume.Phase {
+		case apiv1.SnapshotPhaseFailed:
+			return apiv1.SnapshotPhaseFailed
+		case apiv1.SnapshotPhaseReady:
+			ready++
+		}
+	}
+	if ready == len(volumes) {
+		return apiv1.SnapshotPhaseReady
+	}
+	return apiv1.SnapshotPhaseInProgress
+}
+
+// snapshotObjectName names the VolumeSnapshot or backup Job capturing a volume
+func snapshotObjectName(snapshot *apiv1.DeveloperEnvironmentSnapshot, volume string) string {
+	return fmt.Sprintf("%s-%s", snapshot.Name, volume)
+}
+
+// snapshotCredentialsName names the copy of the bucket credentials next to the backup Jobs
+func snapshotCredentialsName(snapshot *apiv1.DeveloperEnvironmentSnapshot) string {
+	return fmt.Sprintf("%s-s3", snapshot.Name)
+}
+
+// snapshotPasswordsName names the Secret holding the passwords of the backing
+// services, keyed by service name
+func snapshotPasswordsName(snapshot *apiv1.DeveloperEnvironmentSnapshot) string {
+	return fmt.Sprintf("%s-passwords", snapshot.Name)
+}
+
+// capturePasswords keeps the passwords of the backing services with the
+// snapshot, since their data directories only accept the password they were
+// initialised with
+func (r *DeveloperEnvironmentSnapshotReconciler) capturePasswords(
+	ctx context.Context,
+	snapshot *apiv1.DeveloperEnvironmentSnapshot,
+	devEnv *apiv1.DeveloperEnvironment,
+) error {
+	passwords := map[string][]byte{}
+	for _, volume := range snapshot.Status.Volumes {
+		if !serviceTypes[volume.Type].password {
+			continue
+		}
+		connection := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{
+			Name:      serviceConnectionSecretName(devEnv, volume.Name),
+			Namespace: snapshot.Status.Namespace,
+		}, connection)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get connection secret of service %s: %w", volume.Name, err)
+		}
+		if password := connection.Data["password"]; len(password) > 0 {
+			passwords[volume.Name] = password
+		}
+	}
+	if len(passwords) == 0 {
+		return nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshotPasswordsName(snapshot),
+			Namespace: snapshot.Namespace,
+		},
+		Data: passwords,
+	}
+	if err := r.create(ctx, snapshot, secret); err != nil {
+		return fmt.Errorf("failed to create snapshot passwords: %w", err)
+	}
+	return nil
+}
+
+// captureVolumeSnapshot takes a CSI VolumeSnapshot of a volume
+func (r *DeveloperEnvironmentSnapshotReconciler) captureVolumeSnapshot(
+	ctx context.Context,
+	snapshot *apiv1.DeveloperEnvironmentSnapshot,
+	volume *apiv1.SnapshotVolume,
+) error {
+	volumeSnapshot := &unstructured.Unstructured{}
+	volumeSnapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	key := types.NamespacedName{Name: snapshotObjectName(snapshot, volume.Name), Namespace: snapshot.Status.Namespace}
+	err := r.Get(ctx, key, volumeSnapshot)
+	if apierrors.IsNotFound(err) {
+		volumeSnapshot.SetName(key.Name)
+		volumeSnapshot.SetNamespace(key.Namespace)
+		spec := map[string]interface{}{
+			"source": map[string]interface{}{
+				"persistentVolumeClaimName": volume.ClaimName,
+			},
+		}
+		if class := snapshot.Spec.VolumeSnapshotClassName; class != "" {
+			spec["volumeSnapshotClassName"] = class
+		}
+		volumeSnapshot.Object["spec"] = spec
+		if err := r.create(ctx, snapshot, volumeSnapshot); err != nil {
+			return fmt.Errorf("failed to create VolumeSnapshot: %w", err)
+		}
+		volume.Phase = apiv1.SnapshotPhaseInProgress
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get VolumeSnapshot: %w", err)
+	}
+
+	// The snapshot controller retries failed snapshots, so errors are only reported
+	volume.Phase = apiv1.SnapshotPhaseInProgress
+	volume.Message, _, _ = unstructured.NestedString(volumeSnapshot.Object, "status", "error", "message")
+	ready, _, _ := unstructured.NestedBool(volumeSnapshot.Object, "status", "readyToUse")
+	content, _, _ := unstructured.NestedString(volumeSnapshot.Object, "status", "boundVolumeSnapshotContentName")
+	if !ready || content == "" {
+		return nil
+	}
+
+	// The VolumeSnapshot is deleted with the namespace of the environment,
+	// which must leave the snapshot itself to restore from
+	if err := r.setDeletionPolicy(ctx, content, "Retain"); err != nil {
+		return err
+	}
+	volume.VolumeSnapshotContentName = content
+	if size, found, _ := unstructured.NestedString(volumeSnapshot.Object, "status", "restoreSize"); found {
+		if quantity, err := resource.ParseQuantity(size); err == nil {
+			volume.Size = &quantity
+		}
+	}
+	volume.Phase = apiv1.SnapshotPhaseReady
+	volume.Message = ""
+	return nil
+}
+
+// setDeletionPolicy sets whether deleting a VolumeSnapshotContent deletes the snapshot on the storage backend
+func (r *DeveloperEnvironmentSnapshotReconciler) setDeletionPolicy(ctx context.Context, name, policy string) error {
+	content := &unstructured.Unstructured{}
+	content.SetGroupVersionKind(volumeSnapshotContentGVK)
+	content.SetName(name)
+	patch := []byte(fmt.Sprintf(`{"spec":{"deletionPolicy":%q}}`, policy))
+	if err := r.Patch(ctx, content, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return fmt.Errorf("failed to set the deletion policy of VolumeSnapshotContent %s: %w", name, err)
+	}
+	return nil
+}
+
+// captureS3 uploads a dump or an archive of a volume to the bucket of the
+// snapshot with a one-shot Job
+func (r *DeveloperEnvironmentSnapshotReconciler) captureS3(
+	ctx context.Context,
+	snapshot *apiv1.DeveloperEnvironmentSnapshot,
+	devEnv *apiv1.DeveloperEnvironment,
+	volume *apiv1.SnapshotVolume,
+) error {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: snapshotObjectName(snapshot, volume.Name), Namespace: snapshot.Status.Namespace}, job)
+	if apierrors.IsNotFound(err) {
+		if err := r.copyCredentials(ctx, snapshot); err != nil {
+			return err
+		}
+		node, err := r.claimNode(ctx, snapshot.Status.Namespace, volume.ClaimName)
+		if err != nil {
+			return err
+		}
+		job = backupJob(snapshot, devEnv, *volume, node)
+		if err := r.create(ctx, snapshot, job); err != nil {
+			return fmt.Errorf("failed to create backup job: %w", err)
+		}
+		volume.Key = job.Annotations[annotationSnapshotKey]
+		volume.Phase = apiv1.SnapshotPhaseInProgress
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get backup job: %w", err)
+	}
+
+	volume.Key = job.Annotations[annotationSnapshotKey]
+	switch seed := seedJobStatus(job, ""); seed.Phase {
+	case apiv1.SeedPhaseSucceeded:
+		volume.Phase = apiv1.SnapshotPhaseReady
+	case apiv1.SeedPhaseFailed:
+		volume.Phase = apiv1.SnapshotPhaseFailed
+		volume.Message = seed.Message
+	default:
+		volume.Phase = apiv1.SnapshotPhaseInProgress
+	}
+	return nil
+}
+
+// copyCredentials copies the credentials of the bucket next to the backup
+// Jobs, which run in the namespace of the environment
+func (r *DeveloperEnvironmentSnapshotReconciler) copyCredentials(ctx context.Context, snapshot *apiv1.DeveloperEnvironmentSnapshot) error {
+	source := &corev1.Secret{}
+	if err := r.APIReader.Get(ctx, types.NamespacedName{Name: snapshot.Spec.S3.CredentialsSecret, Namespace: snapshot.Namespace}, source); err != nil {
+		return fmt.Errorf("failed to get snapshot credentials: %w", err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshotCredentialsName(snapshot),
+			Namespace: snapshot.Status.Namespace,
+		},
+		Data: map[string][]byte{
+			"accesskey": source.Data["accesskey"],
+			"secretkey": source.Data["secretkey"],
+		},
+	}
+	if err := r.create(ctx, snapshot, secret); err != nil {
+		return fmt.Errorf("failed to create snapshot credentials: %w", err)
+	}
+	return nil
+}
+
+// claimNode returns the node of a running pod that mounts a claim. A
+// ReadWriteOnce volume can only be mounted by other pods on the same node.
+func (r *DeveloperEnvironmentSnapshotReconciler) claimNode(ctx context.Context, namespace, claim string) (string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(namespace)); err != nil {
+		return "", fmt.Errorf("failed to list pods: %w", err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claim {
+				return pod.Spec.NodeName, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// backupJob returns the Job uploading a volume to the bucket of the snapshot.
+// Running databases with a dump tool are dumped, since an archive of their
+// files would be inconsistent; other volumes are archived, on the node of
+// the pod mounting them if any.
+func backupJob(
+	snapshot *apiv1.DeveloperEnvironmentSnapshot,
+	devEnv *apiv1.DeveloperEnvironment,
+	volume apiv1.SnapshotVolume,
+	node string,
+) *batchv1.Job {
+	var (
+		service apiv1.ServiceSpec
+		status  *apiv1.ServiceStatus
+	)
+	for _, s := range devEnv.Spec.EffectiveServices() {
+		if s.Name == volume.Name {
+			service = s
+		}
+	}
+	for i := range devEnv.Status.Services {
+		if devEnv.Status.Services[i].Name == volume.Name {
+			status = &devEnv.Status.Services[i]
+		}
+	}
+	kind := serviceTypes[volume.Type]
+	dump := kind.dump != "" && status != nil && !devEnv.Status.Suspended
+
+	file := volume.Name + archiveExt
+	if dump {
+		file = volume.Name + kind.dumpExt
+	}
+	key := path.Join(strings.Trim(snapshot.Spec.S3.Key, "/"), snapshot.Name, file)
+
+	volumes := []corev1.Volume{
+		{
+			Name:         "backup",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+	backupMount := corev1.VolumeMount{Name: "backup", MountPath: backupPath}
+
+	var backup corev1.Container
+	var affinity *corev1.Affinity
+	if dump {
+		secret := serviceConnectionSecretName(devEnv, service.Name)
+		connection := databaseConnection{
+			Host:     status.Host,
+			Port:     status.Port,
+			User:     status.User,
+			Database: status.Name,
+		}
+		env := []corev1.EnvVar{
+			{Name: "BACKUP_FILE", Value: backupPath + "/" + file},
+			secretEnv("DATABASE_URL", secret, "dsn"),
+		}
+		if kind.seedEnv != nil {
+			env = append(env, kind.seedEnv(connection, secret)...)
+		}
+		backup = corev1.Container{
+			Name:         "dump",
+			Image:        serviceImage(service),
+			Command:      []string{"/bin/sh", "-c", kind.dump},
+			Env:          env,
+			VolumeMounts: []corev1.VolumeMount{backupMount},
+		}
+	} else {
+		volumes = append(volumes, corev1.Volume{
+			Name: "data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: volume.ClaimName,
+					ReadOnly:  true,
+				},
+			},
+		})
+		backup = corev1.Container{
+			Name:    "archive",
+			Image:   archiveImage,
+			Command: []string{"tar", "czf", backupPath + "/" + file, "-C", "/data", "."},
+			VolumeMounts: []corev1.VolumeMount{
+				backupMount,
+				{Name: "data", MountPath: "/data", ReadOnly: true},
+			},
+		}
+		if node != "" {
+			affinity = &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{
+								MatchFields: []corev1.NodeSelectorRequirement{
+									{
+										Key:      metav1.ObjectNameField,
+										Operator: corev1.NodeSelectorOpIn,
+										Values:   []string{node},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+		}
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshotObjectName(snapshot, volume.Name),
+			Namespace: snapshot.Status.Namespace,
+			Annotations: map[string]string{
+				annotationSnapshotKey: key,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: Ptr(int32(2)),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					// The network policies let backup pods of the environment reach its backing services
+					Labels: map[string]string{
+						"app":           "database-backup",
+						"developer-env": devEnv.Name,
+					},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:  corev1.RestartPolicyNever,
+					Affinity:       affinity,
+					InitContainers: []corev1.Container{backup},
+					Containers: []corev1.Container{
+						{
+							Name:         "upload",
+							Image:        mcImage,
+							Command:      []string{"mc"},
+							Args:         []string{"cp", backupPath + "/" + file, s3Path("target", snapshot.Spec.S3.Bucket, key)},
+							Env:          mcHostEnv("target", snapshot.Spec.S3.Endpoint, snapshotCredentialsName(snapshot)),
+							VolumeMounts: []corev1.VolumeMount{backupMount},
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
+
+// deleteBackupJobs deletes the backup Jobs of a snapshot and the copy of its credentials
+func (r *DeveloperEnvironmentSnapshotReconciler) deleteBackupJobs(ctx context.Context, snapshot *apiv1.DeveloperEnvironmentSnapshot) error {
+	if snapshot.Status.Method != apiv1.SnapshotMethodS3 {
+		return nil
+	}
+	for _, volume := range snapshot.Status.Volumes {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshotObjectName(snapshot, volume.Name),
+			Namespace: snapshot.Status.Namespace,
+		}}
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete backup job %s: %w", job.Name, err)
+		}
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:      snapshotCredentialsName(snapshot),
+		Namespace: snapshot.Status.Namespace,
+	}}
+	if err := r.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete snapshot credentials: %w", err)
+	}
+	return nil
+}
+
+// deleteCaptures deletes what a snapshot captured, except for the objects
+// uploaded to S3, which the bucket's lifecycle rules are left to expire
+func (r *DeveloperEnvironmentSnapshotReconciler) deleteCaptures(ctx context.Context, snapshot *apiv1.DeveloperEnvironmentSnapshot) error {
+	if err := r.deleteBackupJobs(ctx, snapshot); err != nil {
+		return err
+	}
+	if snapshot.Status.Method != apiv1.SnapshotMethodVolumeSnapshot {
+		return nil
+	}
+
+	for _, volume := range snapshot.Status.Volumes {
+		volumeSnapshot := &unstructured.Unstructured{}
+		volumeSnapshot.SetGroupVersionKind(volumeSnapshotGVK)
+		volumeSnapshot.SetName(snapshotObjectName(snapshot, volume.Name))
+		volumeSnapshot.SetNamespace(snapshot.Status.Namespace)
+		if err := r.Delete(ctx, volumeSnapshot); err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return fmt.Errorf("failed to delete VolumeSnapshot %s: %w", volumeSnapshot.GetName(), err)
+		}
+		if volume.VolumeSnapshotContentName == "" {
+			continue
+		}
+
+		// The content was retained, so the snapshot on the storage backend
+		// is only deleted once the policy is set back
+		err := r.setDeletionPolicy(ctx, volume.VolumeSnapshotContentName, "Delete")
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		content := &unstructured.Unstructured{}
+		content.SetGroupVersionKind(volumeSnapshotContentGVK)
+		content.SetName(volume.VolumeSnapshotContentName)
+		if err := r.Delete(ctx, content); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete VolumeSnapshotContent %s: %w", content.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// create creates an object of a snapshot unless it exists. Objects are
+// labeled with their snapshot so that they are watched, and objects in the
+// namespace of the snapshot are also owned by it.
+func (r *DeveloperEnvironmentSnapshotReconciler) create(
+	ctx context.Context,
+	snapshot *apiv1.DeveloperEnvironmentSnapshot,
+	obj client.Object,
+) error {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[labelSnapshot] = snapshot.Name
+	labels[labelSnapshotNamespace] = snapshot.Namespace
+	labels[labelManagedBy] = managedBy
+	obj.SetLabels(labels)
+
+	if obj.GetNamespace() == snapshot.Namespace {
+		if err := controllerutil.SetControllerReference(snapshot, obj, r.Scheme); err != nil {
+			return err
+		}
+	}
+	if err := r.Create(ctx, obj); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// snapshotForObject maps an object labeled with its snapshot back to the snapshot
+func (r *DeveloperEnvironmentSnapshotReconciler) snapshotForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	name, namespace := obj.GetLabels()[labelSnapshot], obj.GetLabels()[labelSnapshotNamespace]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DeveloperEnvironmentSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&apiv1.DeveloperEnvironmentSnapshot{}).
+		Watches(&batchv1.Job{}, handler.EnqueueRequestsFromMapFunc(r.snapshotForObject)).
+		Complete(r)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestSnapshotPhase(t *testing.T) {
+	ready := apiv1.SnapshotVolume{Name: "workspace", Phase: apiv1.SnapshotPhaseReady}
+	inProgress := apiv1.SnapshotVolume{Name: "database", Phase: apiv1.SnapshotPhaseInProgress}
+	failed := apiv1.SnapshotVolume{Name: "cache", Phase: apiv1.SnapshotPhaseFailed}
+
+	tests := []struct {
+		name    string
+		volumes []apiv1.SnapshotVolume
+		want    apiv1.SnapshotPhase
+	}{
+		{name: "all ready", volumes: []apiv1.SnapshotVolume{ready, ready}, want: apiv1.SnapshotPhaseReady},
+		{name: "in progress", volumes: []apiv1.SnapshotVolume{ready, inProgress}, want: apiv1.SnapshotPhaseInProgress},
+		{name: "failure wins", volumes: []apiv1.SnapshotVolume{inProgress, failed}, want: apiv1.SnapshotPhaseFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snapshotPhase(tt.volumes); got != tt.want {
+				t.Errorf("snapshotPhase() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupJob(t *testing.T) {
+	snapshot := &apiv1.DeveloperEnvironmentSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "monday", Namespace: "default"},
+		Spec: apiv1.DeveloperEnvironmentSnapshotSpec{
+			EnvironmentName: "golang-env",
+			SnapshotOptions: apiv1.SnapshotOptions{
+				S3: &apiv1.S3Location{Endpoint: "https://minio.example.com", Bucket: "snapshots", Key: "/envs/"},
+			},
+		},
+		Status: apiv1.DeveloperEnvironmentSnapshotStatus{Namespace: "devenv-golang-env"},
+	}
+	devEnv := &apiv1.DeveloperEnvironment{
+		ObjectMeta: metav1.ObjectMeta{Name: "golang-env", Namespace: "default"},
+		Spec: apiv1.DeveloperEnvironmentSpec{
+			Database: &apiv1.DatabaseSpec{Type: "postgres", Version: "16"},
+		},
+		Status: apiv1.DeveloperEnvironmentStatus{
+			Services: []apiv1.ServiceStatus{{Name: "database", Type: "postgres"}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		volume    apiv1.SnapshotVolume
+		suspended bool
+		wantKey   string
+		wantInit  string
+	}{
+		{
+			name:     "workspace is archived",
+			volume:   apiv1.SnapshotVolume{Name: "workspace", ClaimName: "golang-env-vscode-workspace"},
+			wantKey:  "envs/monday/workspace.tar.gz",
+			wantInit: "archive",
+		},
+		{
+			name:     "running database is dumped",
+			volume:   apiv1.SnapshotVolume{Name: "database", Type: "postgres", ClaimName: "golang-env-db-pvc"},
+			wantKey:  "envs/monday/database.dump",
+			wantInit: "dump",
+		},
+		{
+			name:      "suspended database is archived",
+			volume:    apiv1.SnapshotVolume{Name: "database", Type: "postgres", ClaimName: "golang-env-db-pvc"},
+			suspended: true,
+			wantKey:   "envs/monday/database.tar.gz",
+			wantInit:  "archive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := devEnv.DeepCopy()
+			env.Status.Suspended = tt.suspended
+			job := backupJob(snapshot, env, tt.volume, "node-1")
+			if got := job.Annotations[annotationSnapshotKey]; got != tt.wantKey {
+				t.Errorf("key = %s, want %s", got, tt.wantKey)
+			}
+			if got := job.Spec.Template.Spec.InitContainers[0].Name; got != tt.wantInit {
+				t.Errorf("init container = %s, want %s", got, tt.wantInit)
+			}
+			upload := job.Spec.Template.Spec.Containers[0]
+			if target := upload.Args[len(upload.Args)-1]; !strings.HasPrefix(target, "target/snapshots/"+tt.wantKey) {
+				t.Errorf("upload target = %s, want target/snapshots/%s", target, tt.wantKey)
+			}
+		})
+	}
+}
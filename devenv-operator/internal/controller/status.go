@@ -0,0 +1,505 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// Condition reasons
+const (
+	reasonReconcileSucceeded = "ReconcileSucceeded"
+	reasonReconcileFailed    = "ReconcileFailed"
+	reasonNotFound           = "NotFound"
+	reasonAvailable          = "Available"
+	reasonUnavailable        = "Unavailable"
+	reasonCertificateIssued  = "Issued"
+	reasonCertificatePending = "Pending"
+	reasonIngressCreated     = "Created"
+	reasonStatusUnknown      = "StatusUnknown"
+	reasonRollingOut         = "RollingOut"
+	// reasonProgressDeadlineExceeded is also the reason the Deployment controller gives up with
+	reasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	reasonProvisioning             = "Provisioning"
+	reasonProvisioningTimeout      = "ProvisioningTimeout"
+	reasonReady                    = "Ready"
+)
+
+// componentCondition pairs a component condition with whether its object exists at all
+type componentCondition struct {
+	apiv1.Condition
+	exists bool
+}
+
+// setCondition adds or replaces the condition of the same type, bumping
+// LastTransitionTime only when the status actually changes.
+func setCondition(devEnv *apiv1.DeveloperEnvironment, cond apiv1.Condition) {
+	cond.ObservedGeneration = devEnv.Generation
+	for i := range devEnv.Status.Conditions {
+		existing := &devEnv.Status.Conditions[i]
+		if existing.Type != cond.Type {
+			continue
+		}
+		if existing.Status == cond.Status {
+			cond.LastTransitionTime = existing.LastTransitionTime
+		} else {
+			cond.LastTransitionTime = metav1.Now()
+		}
+		*existing = cond
+		return
+	}
+	cond.LastTransitionTime = metav1.Now()
+	devEnv.Status.Conditions = append(devEnv.Status.Conditions, cond)
+}
+
+// removeCondition drops the condition of a component the environment no longer has
+func removeCondition(devEnv *apiv1.DeveloperEnvironment, conditionType string) {
+	conditions := devEnv.Status.Conditions[:0]
+	for _, c := range devEnv.Status.Conditions {
+		if c.Type != conditionType {
+			conditions = append(conditions, c)
+		}
+	}
+	devEnv.Status.Conditions = conditions
+}
+
+func conditionFromBool(conditionType string, ready bool, reason, message string) apiv1.Condition {
+	status := metav1.ConditionFalse
+	if ready {
+		status = metav1.ConditionTrue
+	}
+	return apiv1.Condition{
+		Type:    conditionType,
+		Status:  string(status),
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// deploymentCondition reports a Deployment as ready once its current spec has rolled out
+func (r *DeveloperEnvironmentReconciler) deploymentCondition(
+	ctx context.Context,
+	conditionType string,
+	key types.NamespacedName,
+) (componentCondition, error) {
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, key, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return componentCondition{
+				Condition: conditionFromBool(conditionType, false, reasonNotFound,
+					fmt.Sprintf("Deployment %s has not been created", key.Name)),
+			}, nil
+		}
+		return componentCondition{}, fmt.Errorf("failed to get deployment %s: %w", key.Name, err)
+	}
+
+	ready, reason, message := deploymentRollout(deployment)
+	return componentCondition{
+		Condition: conditionFromBool(conditionType, ready, reason, message),
+		exists:    true,
+	}, nil
+}
+
+// deploymentRollout tells whether every replica of a Deployment runs its
+// current spec and is available, like kubectl rollout status, so that a
+// component is not reported ready while its old pods still serve
+func deploymentRollout(deployment *appsv1.Deployment) (bool, string, string) {
+	name, status := deployment.Name, deployment.Status
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	for _, c := range status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == reasonProgressDeadlineExceeded {
+			return false, reasonProgressDeadlineExceeded, c.Message
+		}
+	}
+
+	switch {
+	case replicas == 0:
+		return false, reasonUnavailable, fmt.Sprintf("Deployment %s is scaled to zero", name)
+	case status.ObservedGeneration < deployment.Generation:
+		return false, reasonRollingOut, fmt.Sprintf("Deployment %s has not observed its current spec yet", name)
+	case status.UpdatedReplicas < replicas:
+		return false, reasonRollingOut,
+			fmt.Sprintf("Deployment %s has %d of %d replica(s) updated", name, status.UpdatedReplicas, replicas)
+	case status.Replicas > status.UpdatedReplicas:
+		return false, reasonRollingOut,
+			fmt.Sprintf("Deployment %s has %d old replica(s) pending termination", name, status.Replicas-status.UpdatedReplicas)
+	case status.AvailableReplicas < replicas:
+		message := fmt.Sprintf("Deployment %s has %d of %d replica(s) available", name, status.AvailableReplicas, replicas)
+		for _, c := range status.Conditions {
+			if c.Type == appsv1.DeploymentAvailable && c.Status != corev1.ConditionTrue && c.Message != "" {
+				message = c.Message
+			}
+		}
+		return false, reasonUnavailable, message
+	}
+	return true, reasonAvailable, fmt.Sprintf("Deployment %s has %d available replica(s)", name, status.AvailableReplicas)
+}
+
+// statefulSetCondition reports a StatefulSet as ready once its current spec has rolled out
+func (r *DeveloperEnvironmentReconciler) statefulSetCondition(
+	ctx context.Context,
+	conditionType string,
+	key types.NamespacedName,
+) (componentCondition, error) {
+	statefulSet := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, key, statefulSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return componentCondition{
+				Condition: conditionFromBool(conditionType, false, reasonNotFound,
+					fmt.Sprintf("StatefulSet %s has not been created", key.Name)),
+			}, nil
+		}
+		return componentCondition{}, fmt.Errorf("failed to get statefulset %s: %w", key.Name, err)
+	}
+
+	ready, reason, message := statefulSetRollout(statefulSet)
+	return componentCondition{
+		Condition: conditionFromBool(conditionType, ready, reason, message),
+		exists:    true,
+	}, nil
+}
+
+// statefulSetRollout tells whether every replica of a StatefulSet runs its current spec and is available
+func statefulSetRollout(statefulSet *appsv1.StatefulSet) (bool, string, string) {
+	name, status := statefulSet.Name, statefulSet.Status
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+
+	switch {
+	case replicas == 0:
+		return false, reasonUnavailable, fmt.Sprintf("StatefulSet %s is scaled to zero", name)
+	case status.ObservedGeneration < statefulSet.Generation:
+		return false, reasonRollingOut, fmt.Sprintf("StatefulSet %s has not observed its current spec yet", name)
+	case status.UpdatedReplicas < replicas || status.CurrentRevision != status.UpdateRevision:
+		return false, reasonRollingOut,
+			fmt.Sprintf("StatefulSet %s has %d of %d replica(s) updated", name, status.UpdatedReplicas, replicas)
+	case status.AvailableReplicas < replicas:
+		return false, reasonUnavailable,
+			fmt.Sprintf("StatefulSet %s has %d of %d replica(s) available", name, status.AvailableReplicas, replicas)
+	}
+	return true, reasonAvailable, fmt.Sprintf("StatefulSet %s has %d available replica(s)", name, status.AvailableReplicas)
+}
+
+// ingressCondition reports the Ingress as ready once it exists, noting the load balancer address if assigned
+func (r *DeveloperEnvironmentReconciler) ingressCondition(
+	ctx context.Context,
+	key types.NamespacedName,
+) (componentCondition, error) {
+	ingress := &networkingv1.Ingress{}
+	if err := r.Get(ctx, key, ingress); err != nil {
+		if apierrors.IsNotFound(err) {
+			return componentCondition{
+				Condition: conditionFromBool(apiv1.ConditionIngressReady, false, reasonNotFound,
+					fmt.Sprintf("Ingress %s has not been created", key.Name)),
+			}, nil
+		}
+		return componentCondition{}, fmt.Errorf("failed to get ingress %s: %w", key.Name, err)
+	}
+
+	message := fmt.Sprintf("Ingress %s created", key.Name)
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		if lb.IP != "" {
+			message = fmt.Sprintf("Ingress %s is served at %s", key.Name, lb.IP)
+		} else if lb.Hostname != "" {
+			message = fmt.Sprintf("Ingress %s is served at %s", key.Name, lb.Hostname)
+		}
+	}
+	return componentCondition{
+		Condition: conditionFromBool(apiv1.ConditionIngressReady, true, reasonIngressCreated, message),
+		exists:    true,
+	}, nil
+}
+
+// componentConditions inspects every child component of the environment
+func (r *DeveloperEnvironmentReconciler) componentConditions(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) ([]componentCondition, error) {
+	var conditions []componentCondition
+
+	ide, err := r.deploymentCondition(ctx, apiv1.ConditionIDEReady, types.NamespacedName{
+		Name:      fmt.Sprintf("%s-vscode-server", devEnv.Name),
+		Namespace: environmentNamespace(devEnv),
+	})
+	if err != nil {
+		return nil, err
+	}
+	conditions = append(conditions, ide)
+
+	services, err := r.servicesCondition(ctx, devEnv)
+	if err != nil {
+		return nil, err
+	}
+	if services != nil {
+		conditions = append(conditions, *services)
+	}
+
+	certificate, err := r.certificateCondition(ctx, devEnv)
+	if err != nil {
+		return nil, err
+	}
+	if certificate != nil {
+		conditions = append(conditions, *certificate)
+	}
+
+	ingress, err := r.ingressCondition(ctx, types.NamespacedName{
+		Name:      fmt.Sprintf("%s-vscode-ingress", devEnv.Name),
+		Namespace: environmentNamespace(devEnv),
+	})
+	if err != nil {
+		return nil, err
+	}
+	conditions = append(conditions, ingress)
+
+	return conditions, nil
+}
+
+// computePhase derives the environment phase from the reconcile result and its component conditions
+func computePhase(devEnv *apiv1.DeveloperEnvironment, components []componentCondition, reconcileErr error) string {
+	if devEnv.DeletionTimestamp != nil {
+		return apiv1.PhaseTerminating
+	}
+
+	wasReady := devEnv.Status.Phase == apiv1.PhaseReady || devEnv.Status.Phase == apiv1.PhaseDegraded
+	if reconcileErr != nil {
+		if wasReady {
+			return apiv1.PhaseDegraded
+		}
+		return apiv1.PhaseFailed
+	}
+	if devEnv.Status.Suspended {
+		return apiv1.PhaseSuspended
+	}
+
+	allReady, anyExists := true, false
+	for _, c := range components {
+		if c.Status != string(metav1.ConditionTrue) {
+			allReady = false
+		}
+		if c.exists {
+			anyExists = true
+		}
+	}
+
+	switch {
+	case allReady:
+		return apiv1.PhaseReady
+	case !anyExists:
+		return apiv1.PhasePending
+	case wasReady:
+		return apiv1.PhaseDegraded
+	default:
+		return apiv1.PhaseProvisioning
+	}
+}
+
+// provisioningTimeout is how long the environment may take to become ready
+func (r *DeveloperEnvironmentReconciler) provisioningTimeout(devEnv *apiv1.DeveloperEnvironment) time.Duration {
+	if devEnv.Spec.ProvisioningTimeout != nil {
+		return devEnv.Spec.ProvisioningTimeout.Duration
+	}
+	return r.Defaults.ProvisioningTimeout
+}
+
+// provisioningPhase fails an environment that has been provisioning its
+// current spec for longer than the timeout, and records the outcome in the
+// Provisioned condition. Provisioning starts again when the spec changes, so
+// fixing a failed environment gives it another try.
+func provisioningPhase(
+	devEnv *apiv1.DeveloperEnvironment,
+	components []componentCondition,
+	phase string,
+	timeout time.Duration,
+	now metav1.Time,
+) string {
+	if devEnv.Status.ObservedGeneration != devEnv.Generation {
+		devEnv.Status.ProvisioningStartTime = nil
+	}
+	switch phase {
+	case apiv1.PhasePending, apiv1.PhaseProvisioning, apiv1.PhaseFailed:
+	default:
+		devEnv.Status.ProvisioningStartTime = nil
+		if phase == apiv1.PhaseReady {
+			setCondition(devEnv, conditionFromBool(apiv1.ConditionProvisioned, true, reasonReady, "Every component is ready"))
+		}
+		return phase
+	}
+
+	if devEnv.Status.ProvisioningStartTime == nil {
+		devEnv.Status.ProvisioningStartTime = &now
+	}
+	if timeout <= 0 || now.Sub(devEnv.Status.ProvisioningStartTime.Time) < timeout {
+		message := "Waiting for every component to become ready"
+		if timeout > 0 {
+			message = fmt.Sprintf("%s, for up to %s", message, timeout)
+		}
+		setCondition(devEnv, conditionFromBool(apiv1.ConditionProvisioned, false, reasonProvisioning, message))
+		return phase
+	}
+
+	var waiting []string
+	for _, c := range components {
+		if c.Status != string(metav1.ConditionTrue) {
+			waiting = append(waiting, c.Type)
+		}
+	}
+	message := fmt.Sprintf("Not ready after %s", timeout)
+	if len(waiting) > 0 {
+		message = fmt.Sprintf("%s, waiting for %s", message, strings.Join(waiting, ", "))
+	}
+	setCondition(devEnv, conditionFromBool(apiv1.ConditionProvisioned, false, reasonProvisioningTimeout, message))
+	return apiv1.PhaseFailed
+}
+
+// provisioningRequeue returns when to look at a provisioning environment
+// again for its timeout, which no change to its objects may trigger
+func (r *DeveloperEnvironmentReconciler) provisioningRequeue(devEnv *apiv1.DeveloperEnvironment) time.Duration {
+	start, timeout := devEnv.Status.ProvisioningStartTime, r.provisioningTimeout(devEnv)
+	if start == nil || timeout <= 0 || devEnv.Status.Phase == apiv1.PhaseFailed {
+		return 0
+	}
+	return max(time.Until(start.Add(timeout)), 0) + time.Second
+}
+
+// Update status of the DevEnv resource. The status is only written when it
+// differs from observed, the status the reconcile started from, since every
+// write triggers another reconcile.
+func (r *DeveloperEnvironmentReconciler) updateStatus(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	observed *apiv1.DeveloperEnvironmentStatus,
+	reconcileErr error,
+) error {
+	components, err := r.componentConditions(ctx, devEnv)
+	if err != nil {
+		return err
+	}
+	for _, c := range components {
+		setCondition(devEnv, c.Condition)
+		if c.Type == apiv1.ConditionIngressReady && c.exists {
+			devEnv.Status.AccessURL = r.accessURL(devEnv)
+		}
+	}
+
+	if len(devEnv.Spec.EffectiveServices()) == 0 {
+		removeCondition(devEnv, apiv1.ConditionServicesReady)
+	}
+	if devEnv.Status.Certificate == nil {
+		removeCondition(devEnv, apiv1.ConditionCertificateReady)
+	}
+
+	quota, err := r.quotaCondition(ctx, devEnv)
+	if err != nil {
+		return err
+	}
+	setCondition(devEnv, quota)
+
+	storage, err := r.storageCondition(ctx, devEnv)
+	if err != nil {
+		return err
+	}
+	setCondition(devEnv, storage)
+
+	dependencies, err := r.dependencyStatuses(ctx, devEnv)
+	if err != nil {
+		return err
+	}
+	devEnv.Status.Dependencies = dependencies
+	if len(dependencies) > 0 {
+		setCondition(devEnv, dependencyCondition(dependencies))
+	}
+
+	if seeded, ok := seedCondition(devEnv.Status.Services); ok {
+		setCondition(devEnv, seeded)
+	} else {
+		removeCondition(devEnv, apiv1.ConditionDatabasesSeeded)
+	}
+
+	if restored, ok := restoreCondition(devEnv.Status.Restore); ok {
+		setCondition(devEnv, restored)
+	} else {
+		removeCondition(devEnv, apiv1.ConditionRestored)
+	}
+
+	results, err := r.initContainerResults(ctx, devEnv, cloneContainerName)
+	if err != nil {
+		return err
+	}
+	devEnv.Status.Repositories = repositoryStatuses(devEnv.Spec.Repositories, results)
+	if len(devEnv.Status.Repositories) > 0 {
+		setCondition(devEnv, repositoryCondition(devEnv.Status.Repositories))
+	}
+
+	if results, err = r.initContainerResults(ctx, devEnv, extensionsContainerName); err != nil {
+		return err
+	}
+	devEnv.Status.Extensions = extensionStatuses(devEnv.Spec.IDE.Extensions, results)
+	if len(devEnv.Status.Extensions) > 0 {
+		setCondition(devEnv, extensionCondition(devEnv.Status.Extensions))
+	} else {
+		removeCondition(devEnv, apiv1.ConditionExtensionsInstalled)
+	}
+
+	if reconcileErr != nil {
+		setCondition(devEnv, conditionFromBool(apiv1.ConditionReconciled, false, reasonReconcileFailed, reconcileErr.Error()))
+	} else {
+		setCondition(devEnv, conditionFromBool(apiv1.ConditionReconciled, true, reasonReconcileSucceeded, ""))
+	}
+
+	phase := computePhase(devEnv, components, reconcileErr)
+	devEnv.Status.Phase = provisioningPhase(devEnv, components, phase, r.provisioningTimeout(devEnv), metav1.Now())
+	devEnv.Status.ObservedGeneration = devEnv.Generation
+
+	devEnv.Status.LastUpdated = observed.LastUpdated
+	if equality.Semantic.DeepEqual(&devEnv.Status, observed) {
+		return nil
+	}
+	devEnv.Status.LastUpdated = metav1.Now()
+	return r.Status().Update(ctx, devEnv)
+}
+
+// setPhase records a phase transition that is not derived from the component state
+func (r *DeveloperEnvironmentReconciler) setPhase(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	phase string,
+) error {
+	if devEnv.Status.Phase == phase {
+		return nil
+	}
+	devEnv.Status.Phase = phase
+	devEnv.Status.LastUpdated = metav1.Now()
+	return r.Status().Update(ctx, devEnv)
+}
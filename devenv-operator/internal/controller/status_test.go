@@ -0,0 +1,321 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func component(ready, exists bool) componentCondition {
+	return componentCondition{
+		Condition: conditionFromBool("Test", ready, "", ""),
+		exists:    exists,
+	}
+}
+
+func TestComputePhase(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name         string
+		deleting     bool
+		currentPhase string
+		suspended    bool
+		components   []componentCondition
+		reconcileErr error
+		want         string
+	}{
+		{
+			name:       "nothing created yet",
+			components: []componentCondition{component(false, false), component(false, false)},
+			want:       apiv1.PhasePending,
+		},
+		{
+			name:         "partially created",
+			currentPhase: apiv1.PhasePending,
+			components:   []componentCondition{component(true, true), component(false, true)},
+			want:         apiv1.PhaseProvisioning,
+		},
+		{
+			name:         "all components ready",
+			currentPhase: apiv1.PhaseProvisioning,
+			components:   []componentCondition{component(true, true), component(true, true)},
+			want:         apiv1.PhaseReady,
+		},
+		{
+			name:         "component lost after being ready",
+			currentPhase: apiv1.PhaseReady,
+			components:   []componentCondition{component(true, true), component(false, true)},
+			want:         apiv1.PhaseDegraded,
+		},
+		{
+			name:         "reconcile error before ever being ready",
+			currentPhase: apiv1.PhaseProvisioning,
+			components:   []componentCondition{component(true, true)},
+			reconcileErr: errors.New("boom"),
+			want:         apiv1.PhaseFailed,
+		},
+		{
+			name:         "reconcile error after being ready",
+			currentPhase: apiv1.PhaseReady,
+			components:   []componentCondition{component(true, true)},
+			reconcileErr: errors.New("boom"),
+			want:         apiv1.PhaseDegraded,
+		},
+		{
+			name:         "suspended",
+			currentPhase: apiv1.PhaseReady,
+			suspended:    true,
+			components:   []componentCondition{component(false, true)},
+			want:         apiv1.PhaseSuspended,
+		},
+		{
+			name:         "being deleted",
+			deleting:     true,
+			currentPhase: apiv1.PhaseReady,
+			components:   []componentCondition{component(true, true)},
+			want:         apiv1.PhaseTerminating,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := &apiv1.DeveloperEnvironment{}
+			devEnv.Status.Phase = tt.currentPhase
+			devEnv.Status.Suspended = tt.suspended
+			if tt.deleting {
+				devEnv.DeletionTimestamp = &now
+			}
+			if got := computePhase(devEnv, tt.components, tt.reconcileErr); got != tt.want {
+				t.Errorf("computePhase() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetConditionKeepsTransitionTime(t *testing.T) {
+	devEnv := &apiv1.DeveloperEnvironment{}
+	devEnv.Generation = 1
+	setCondition(devEnv, conditionFromBool(apiv1.ConditionIDEReady, false, reasonNotFound, ""))
+
+	past := metav1.NewTime(time.Now().Add(-time.Hour))
+	devEnv.Status.Conditions[0].LastTransitionTime = past
+
+	devEnv.Generation = 2
+	setCondition(devEnv, conditionFromBool(apiv1.ConditionIDEReady, false, reasonUnavailable, "scaling up"))
+	if len(devEnv.Status.Conditions) != 1 {
+		t.Fatalf("expected a single condition, got %d", len(devEnv.Status.Conditions))
+	}
+	cond := devEnv.Status.Conditions[0]
+	if !cond.LastTransitionTime.Equal(&past) {
+		t.Errorf("LastTransitionTime changed without a status change")
+	}
+	if cond.ObservedGeneration != 2 || cond.Reason != reasonUnavailable {
+		t.Errorf("condition not updated: %+v", cond)
+	}
+
+	setCondition(devEnv, conditionFromBool(apiv1.ConditionIDEReady, true, reasonAvailable, ""))
+	if devEnv.Status.Conditions[0].LastTransitionTime.Equal(&past) {
+		t.Errorf("LastTransitionTime not bumped on status change")
+	}
+}
+
+func TestDeploymentRollout(t *testing.T) {
+	deployment := func(generation int64, status appsv1.DeploymentStatus) *appsv1.Deployment {
+		d := &appsv1.Deployment{}
+		d.Name, d.Generation = "golang-env-vscode-server", generation
+		d.Spec.Replicas = Ptr(int32(1))
+		d.Status = status
+		return d
+	}
+
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		want       bool
+		wantReason string
+	}{
+		{
+			name:       "rolled out",
+			deployment: deployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}),
+			want:       true,
+			wantReason: reasonAvailable,
+		},
+		{
+			name:       "new spec not observed yet",
+			deployment: deployment(3, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}),
+			wantReason: reasonRollingOut,
+		},
+		{
+			name:       "old pod still serving",
+			deployment: deployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 1}),
+			wantReason: reasonRollingOut,
+		},
+		{
+			name:       "updated pod not available",
+			deployment: deployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1}),
+			wantReason: reasonUnavailable,
+		},
+		{
+			name: "rollout gave up",
+			deployment: deployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1,
+				Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse,
+					Reason: reasonProgressDeadlineExceeded}}}),
+			wantReason: reasonProgressDeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason, _ := deploymentRollout(tt.deployment)
+			if got != tt.want || reason != tt.wantReason {
+				t.Errorf("deploymentRollout() = %v, %q, want %v, %q", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestProvisioningPhase(t *testing.T) {
+	now := metav1.Now()
+	started := func(ago time.Duration) *metav1.Time {
+		return &metav1.Time{Time: now.Add(-ago)}
+	}
+
+	tests := []struct {
+		name       string
+		phase      string
+		started    *metav1.Time
+		specChange bool
+		want       string
+		wantReason string
+		wantStart  bool
+	}{
+		{
+			name:       "starts provisioning",
+			phase:      apiv1.PhasePending,
+			want:       apiv1.PhasePending,
+			wantReason: reasonProvisioning,
+			wantStart:  true,
+		},
+		{
+			name:       "within the timeout",
+			phase:      apiv1.PhaseProvisioning,
+			started:    started(10 * time.Minute),
+			want:       apiv1.PhaseProvisioning,
+			wantReason: reasonProvisioning,
+			wantStart:  true,
+		},
+		{
+			name:       "timed out",
+			phase:      apiv1.PhaseProvisioning,
+			started:    started(time.Hour),
+			want:       apiv1.PhaseFailed,
+			wantReason: reasonProvisioningTimeout,
+			wantStart:  true,
+		},
+		{
+			name:       "spec changed after timing out",
+			phase:      apiv1.PhaseProvisioning,
+			started:    started(time.Hour),
+			specChange: true,
+			want:       apiv1.PhaseProvisioning,
+			wantReason: reasonProvisioning,
+			wantStart:  true,
+		},
+		{
+			name:       "ready after timing out",
+			phase:      apiv1.PhaseReady,
+			started:    started(time.Hour),
+			want:       apiv1.PhaseReady,
+			wantReason: reasonReady,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := &apiv1.DeveloperEnvironment{}
+			devEnv.Generation, devEnv.Status.ObservedGeneration = 1, 1
+			if tt.specChange {
+				devEnv.Generation = 2
+			}
+			devEnv.Status.ProvisioningStartTime = tt.started
+			components := []componentCondition{component(true, true), component(false, true)}
+
+			if got := provisioningPhase(devEnv, components, tt.phase, 30*time.Minute, now); got != tt.want {
+				t.Errorf("provisioningPhase() = %q, want %q", got, tt.want)
+			}
+			if got := devEnv.Status.Conditions[0].Reason; got != tt.wantReason {
+				t.Errorf("Provisioned reason = %q, want %q", got, tt.wantReason)
+			}
+			if (devEnv.Status.ProvisioningStartTime != nil) != tt.wantStart {
+				t.Errorf("ProvisioningStartTime = %v, want set %v", devEnv.Status.ProvisioningStartTime, tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusSkipsUnchanged(t *testing.T) {
+	ctx := context.Background()
+	devEnv := &apiv1.DeveloperEnvironment{
+		ObjectMeta: metav1.ObjectMeta{Name: "golang-env", Namespace: "team", Generation: 1},
+		Status:     apiv1.DeveloperEnvironmentStatus{Phase: apiv1.PhasePending, Namespace: "team"},
+	}
+	r := fakeReconciler(t, devEnv)
+
+	// The first pass records the state of the components
+	observed := devEnv.Status.DeepCopy()
+	if err := r.updateStatus(ctx, devEnv, observed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(devEnv), devEnv); err != nil {
+		t.Fatal(err)
+	}
+	written := devEnv.ResourceVersion
+
+	// Nothing changed since, so writing the status would only trigger another reconcile
+	observed = devEnv.Status.DeepCopy()
+	if err := r.updateStatus(ctx, devEnv, observed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(devEnv), devEnv); err != nil {
+		t.Fatal(err)
+	}
+	if devEnv.ResourceVersion != written {
+		t.Errorf("updateStatus() wrote an unchanged status")
+	}
+
+	// A failed reconcile is recorded
+	observed = devEnv.Status.DeepCopy()
+	if err := r.updateStatus(ctx, devEnv, observed, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(devEnv), devEnv); err != nil {
+		t.Fatal(err)
+	}
+	if devEnv.ResourceVersion == written {
+		t.Errorf("updateStatus() did not write a changed status")
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// annotationResumeAt asks for an environment suspended for being idle to
+// resume, when set to a time after the suspension
+const annotationResumeAt = "devenv.adityajoshi.online/resume-at"
+
+// activityPollInterval is how often the IDE of an environment with an idle timeout is checked for activity
+const activityPollInterval = 5 * time.Minute
+
+// Suspension reasons
+const (
+	reasonActive              = "Active"
+	reasonSuspendedManually   = "SuspendedManually"
+	reasonOutsideWorkingHours = "OutsideWorkingHours"
+	reasonIdle                = "Idle"
+)
+
+// activityClient queries code-server for the time of its last user activity
+var activityClient = &http.Client{Timeout: 5 * time.Second}
+
+// scheduleState is where a point in time falls within an environment schedule
+type scheduleState struct {
+	Working     bool
+	LastWake    time.Time
+	NextWake    time.Time
+	NextSuspend time.Time
+}
+
+// evaluateSchedule works out whether now falls within the working hours of the
+// schedule, that is whether the wake expression fired more recently than the
+// suspend expression. An environment without a schedule is always working.
+func evaluateSchedule(schedule *apiv1.ScheduleSpec, now time.Time) (scheduleState, error) {
+	if schedule == nil {
+		return scheduleState{Working: true}, nil
+	}
+
+	location := time.UTC
+	if schedule.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+			return scheduleState{}, fmt.Errorf("invalid schedule time zone %q: %w", schedule.TimeZone, err)
+		}
+	}
+	wake, err := cron.ParseStandard(schedule.Wake)
+	if err != nil {
+		return scheduleState{}, fmt.Errorf("invalid wake schedule %q: %w", schedule.Wake, err)
+	}
+	suspend, err := cron.ParseStandard(schedule.Suspend)
+	if err != nil {
+		return scheduleState{}, fmt.Errorf("invalid suspend schedule %q: %w", schedule.Suspend, err)
+	}
+
+	now = now.In(location)
+	state := scheduleState{
+		LastWake:    previousActivation(wake, now),
+		NextWake:    wake.Next(now),
+		NextSuspend: suspend.Next(now),
+	}
+	state.Working = !state.LastWake.Before(previousActivation(suspend, now))
+	return state, nil
+}
+
+// previousActivation returns the last time the schedule fired at or before now,
+// looking back up to two years.
+func previousActivation(schedule cron.Schedule, now time.Time) time.Time {
+	for lookback := 24 * time.Hour; lookback <= 2*366*24*time.Hour; lookback *= 2 {
+		var last time.Time
+		for t := schedule.Next(now.Add(-lookback)); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+			last = t
+		}
+		if !last.IsZero() {
+			return last
+		}
+	}
+	return time.Time{}
+}
+
+// lastIDEActivity asks the IDE when it last saw a user. IDEs that do not
+// report it are never seen active.
+func (r *DeveloperEnvironmentReconciler) lastIDEActivity(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (time.Time, error) {
+	kind, err := ideKind(devEnv)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if kind.Activity == nil {
+		return time.Time{}, fmt.Errorf("the %s IDE does not report activity", devEnv.Spec.IDE.Type)
+	}
+	url := fmt.Sprintf("http://%s-vscode-server.%s.svc:%d%s", devEnv.Name, environmentNamespace(devEnv), kind.port, kind.ActivityPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp, err := activityClient.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("IDE activity check returned %s", resp.Status)
+	}
+	return kind.Activity(resp.Body)
+}
+
+// reconcileSuspension decides whether the environment should be running,
+// recording the outcome in the status for the IDE and database to be scaled
+// accordingly. Idle environments stay suspended, as recorded in
+// status.idleSuspendedAt, until the user asks for them to resume or the next
+// working hours wake them. It returns when the decision has to be revisited.
+func (r *DeveloperEnvironmentReconciler) reconcileSuspension(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (time.Duration, error) {
+	logger := log.FromContext(ctx)
+	now := time.Now()
+
+	state, err := evaluateSchedule(devEnv.Spec.Schedule, now)
+	if err != nil {
+		return 0, err
+	}
+
+	// Forget an idle suspension once the user suspends the environment or asks
+	// for it to resume, and once the next working hours have begun
+	if idle := devEnv.Status.IdleSuspendedAt; idle != nil {
+		resumeAt, err := time.Parse(time.RFC3339, devEnv.Annotations[annotationResumeAt])
+		if devEnv.Spec.Suspended ||
+			(err == nil && resumeAt.After(idle.Time)) ||
+			(devEnv.Spec.Schedule != nil && state.Working && state.LastWake.After(idle.Time)) {
+			devEnv.Status.IdleSuspendedAt = nil
+		}
+	}
+
+	suspended, reason, message := true, "", ""
+	switch {
+	case devEnv.Spec.Suspended:
+		reason = reasonSuspendedManually
+	case devEnv.Status.IdleSuspendedAt != nil:
+		reason, message = reasonIdle, fmt.Sprintf("Suspended for inactivity at %s",
+			devEnv.Status.IdleSuspendedAt.UTC().Format(time.RFC3339))
+	case !state.Working:
+		reason = reasonOutsideWorkingHours
+	default:
+		suspended, reason = false, reasonActive
+	}
+
+	var requeue time.Duration
+	requeueBy := func(at time.Time) {
+		if d := at.Sub(now); !at.IsZero() && d > 0 && (requeue == 0 || d < requeue) {
+			requeue = d
+		}
+	}
+	if devEnv.Spec.Schedule != nil {
+		if state.Working {
+			requeueBy(state.NextSuspend)
+		} else {
+			requeueBy(state.NextWake)
+		}
+	}
+
+	if !suspended && devEnv.Spec.IdleTimeout != nil {
+		if devEnv.Status.Suspended || devEnv.Status.LastActivityTime == nil {
+			// Waking up counts as activity, or the environment would be suspended again right away
+			devEnv.Status.LastActivityTime = &metav1.Time{Time: now}
+		} else if last, err := r.lastIDEActivity(ctx, devEnv); err != nil {
+			logger.V(1).Info("Could not query IDE activity", "error", err.Error())
+		} else if last.After(devEnv.Status.LastActivityTime.Time) {
+			devEnv.Status.LastActivityTime = &metav1.Time{Time: last}
+		}
+
+		idleFor := now.Sub(devEnv.Status.LastActivityTime.Time)
+		if idleFor >= devEnv.Spec.IdleTimeout.Duration {
+			devEnv.Status.IdleSuspendedAt = &metav1.Time{Time: now}
+			logger.Info("Suspending idle environment", "idleFor", idleFor.Round(time.Second).String())
+			suspended, reason = true, reasonIdle
+			message = fmt.Sprintf("No IDE activity for %s", devEnv.Spec.IdleTimeout.Duration)
+		} else {
+			requeueBy(now.Add(min(devEnv.Spec.IdleTimeout.Duration-idleFor, activityPollInterval)))
+		}
+	}
+
+	devEnv.Status.Suspended = suspended
+	devEnv.Status.NextWakeTime = nil
+	if suspended && reason != reasonSuspendedManually && devEnv.Spec.Schedule != nil && !state.NextWake.IsZero() {
+		devEnv.Status.NextWakeTime = &metav1.Time{Time: state.NextWake}
+		if message != "" {
+			message += "; "
+		}
+		message += fmt.Sprintf("waking at %s", state.NextWake.Format(time.RFC3339))
+	}
+	setCondition(devEnv, conditionFromBool(apiv1.ConditionSuspended, suspended, reason, message))
+
+	return requeue, nil
+}
+
+// patchEnvironment applies mutate to the environment's metadata or spec,
+// keeping the status computed so far in this reconcile.
+func (r *DeveloperEnvironmentReconciler) patchEnvironment(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+	mutate func(),
+) error {
+	status := devEnv.Status.DeepCopy()
+	patch := client.MergeFrom(devEnv.DeepCopy())
+	mutate()
+	if err := r.Patch(ctx, devEnv, patch); err != nil {
+		return err
+	}
+	devEnv.Status = *status
+	return nil
+}
+
+// desiredReplicas is the replica count of the environment's Deployments
+func desiredReplicas(devEnv *apiv1.DeveloperEnvironment) *int32 {
+	if devEnv.Status.Suspended {
+		return Ptr(int32(0))
+	}
+	return Ptr(int32(1))
+}
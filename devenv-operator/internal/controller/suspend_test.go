@@ -0,0 +1,151 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func TestEvaluateSchedule(t *testing.T) {
+	workingHours := &apiv1.ScheduleSpec{
+		Wake:     "0 8 * * 1-5",
+		Suspend:  "0 19 * * 1-5",
+		TimeZone: "Europe/Berlin",
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		schedule    *apiv1.ScheduleSpec
+		now         time.Time
+		wantWorking bool
+		wantWake    time.Time
+		wantErr     bool
+	}{
+		{
+			name:        "no schedule",
+			now:         time.Date(2024, 6, 12, 3, 0, 0, 0, berlin),
+			wantWorking: true,
+		},
+		{
+			name:        "during working hours",
+			schedule:    workingHours,
+			now:         time.Date(2024, 6, 12, 10, 0, 0, 0, berlin),
+			wantWorking: true,
+			wantWake:    time.Date(2024, 6, 13, 8, 0, 0, 0, berlin),
+		},
+		{
+			name:        "overnight",
+			schedule:    workingHours,
+			now:         time.Date(2024, 6, 12, 22, 0, 0, 0, berlin),
+			wantWorking: false,
+			wantWake:    time.Date(2024, 6, 13, 8, 0, 0, 0, berlin),
+		},
+		{
+			name:        "weekend",
+			schedule:    workingHours,
+			now:         time.Date(2024, 6, 15, 12, 0, 0, 0, berlin),
+			wantWorking: false,
+			wantWake:    time.Date(2024, 6, 17, 8, 0, 0, 0, berlin),
+		},
+		{
+			name:        "time zone is honoured",
+			schedule:    workingHours,
+			now:         time.Date(2024, 6, 12, 6, 30, 0, 0, time.UTC),
+			wantWorking: true,
+			wantWake:    time.Date(2024, 6, 13, 8, 0, 0, 0, berlin),
+		},
+		{
+			name:     "invalid expression",
+			schedule: &apiv1.ScheduleSpec{Wake: "every morning", Suspend: "0 19 * * *"},
+			now:      time.Date(2024, 6, 12, 10, 0, 0, 0, time.UTC),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := evaluateSchedule(tt.schedule, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evaluateSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if state.Working != tt.wantWorking {
+				t.Errorf("Working = %v, want %v", state.Working, tt.wantWorking)
+			}
+			if !state.NextWake.Equal(tt.wantWake) {
+				t.Errorf("NextWake = %s, want %s", state.NextWake, tt.wantWake)
+			}
+		})
+	}
+}
+
+func TestIdleSuspension(t *testing.T) {
+	ctx := context.Background()
+	r := &DeveloperEnvironmentReconciler{}
+	devEnv := &apiv1.DeveloperEnvironment{
+		ObjectMeta: metav1.ObjectMeta{Name: "notebook", Namespace: "team"},
+		Spec: apiv1.DeveloperEnvironmentSpec{
+			// JupyterLab reports no activity, so the last activity stays as it is
+			IDE:         apiv1.IDEConfig{Type: apiv1.IDETypeJupyterLab},
+			IdleTimeout: &metav1.Duration{Duration: time.Hour},
+		},
+		Status: apiv1.DeveloperEnvironmentStatus{
+			LastActivityTime: &metav1.Time{Time: time.Now().Add(-2 * time.Hour)},
+		},
+	}
+
+	if _, err := r.reconcileSuspension(ctx, devEnv); err != nil {
+		t.Fatal(err)
+	}
+	if !devEnv.Status.Suspended || devEnv.Status.IdleSuspendedAt == nil {
+		t.Fatalf("idle environment not suspended: suspended %v, idleSuspendedAt %v",
+			devEnv.Status.Suspended, devEnv.Status.IdleSuspendedAt)
+	}
+	if devEnv.Spec.Suspended {
+		t.Errorf("idle suspension changed spec.suspended")
+	}
+
+	// Stays suspended until the user asks for it to resume
+	if _, err := r.reconcileSuspension(ctx, devEnv); err != nil {
+		t.Fatal(err)
+	}
+	if !devEnv.Status.Suspended {
+		t.Fatalf("idle environment resumed by itself")
+	}
+	devEnv.Annotations = map[string]string{
+		annotationResumeAt: devEnv.Status.IdleSuspendedAt.Add(time.Minute).Format(time.RFC3339),
+	}
+	if _, err := r.reconcileSuspension(ctx, devEnv); err != nil {
+		t.Fatal(err)
+	}
+	if devEnv.Status.Suspended || devEnv.Status.IdleSuspendedAt != nil {
+		t.Errorf("environment not resumed: suspended %v, idleSuspendedAt %v",
+			devEnv.Status.Suspended, devEnv.Status.IdleSuspendedAt)
+	}
+}
@@ -0,0 +1,354 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+// Condition reasons of the Secret mode
+const (
+	reasonCertificateInvalid = "Invalid"
+	reasonCertificateExpired = "Expired"
+)
+
+// tlsSpec is the TLS configuration of an environment, falling back to the
+// operator-wide one
+func (r *DeveloperEnvironmentReconciler) tlsSpec(devEnv *apiv1.DeveloperEnvironment) apiv1.TLSSpec {
+	if devEnv.Spec.TLS != nil {
+		return *devEnv.Spec.TLS
+	}
+	if r.DefaultTLS.Mode == "" {
+		return apiv1.TLSSpec{Mode: apiv1.TLSModeSelfSigned}
+	}
+	return r.DefaultTLS
+}
+
+// tlsSourceSecret is the Secret of the Secret mode, which is next to the
+// environment when it sets one and in the operator namespace otherwise
+func (r *DeveloperEnvironmentReconciler) tlsSourceSecret(devEnv *apiv1.DeveloperEnvironment) types.NamespacedName {
+	if devEnv.Spec.TLS != nil {
+		return types.NamespacedName{Name: devEnv.Spec.TLS.SecretName, Namespace: devEnv.Namespace}
+	}
+	return types.NamespacedName{Name: r.DefaultTLS.SecretName, Namespace: r.OperatorNamespace}
+}
+
+// tlsSecretName is the Secret the Ingress reads the certificate from, or
+// nothing when the IDE is served without TLS. The Secret of the Secret mode
+// is copied next to the IDE unless it is there already.
+func (r *DeveloperEnvironmentReconciler) tlsSecretName(devEnv *apiv1.DeveloperEnvironment) string {
+	switch r.tlsSpec(devEnv).Mode {
+	case apiv1.TLSModeNone:
+		return ""
+	case apiv1.TLSModeSecret:
+		source := r.tlsSourceSecret(devEnv)
+		if source.Namespace == environmentNamespace(devEnv) {
+			return source.Name
+		}
+		return tlsSecretCopyName(devEnv)
+	}
+	return r.ideHost(devEnv)
+}
+
+func tlsSecretCopyName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-ide-tls", devEnv.Name)
+}
+
+// selfSignedIssuerName is the per-environment Issuer signing the IDE certificate.
+// Each environment gets its own so that the Issuer can be owned by it.
+func selfSignedIssuerName(devEnv *apiv1.DeveloperEnvironment) string {
+	return fmt.Sprintf("%s-selfsigned-issuer", devEnv.Name)
+}
+
+// issuerRef is the cert-manager issuer of the IDE certificate, for the TLS
+// modes where cert-manager issues it
+func issuerRef(devEnv *apiv1.DeveloperEnvironment, tls apiv1.TLSSpec) (cmmeta.ObjectReference, bool) {
+	ref := cmmeta.ObjectReference{Group: certmanagerv1.SchemeGroupVersion.Group}
+	switch tls.Mode {
+	case apiv1.TLSModeSelfSigned:
+		ref.Name, ref.Kind = selfSignedIssuerName(devEnv), certmanagerv1.IssuerKind
+	case apiv1.TLSModeIssuer:
+		ref.Name, ref.Kind = tls.IssuerName, certmanagerv1.IssuerKind
+	case apiv1.TLSModeClusterIssuer:
+		ref.Name, ref.Kind = tls.IssuerName, certmanagerv1.ClusterIssuerKind
+	default:
+		return cmmeta.ObjectReference{}, false
+	}
+	return ref, true
+}
+
+// setupCertificates sets up the certificate of the IDE as its TLS mode asks,
+// and deletes what an earlier mode left behind
+func (r *DeveloperEnvironmentReconciler) setupCertificates(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	tls := r.tlsSpec(devEnv)
+
+	issuer := &certmanagerv1.Issuer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      selfSignedIssuerName(devEnv),
+			Namespace: environmentNamespace(devEnv),
+			Labels: map[string]string{
+				"developer-env": devEnv.Name,
+			},
+		},
+		Spec: certmanagerv1.IssuerSpec{
+			IssuerConfig: certmanagerv1.IssuerConfig{
+				SelfSigned: &certmanagerv1.SelfSignedIssuer{},
+			},
+		},
+	}
+	if tls.Mode == apiv1.TLSModeSelfSigned {
+		if err := r.apply(ctx, devEnv, issuer); err != nil {
+			return fmt.Errorf("failed to apply Issuer: %w", err)
+		}
+	} else if err := r.Delete(ctx, issuer); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Issuer: %w", err)
+	}
+
+	certificateName := r.ideHost(devEnv)
+	certificate := &certmanagerv1.Certificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      certificateName,
+			Namespace: environmentNamespace(devEnv),
+			Labels: map[string]string{
+				"developer-env": devEnv.Name,
+			},
+		},
+	}
+	if ref, ok := issuerRef(devEnv, tls); ok {
+		certificate.Spec = certmanagerv1.CertificateSpec{
+			CommonName: certificateName,
+			DNSNames:   []string{certificateName},
+			SecretName: certificateName,
+			PrivateKey: &certmanagerv1.CertificatePrivateKey{
+				Algorithm: certmanagerv1.ECDSAKeyAlgorithm,
+				Size:      256,
+			},
+			IssuerRef: ref,
+		}
+		if err := r.apply(ctx, devEnv, certificate); err != nil {
+			return fmt.Errorf("failed to apply Certificate: %w", err)
+		}
+	} else {
+		if err := r.Delete(ctx, certificate); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete Certificate: %w", err)
+		}
+		// The Secret of the Certificate carries none of our labels, but is
+		// annotated by cert-manager
+		err := r.deleteStaleSecret(ctx, client.ObjectKeyFromObject(certificate), func(secret *corev1.Secret) bool {
+			return secret.Annotations[certmanagerv1.CertificateNameKey] == certificateName
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if tls.Mode == apiv1.TLSModeSecret && r.tlsSecretName(devEnv) == tlsSecretCopyName(devEnv) {
+		return r.copyTLSSecret(ctx, devEnv)
+	}
+	key := types.NamespacedName{Name: tlsSecretCopyName(devEnv), Namespace: environmentNamespace(devEnv)}
+	return r.deleteStaleSecret(ctx, key, func(secret *corev1.Secret) bool {
+		return secret.Labels[labelEnvironment] == devEnv.Name
+	})
+}
+
+// copyTLSSecret copies the certificate of the Secret mode next to the IDE
+func (r *DeveloperEnvironmentReconciler) copyTLSSecret(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) error {
+	key := r.tlsSourceSecret(devEnv)
+	source := &corev1.Secret{}
+	if err := r.APIReader.Get(ctx, key, source); err != nil {
+		return fmt.Errorf("failed to get TLS secret %s: %w", key, err)
+	}
+	if source.Type != corev1.SecretTypeTLS {
+		return fmt.Errorf("TLS secret %s is of type %s rather than %s", key, source.Type, corev1.SecretTypeTLS)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tlsSecretCopyName(devEnv),
+			Namespace: environmentNamespace(devEnv),
+			Labels: map[string]string{
+				"app":           "vscode-server",
+				"developer-env": devEnv.Name,
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       source.Data[corev1.TLSCertKey],
+			corev1.TLSPrivateKeyKey: source.Data[corev1.TLSPrivateKeyKey],
+		},
+	}
+	if err := r.apply(ctx, devEnv, secret); err != nil {
+		return fmt.Errorf("failed to apply TLS secret: %w", err)
+	}
+	return nil
+}
+
+// deleteStaleSecret deletes a Secret an earlier TLS mode left behind, when
+// stale tells it is the one that mode created rather than one of the user
+func (r *DeveloperEnvironmentReconciler) deleteStaleSecret(
+	ctx context.Context,
+	key types.NamespacedName,
+	stale func(*corev1.Secret) bool,
+) error {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, key, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get TLS secret %s: %w", key, err)
+	}
+	if !stale(secret) {
+		return nil
+	}
+	if err := r.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete TLS secret %s: %w", key, err)
+	}
+	return nil
+}
+
+// certificateCondition records the certificate of the IDE in the status and
+// reports whether it is issued and valid. It returns nil for IDEs served
+// without TLS.
+func (r *DeveloperEnvironmentReconciler) certificateCondition(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (*componentCondition, error) {
+	tls := r.tlsSpec(devEnv)
+	if tls.Mode == apiv1.TLSModeNone {
+		devEnv.Status.Certificate = nil
+		return nil, nil
+	}
+	status := &apiv1.CertificateStatus{
+		Mode:       tls.Mode,
+		SecretName: r.tlsSecretName(devEnv),
+	}
+	devEnv.Status.Certificate = status
+
+	if tls.Mode == apiv1.TLSModeSecret {
+		key := types.NamespacedName{Name: status.SecretName, Namespace: environmentNamespace(devEnv)}
+		secret := &corev1.Secret{}
+		if err := r.getChild(ctx, key, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				return &componentCondition{
+					Condition: conditionFromBool(apiv1.ConditionCertificateReady, false, reasonNotFound,
+						fmt.Sprintf("Secret %s has not been created", key.Name)),
+				}, nil
+			}
+			return nil, fmt.Errorf("failed to get TLS secret %s: %w", key.Name, err)
+		}
+		cond, notAfter := secretCertificateCondition(secret, r.ideHost(devEnv), time.Now())
+		status.NotAfter = notAfter
+		return &componentCondition{Condition: cond, exists: true}, nil
+	}
+
+	ref, _ := issuerRef(devEnv, tls)
+	status.Issuer = fmt.Sprintf("%s/%s", ref.Kind, ref.Name)
+	key := types.NamespacedName{Name: r.ideHost(devEnv), Namespace: environmentNamespace(devEnv)}
+	certificate := &certmanagerv1.Certificate{}
+	if err := r.Get(ctx, key, certificate); err != nil {
+		if apierrors.IsNotFound(err) {
+			return &componentCondition{
+				Condition: conditionFromBool(apiv1.ConditionCertificateReady, false, reasonNotFound,
+					fmt.Sprintf("Certificate %s has not been created", key.Name)),
+			}, nil
+		}
+		return nil, fmt.Errorf("failed to get certificate %s: %w", key.Name, err)
+	}
+	status.NotAfter = certificate.Status.NotAfter
+	status.RenewalTime = certificate.Status.RenewalTime
+	return &componentCondition{Condition: certificateReadiness(certificate), exists: true}, nil
+}
+
+// certificateReadiness mirrors the Ready condition of a cert-manager
+// Certificate, explaining a certificate that is not ready with its Issuing
+// condition, such as a pending ACME challenge
+func certificateReadiness(certificate *certmanagerv1.Certificate) apiv1.Condition {
+	var ready, issuing *certmanagerv1.CertificateCondition
+	for i, c := range certificate.Status.Conditions {
+		switch c.Type {
+		case certmanagerv1.CertificateConditionReady:
+			ready = &certificate.Status.Conditions[i]
+		case certmanagerv1.CertificateConditionIssuing:
+			issuing = &certificate.Status.Conditions[i]
+		}
+	}
+	if ready == nil {
+		return apiv1.Condition{
+			Type:    apiv1.ConditionCertificateReady,
+			Status:  string(metav1.ConditionUnknown),
+			Reason:  reasonStatusUnknown,
+			Message: "cert-manager has not reported on the certificate yet",
+		}
+	}
+	if ready.Status == cmmeta.ConditionTrue {
+		return conditionFromBool(apiv1.ConditionCertificateReady, true, reasonCertificateIssued, ready.Message)
+	}
+	message := ready.Message
+	if issuing != nil && issuing.Message != "" {
+		message = fmt.Sprintf("%s: %s", message, issuing.Message)
+	}
+	return conditionFromBool(apiv1.ConditionCertificateReady, false, reasonCertificatePending, message)
+}
+
+// secretCertificateCondition reports whether the certificate of a TLS Secret
+// covers the IDE host and is valid at now, and returns when it expires
+func secretCertificateCondition(secret *corev1.Secret, host string, now time.Time) (apiv1.Condition, *metav1.Time) {
+	invalid := func(format string, args ...any) (apiv1.Condition, *metav1.Time) {
+		return conditionFromBool(apiv1.ConditionCertificateReady, false, reasonCertificateInvalid,
+			fmt.Sprintf("Secret %s: %s", secret.Name, fmt.Sprintf(format, args...))), nil
+	}
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if block == nil || block.Type != "CERTIFICATE" {
+		return invalid("%s holds no PEM certificate", corev1.TLSCertKey)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return invalid("failed to parse certificate: %v", err)
+	}
+	if len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return invalid("%s is empty", corev1.TLSPrivateKeyKey)
+	}
+	if err := cert.VerifyHostname(host); err != nil {
+		return invalid("certificate does not cover %s", host)
+	}
+
+	notAfter := metav1.NewTime(cert.NotAfter)
+	switch {
+	case now.After(cert.NotAfter):
+		return conditionFromBool(apiv1.ConditionCertificateReady, false, reasonCertificateExpired,
+			fmt.Sprintf("Certificate of Secret %s expired at %s", secret.Name, cert.NotAfter.Format(time.RFC3339))), &notAfter
+	case now.Before(cert.NotBefore):
+		return conditionFromBool(apiv1.ConditionCertificateReady, false, reasonCertificateInvalid,
+			fmt.Sprintf("Certificate of Secret %s is not valid before %s", secret.Name, cert.NotBefore.Format(time.RFC3339))), &notAfter
+	}
+	return conditionFromBool(apiv1.ConditionCertificateReady, true, reasonCertificateIssued,
+		fmt.Sprintf("Certificate of Secret %s is valid until %s", secret.Name, cert.NotAfter.Format(time.RFC3339))), &notAfter
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// wildcardCertificate returns a self-signed PEM certificate for *.example.com
+// valid from notBefore to notAfter
+func wildcardCertificate(t *testing.T, notBefore, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "*.example.com"},
+		DNSNames:     []string{"*.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestSecretCertificateCondition(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	valid := wildcardCertificate(t, now.Add(-24*time.Hour), now.Add(90*24*time.Hour))
+
+	tests := []struct {
+		name       string
+		cert       []byte
+		key        []byte
+		host       string
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{
+			name:       "wildcard covers the IDE host",
+			cert:       valid,
+			key:        []byte("key"),
+			host:       "alice.example.com",
+			wantStatus: metav1.ConditionTrue,
+			wantReason: reasonCertificateIssued,
+		},
+		{
+			name:       "wildcard does not cover another domain",
+			cert:       valid,
+			key:        []byte("key"),
+			host:       "alice.example.org",
+			wantStatus: metav1.ConditionFalse,
+			wantReason: reasonCertificateInvalid,
+		},
+		{
+			name:       "wildcard only covers one label",
+			cert:       valid,
+			key:        []byte("key"),
+			host:       "alice.dev.example.com",
+			wantStatus: metav1.ConditionFalse,
+			wantReason: reasonCertificateInvalid,
+		},
+		{
+			name:       "expired certificate",
+			cert:       wildcardCertificate(t, now.Add(-90*24*time.Hour), now.Add(-time.Hour)),
+			key:        []byte("key"),
+			host:       "alice.example.com",
+			wantStatus: metav1.ConditionFalse,
+			wantReason: reasonCertificateExpired,
+		},
+		{
+			name:       "not a certificate",
+			cert:       []byte("not a certificate"),
+			key:        []byte("key"),
+			host:       "alice.example.com",
+			wantStatus: metav1.ConditionFalse,
+			wantReason: reasonCertificateInvalid,
+		},
+		{
+			name:       "missing private key",
+			cert:       valid,
+			host:       "alice.example.com",
+			wantStatus: metav1.ConditionFalse,
+			wantReason: reasonCertificateInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "wildcard-tls"},
+				Type:       corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       tt.cert,
+					corev1.TLSPrivateKeyKey: tt.key,
+				},
+			}
+			cond, notAfter := secretCertificateCondition(secret, tt.host, now)
+			if cond.Status != string(tt.wantStatus) || cond.Reason != tt.wantReason {
+				t.Errorf("secretCertificateCondition() = %s/%s (%s), want %s/%s",
+					cond.Status, cond.Reason, cond.Message, tt.wantStatus, tt.wantReason)
+			}
+			if tt.wantStatus == metav1.ConditionTrue && notAfter == nil {
+				t.Errorf("secretCertificateCondition() did not report when the certificate expires")
+			}
+		})
+	}
+}
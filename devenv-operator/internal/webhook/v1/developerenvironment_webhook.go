@@ -0,0 +1,493 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+	"github.com/adityajoshi12/devenv-operator/internal/catalog"
+)
+
+var developerenvironmentlog = logf.Log.WithName("developerenvironment-resource")
+
+// defaultIDEType is the IDE of environments that do not pick one
+const defaultIDEType = apiv1.IDETypeVSCode
+
+// maxCommonNameLength is the longest certificate common name, which the IDE host name is used as
+const maxCommonNameLength = 64
+
+// languageVersions are the versions the install script can provision for each language.
+// Other versions must be provided by a LanguageRuntime.
+var languageVersions = map[string]*regexp.Regexp{
+	"nodejs": regexp.MustCompile(`^(\d+(\.\d+){0,2}|lts/[a-z*]+|node)$`),
+	"go":     regexp.MustCompile(`^1\.\d+(\.\d+)?$`),
+	"python": regexp.MustCompile(`^3(\.\d+)?$`),
+	"java":   regexp.MustCompile(`^\d+$`),
+	"rust":   regexp.MustCompile(`^(stable|beta|nightly|1\.\d+(\.\d+)?)$`),
+}
+
+// serviceTypes are the supported backing service types, and seedTypes those of them that can be seeded
+var serviceTypes, seedTypes = func() ([]string, []string) {
+	all := slices.Sorted(maps.Keys(catalog.ServiceTypes))
+	var seedable []string
+	for _, name := range all {
+		if catalog.ServiceTypes[name].Seedable {
+			seedable = append(seedable, name)
+		}
+	}
+	return all, seedable
+}()
+
+// serviceVersion matches the image tags of the backing service images
+var serviceVersion = regexp.MustCompile(`^(latest|alpine|management|\d+(\.\d+){0,2}(-[a-z0-9][a-z0-9.-]*)?)$`)
+
+// extensionID matches a VS Code extension identifier, publisher.name, optionally pinned to a version
+var extensionID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*\.[A-Za-z0-9][A-Za-z0-9-]*(@[0-9A-Za-z][0-9A-Za-z.+-]*)?$`)
+
+// ideSupport describes what an IDE type accepts, on top of its catalog entry
+type ideSupport struct {
+	catalog.IDE
+	// extension matches the extensions of the IDE, described by extensionForm; nil if it has none
+	extension     *regexp.Regexp
+	extensionForm string
+	// settings matches the setting names of the IDE; nil if it has none
+	settings     *regexp.Regexp
+	settingsForm string
+}
+
+var ideTypes = map[apiv1.IDEType]ideSupport{
+	apiv1.IDETypeVSCode: {
+		IDE:           catalog.IDETypes[apiv1.IDETypeVSCode],
+		extension:     extensionID,
+		extensionForm: "an extension identifier of the form publisher.name or publisher.name@version",
+		settings:      regexp.MustCompile(`.`),
+	},
+	apiv1.IDETypeJupyterLab: {
+		IDE:           catalog.IDETypes[apiv1.IDETypeJupyterLab],
+		extension:     regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?(@[0-9A-Za-z][0-9A-Za-z.+!-]*)?$`),
+		extensionForm: "the name of a pip package, optionally followed by @version",
+		settings:      regexp.MustCompile(`^[^.]+.*\.[^.]+$`),
+		settingsForm:  "of the form <plugin>.<setting>",
+	},
+}
+
+// SetupDeveloperEnvironmentWebhookWithManager registers the webhooks for DeveloperEnvironment in the manager.
+func SetupDeveloperEnvironmentWebhookWithManager(mgr ctrl.Manager, resourceURL string) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&apiv1.DeveloperEnvironment{}).
+		WithValidator(&DeveloperEnvironmentCustomValidator{Client: mgr.GetClient(), ResourceURL: resourceURL}).
+		WithDefaulter(&DeveloperEnvironmentCustomDefaulter{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-api-adityajoshi-online-v1-developerenvironment,mutating=true,failurePolicy=fail,sideEffects=None,groups=api.adityajoshi.online,resources=developerenvironments,verbs=create;update,versions=v1,name=mdeveloperenvironment.kb.io,admissionReviewVersions=v1
+
+// DeveloperEnvironmentCustomDefaulter sets default values on DeveloperEnvironments
+type DeveloperEnvironmentCustomDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &DeveloperEnvironmentCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter
+func (d *DeveloperEnvironmentCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	devEnv, ok := obj.(*apiv1.DeveloperEnvironment)
+	if !ok {
+		return fmt.Errorf("expected a DeveloperEnvironment object but got %T", obj)
+	}
+	developerenvironmentlog.V(1).Info("Defaulting", "name", devEnv.GetName())
+
+	if devEnv.Spec.IDE.Type == "" {
+		devEnv.Spec.IDE.Type = defaultIDEType
+	}
+	if devEnv.Spec.Database != nil && devEnv.Spec.Database.Version == "" {
+		devEnv.Spec.Database.Version = "latest"
+	}
+	for i := range devEnv.Spec.Services {
+		if devEnv.Spec.Services[i].Version == "" {
+			devEnv.Spec.Services[i].Version = "latest"
+		}
+	}
+	// The environment belongs to whoever creates it
+	if req, err := admission.RequestFromContext(ctx); err == nil &&
+		req.Operation == admissionv1.Create && devEnv.Spec.Owner == "" {
+		devEnv.Spec.Owner = req.UserInfo.Username
+	}
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-api-adityajoshi-online-v1-developerenvironment,mutating=false,failurePolicy=fail,sideEffects=None,groups=api.adityajoshi.online,resources=developerenvironments,verbs=create;update,versions=v1,name=vdeveloperenvironment.kb.io,admissionReviewVersions=v1
+
+// DeveloperEnvironmentCustomValidator rejects DeveloperEnvironments the operator cannot provision
+type DeveloperEnvironmentCustomValidator struct {
+	// Client looks up the LanguageRuntime catalog
+	Client client.Reader
+	// ResourceURL is the domain the IDE host names are built on
+	ResourceURL string
+}
+
+var _ webhook.CustomValidator = &DeveloperEnvironmentCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator
+func (v *DeveloperEnvironmentCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	devEnv, ok := obj.(*apiv1.DeveloperEnvironment)
+	if !ok {
+		return nil, fmt.Errorf("expected a DeveloperEnvironment object but got %T", obj)
+	}
+	developerenvironmentlog.V(1).Info("Validating creation", "name", devEnv.GetName())
+
+	allErrs, err := v.validate(ctx, devEnv)
+	if err != nil {
+		return nil, err
+	}
+	allErrs = append(allErrs, validatePassword(nil, devEnv)...)
+	allErrs = append(allErrs, validateOwner(ctx, devEnv)...)
+	return nil, invalid(devEnv, allErrs)
+}
+
+// ValidateUpdate implements webhook.CustomValidator
+func (v *DeveloperEnvironmentCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	devEnv, ok := newObj.(*apiv1.DeveloperEnvironment)
+	if !ok {
+		return nil, fmt.Errorf("expected a DeveloperEnvironment object for the newObj but got %T", newObj)
+	}
+	old, ok := oldObj.(*apiv1.DeveloperEnvironment)
+	if !ok {
+		return nil, fmt.Errorf("expected a DeveloperEnvironment object for the oldObj but got %T", oldObj)
+	}
+	developerenvironmentlog.V(1).Info("Validating update", "name", devEnv.GetName())
+
+	// Let environments that predate a rule be deleted
+	if devEnv.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	allErrs, err := v.validate(ctx, devEnv)
+	if err != nil {
+		return nil, err
+	}
+	allErrs = append(allErrs, validateImmutable(old, devEnv)...)
+	allErrs = append(allErrs, validatePassword(old, devEnv)...)
+	if old.Spec.Owner == "" {
+		allErrs = append(allErrs, validateOwner(ctx, devEnv)...)
+	}
+	return nil, invalid(devEnv, allErrs)
+}
+
+// ValidateDelete implements webhook.CustomValidator
+func (v *DeveloperEnvironmentCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func invalid(devEnv *apiv1.DeveloperEnvironment, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(apiv1.GroupVersion.WithKind("DeveloperEnvironment").GroupKind(), devEnv.Name, allErrs)
+}
+
+// validate checks the spec of an environment
+func (v *DeveloperEnvironmentCustomValidator) validate(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) (field.ErrorList, error) {
+	var allErrs field.ErrorList
+
+	// The host name is the common name of the IDE certificate
+	host := fmt.Sprintf("%s.%s", devEnv.Name, v.ResourceURL)
+	for _, msg := range validation.IsDNS1123Subdomain(host) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "name"), devEnv.Name,
+			fmt.Sprintf("host name %s: %s", host, msg)))
+	}
+	if len(host) > maxCommonNameLength {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "name"), devEnv.Name,
+			fmt.Sprintf("host name %s must be no more than %d characters", host, maxCommonNameLength)))
+	}
+
+	languageErrs, err := v.validateLanguages(ctx, devEnv)
+	if err != nil {
+		return nil, err
+	}
+	allErrs = append(allErrs, languageErrs...)
+	for i, service := range devEnv.Spec.EffectiveServices() {
+		allErrs = append(allErrs, validateService(devEnv, service, servicePath(devEnv, i))...)
+	}
+
+	allErrs = append(allErrs, validateIDE(devEnv)...)
+	return allErrs, nil
+}
+
+// validateIDE checks that the IDE type supports what the environment asks of it
+func validateIDE(devEnv *apiv1.DeveloperEnvironment) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := field.NewPath("spec")
+	ide := spec.Child("ide")
+
+	ideType, ok := ideTypes[effectiveIDEType(devEnv)]
+	if !ok {
+		// The schema rejects unknown types
+		return nil
+	}
+	for i, extension := range devEnv.Spec.IDE.Extensions {
+		if ideType.extension == nil {
+			allErrs = append(allErrs, field.Forbidden(ide.Child("extensions"),
+				fmt.Sprintf("the %s IDE has no extensions", effectiveIDEType(devEnv))))
+			break
+		}
+		if !ideType.extension.MatchString(extension) {
+			allErrs = append(allErrs, field.Invalid(ide.Child("extensions").Index(i), extension,
+				fmt.Sprintf("must be %s", ideType.extensionForm)))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(devEnv.Spec.IDE.Settings)) {
+		if ideType.settings == nil {
+			allErrs = append(allErrs, field.Forbidden(ide.Child("settings"),
+				fmt.Sprintf("the %s IDE has no settings", effectiveIDEType(devEnv))))
+			break
+		}
+		if !ideType.settings.MatchString(name) {
+			allErrs = append(allErrs, field.Invalid(ide.Child("settings").Key(name), name,
+				fmt.Sprintf("must be %s", ideType.settingsForm)))
+		}
+	}
+	if !ideType.VSIX && devEnv.Spec.IDE.VSIX != nil {
+		allErrs = append(allErrs, field.Forbidden(ide.Child("vsix"),
+			fmt.Sprintf("the %s IDE cannot install .vsix files", effectiveIDEType(devEnv))))
+	}
+	if !ideType.VSIX && devEnv.Spec.IDE.ExtensionRegistry != "" {
+		allErrs = append(allErrs, field.Forbidden(ide.Child("extensionRegistry"),
+			fmt.Sprintf("the %s IDE cannot use an Open VSX registry", effectiveIDEType(devEnv))))
+	}
+	if !ideType.Scripts && len(devEnv.Spec.Dependencies) > 0 {
+		allErrs = append(allErrs, field.Forbidden(spec.Child("dependencies"),
+			fmt.Sprintf("cannot be installed by the %s IDE", effectiveIDEType(devEnv))))
+	}
+	if ideType.Activity == nil && devEnv.Spec.IdleTimeout != nil {
+		allErrs = append(allErrs, field.Forbidden(spec.Child("idleTimeout"),
+			fmt.Sprintf("the %s IDE does not report activity", effectiveIDEType(devEnv))))
+	}
+	return allErrs
+}
+
+// effectiveIDEType is the IDE type of an environment, which may not be defaulted yet
+func effectiveIDEType(devEnv *apiv1.DeveloperEnvironment) apiv1.IDEType {
+	if devEnv.Spec.IDE.Type == "" {
+		return defaultIDEType
+	}
+	return devEnv.Spec.IDE.Type
+}
+
+// validateLanguages checks that every language version can be provisioned,
+// either by the install script or by an image of the LanguageRuntime catalog
+func (v *DeveloperEnvironmentCustomValidator) validateLanguages(
+	ctx context.Context,
+	devEnv *apiv1.DeveloperEnvironment,
+) (field.ErrorList, error) {
+	var allErrs field.ErrorList
+	var runtimes *apiv1.LanguageRuntimeList
+	ide := effectiveIDEType(devEnv)
+	ideType := ideTypes[ide]
+
+	for i, language := range devEnv.Spec.EffectiveLanguages() {
+		path := field.NewPath("spec", "languages").Index(i).Child("version")
+		if len(devEnv.Spec.Languages) == 0 {
+			path = field.NewPath("spec", "version")
+		}
+		if slices.Contains(ideType.Languages, language.Name) {
+			continue
+		}
+		pattern, ok := languageVersions[language.Name]
+		if ideType.Scripts && (language.Version == "" || !ok || pattern.MatchString(language.Version)) {
+			continue
+		}
+
+		if runtimes == nil {
+			runtimes = &apiv1.LanguageRuntimeList{}
+			if err := v.Client.List(ctx, runtimes); err != nil {
+				return nil, fmt.Errorf("failed to list language runtimes: %w", err)
+			}
+		}
+		switch {
+		case catalogProvides(runtimes.Items, ide, language):
+		case ideType.Scripts:
+			allErrs = append(allErrs, field.Invalid(path, language.Version,
+				fmt.Sprintf("is not a %s version the install script can provision, and no LanguageRuntime provides it", language.Name)))
+		default:
+			allErrs = append(allErrs, field.Invalid(path, language.Version,
+				fmt.Sprintf("no LanguageRuntime provides this %s version for the %s IDE, which cannot install it", language.Name, ide)))
+		}
+	}
+	return allErrs, nil
+}
+
+// catalogProvides returns whether a LanguageRuntime has an image of the language version for the IDE
+func catalogProvides(runtimes []apiv1.LanguageRuntime, ide apiv1.IDEType, language apiv1.LanguageSpec) bool {
+	for _, runtime := range runtimes {
+		runtimeIDE := runtime.Spec.IDE
+		if runtimeIDE == "" {
+			runtimeIDE = defaultIDEType
+		}
+		if runtime.Spec.Language != language.Name || runtimeIDE != ide {
+			continue
+		}
+		for _, image := range runtime.Spec.Images {
+			if image.Version == language.Version {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// servicePath is the path of a backing service, which is spec.database for the shorthand
+func servicePath(devEnv *apiv1.DeveloperEnvironment, index int) *field.Path {
+	if len(devEnv.Spec.Services) == 0 {
+		return field.NewPath("spec", "database")
+	}
+	return field.NewPath("spec", "services").Index(index)
+}
+
+func validateService(devEnv *apiv1.DeveloperEnvironment, service apiv1.ServiceSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	// The service is reached under <environment>-<service>
+	object := fmt.Sprintf("%s-%s", devEnv.Name, service.Name)
+	for _, msg := range validation.IsDNS1035Label(object) {
+		allErrs = append(allErrs, field.Invalid(path.Child("name"), service.Name,
+			fmt.Sprintf("service name %s: %s", object, msg)))
+	}
+	// Snapshots name the volume of the IDE workspace
+	if service.Name == "workspace" {
+		allErrs = append(allErrs, field.Forbidden(path.Child("name"), "workspace is reserved for the IDE workspace"))
+	}
+	if !slices.Contains(serviceTypes, service.Type) {
+		allErrs = append(allErrs, field.NotSupported(path.Child("type"), service.Type, serviceTypes))
+	}
+	if service.Seed != nil && !slices.Contains(seedTypes, service.Type) {
+		allErrs = append(allErrs, field.Forbidden(path.Child("seed"),
+			fmt.Sprintf("is only supported by %s", strings.Join(seedTypes, ", "))))
+	}
+	if service.Version != "" && !serviceVersion.MatchString(service.Version) {
+		allErrs = append(allErrs, field.Invalid(path.Child("version"), service.Version,
+			"must be latest or an image tag such as 16, 16.4 or 16-alpine"))
+	}
+	return allErrs
+}
+
+// validatePassword rejects new plain text passwords. Environments created
+// with one keep it until the operator moves it into a Secret.
+func validatePassword(old, devEnv *apiv1.DeveloperEnvironment) field.ErrorList {
+	var allErrs field.ErrorList
+	ide := field.NewPath("spec", "ide")
+	password := devEnv.Spec.IDE.PasswordSecret
+	if password == "" {
+		return nil
+	}
+	if devEnv.Spec.IDE.PasswordSecretRef != nil {
+		allErrs = append(allErrs, field.Forbidden(ide.Child("passwordSecret"), "cannot be set together with passwordSecretRef"))
+	} else if old == nil || old.Spec.IDE.PasswordSecret != password {
+		allErrs = append(allErrs, field.Forbidden(ide.Child("passwordSecret"),
+			"holds the password in plain text; reference a Secret with passwordSecretRef instead"))
+	}
+	return allErrs
+}
+
+// validateOwner rejects environments owned by someone else than the user
+// creating them, who would otherwise adopt the volumes that user retained
+func validateOwner(ctx context.Context, devEnv *apiv1.DeveloperEnvironment) field.ErrorList {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil || devEnv.Spec.Owner == "" || devEnv.Spec.Owner == req.UserInfo.Username {
+		return nil
+	}
+	return field.ErrorList{field.Forbidden(field.NewPath("spec", "owner"),
+		fmt.Sprintf("must be the user creating the environment, %s", req.UserInfo.Username))}
+}
+
+// validateImmutable rejects changes the operator cannot carry out on existing volumes
+func validateImmutable(old, devEnv *apiv1.DeveloperEnvironment) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := field.NewPath("spec")
+
+	// Retained volumes are adopted by the owner, which cannot be handed over
+	if old.Spec.Owner != "" && devEnv.Spec.Owner != old.Spec.Owner {
+		allErrs = append(allErrs, field.Forbidden(spec.Child("owner"), fmt.Sprintf("cannot be changed from %s", old.Spec.Owner)))
+	}
+
+	// The volumes are only restored when they are created
+	if devEnv.Spec.RestoreFrom != old.Spec.RestoreFrom {
+		allErrs = append(allErrs, field.Forbidden(spec.Child("restoreFrom"), "can only be set when the environment is created"))
+	}
+
+	type volume struct {
+		path     *field.Path
+		old, new *apiv1.StorageSpec
+	}
+	volumes := []volume{
+		{spec.Child("workspace", "storage"), workspaceStorage(old), workspaceStorage(devEnv)},
+	}
+
+	// The volume of a service holds the data files of the type it was created for.
+	// Removing a service deletes its volume, so it may then be added back with another type.
+	oldServices := map[string]apiv1.ServiceSpec{}
+	for _, service := range old.Spec.EffectiveServices() {
+		oldServices[service.Name] = service
+	}
+	for i, service := range devEnv.Spec.EffectiveServices() {
+		previous, ok := oldServices[service.Name]
+		if !ok {
+			continue
+		}
+		path := servicePath(devEnv, i)
+		if service.Type != previous.Type {
+			allErrs = append(allErrs, field.Forbidden(path.Child("type"),
+				fmt.Sprintf("cannot be changed from %s", previous.Type)))
+		}
+		volumes = append(volumes, volume{path.Child("storage"), previous.Storage, service.Storage})
+	}
+
+	for _, volume := range volumes {
+		if volume.old == nil || volume.new == nil {
+			continue
+		}
+		if volume.old.StorageClassName != nil && volume.new.StorageClassName != nil &&
+			*volume.old.StorageClassName != *volume.new.StorageClassName {
+			allErrs = append(allErrs, field.Forbidden(volume.path.Child("storageClassName"),
+				fmt.Sprintf("cannot be changed from %s", *volume.old.StorageClassName)))
+		}
+		if volume.old.Size != nil && volume.new.Size != nil && volume.new.Size.Cmp(*volume.old.Size) < 0 {
+			allErrs = append(allErrs, field.Forbidden(volume.path.Child("size"),
+				fmt.Sprintf("cannot be shrunk from %s", volume.old.Size.String())))
+		}
+	}
+	return allErrs
+}
+
+func workspaceStorage(devEnv *apiv1.DeveloperEnvironment) *apiv1.StorageSpec {
+	if devEnv.Spec.Workspace == nil {
+		return nil
+	}
+	return devEnv.Spec.Workspace.Storage
+}
@@ -0,0 +1,426 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	apiv1 "github.com/adityajoshi12/devenv-operator/api/v1"
+)
+
+func validEnvironment() *apiv1.DeveloperEnvironment {
+	return &apiv1.DeveloperEnvironment{
+		ObjectMeta: metav1.ObjectMeta{Name: "golang-env", Namespace: "default"},
+		Spec: apiv1.DeveloperEnvironmentSpec{
+			Language: "go",
+			Version:  "1.22.0",
+			IDE:      apiv1.IDEConfig{Type: "vscode", Extensions: []string{"golang.Go"}},
+			Database: &apiv1.DatabaseSpec{Type: "postgres", Version: "16"},
+		},
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	catalog := &apiv1.LanguageRuntime{
+		ObjectMeta: metav1.ObjectMeta{Name: "go"},
+		Spec: apiv1.LanguageRuntimeSpec{
+			Language: "go",
+			Images:   []apiv1.RuntimeImage{{Version: "1.22-bookworm", Image: "example/go:1.22-bookworm"}},
+		},
+	}
+	validator := &DeveloperEnvironmentCustomValidator{
+		Client:      fake.NewClientBuilder().WithScheme(scheme).WithObjects(catalog).Build(),
+		ResourceURL: "dev.example.com",
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(*apiv1.DeveloperEnvironment)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			mutate: func(*apiv1.DeveloperEnvironment) {},
+		},
+		{
+			name:   "version provided by the catalog",
+			mutate: func(d *apiv1.DeveloperEnvironment) { d.Spec.Version = "1.22-bookworm" },
+		},
+		{
+			name:    "version nothing can provision",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.Version = "2.0-preview" },
+			wantErr: "spec.version",
+		},
+		{
+			name: "polyglot version nothing can provision",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Languages = []apiv1.LanguageSpec{{Name: "go"}, {Name: "python", Version: "2.7"}}
+			},
+			wantErr: "spec.languages[1].version",
+		},
+		{
+			name:   "no database",
+			mutate: func(d *apiv1.DeveloperEnvironment) { d.Spec.Database = nil },
+		},
+		{
+			name:    "missing database type",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.Database = &apiv1.DatabaseSpec{} },
+			wantErr: "spec.database.type",
+		},
+		{
+			name:    "invalid database version",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.Database.Version = "16; rm -rf /" },
+			wantErr: "spec.database.version",
+		},
+		{
+			name: "named services",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Services = []apiv1.ServiceSpec{
+					{Name: "db", DatabaseSpec: apiv1.DatabaseSpec{Type: "mysql", Version: "8.4"}},
+					{Name: "queue", DatabaseSpec: apiv1.DatabaseSpec{Type: "rabbitmq", Version: "3-management"}},
+					{Name: "events", DatabaseSpec: apiv1.DatabaseSpec{Type: "kafka", Version: "3.8.0"}},
+				}
+			},
+		},
+		{
+			name: "unsupported service type",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Services = []apiv1.ServiceSpec{
+					{Name: "db", DatabaseSpec: apiv1.DatabaseSpec{Type: "postgres"}},
+					{Name: "search", DatabaseSpec: apiv1.DatabaseSpec{Type: "elasticsearch"}},
+				}
+			},
+			wantErr: "spec.services[1].type",
+		},
+		{
+			name: "service named after the workspace",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Services = []apiv1.ServiceSpec{
+					{Name: "workspace", DatabaseSpec: apiv1.DatabaseSpec{Type: "redis"}},
+				}
+			},
+			wantErr: "spec.services[0].name",
+		},
+		{
+			name: "seed from a ConfigMap",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Database.Seed = &apiv1.SeedSpec{ConfigMap: "fixtures"}
+			},
+		},
+		{
+			name: "seed a service that cannot be seeded",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Database = &apiv1.DatabaseSpec{Type: "redis", Seed: &apiv1.SeedSpec{ConfigMap: "fixtures"}}
+			},
+			wantErr: "spec.database.seed",
+		},
+		{
+			name:    "invalid extension ID",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.IDE.Extensions = []string{"gopls"} },
+			wantErr: "spec.ide.extensions[0]",
+		},
+		{
+			name: "pinned extension from a VSIX ConfigMap",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.IDE.Extensions = []string{"golang.Go@0.42.1"}
+				d.Spec.IDE.VSIX = &apiv1.VSIXSource{ConfigMap: "extensions"}
+			},
+		},
+		{
+			name: "VSIX files for JupyterLab",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.IDE = apiv1.IDEConfig{Type: apiv1.IDETypeJupyterLab, VSIX: &apiv1.VSIXSource{ConfigMap: "extensions"}}
+				d.Spec.Language, d.Spec.Version = "python", "3.12"
+			},
+			wantErr: "spec.ide.vsix",
+		},
+		{
+			name: "password from a Secret",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.IDE.PasswordSecretRef = &apiv1.SecretKeyReference{Name: "ide-password", Key: "password"}
+			},
+		},
+		{
+			name:    "plain text password",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.IDE.PasswordSecret = "hunter2" },
+			wantErr: "spec.ide.passwordSecret",
+		},
+		{
+			name: "JupyterLab with Python",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.IDE = apiv1.IDEConfig{
+					Type:       apiv1.IDETypeJupyterLab,
+					Extensions: []string{"jupyterlab-git"},
+					Settings:   map[string]string{"@jupyterlab/apputils-extension:themes.theme": "JupyterLab Dark"},
+				}
+				d.Spec.Language, d.Spec.Version = "python", "3.12"
+			},
+		},
+		{
+			name:    "JupyterLab with a language no image provides",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.IDE = apiv1.IDEConfig{Type: apiv1.IDETypeJupyterLab} },
+			wantErr: "spec.version",
+		},
+		{
+			name: "JupyterLab setting without a plugin",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.IDE = apiv1.IDEConfig{Type: apiv1.IDETypeJupyterLab, Settings: map[string]string{"theme": "dark"}}
+				d.Spec.Language, d.Spec.Version = "python", "3.12"
+			},
+			wantErr: "spec.ide.settings[theme]",
+		},
+		{
+			name: "idle timeout of an IDE that does not report activity",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.IDE = apiv1.IDEConfig{Type: apiv1.IDETypeJupyterLab}
+				d.Spec.Language, d.Spec.Version = "python", "3.12"
+				d.Spec.IdleTimeout = &metav1.Duration{Duration: time.Hour}
+			},
+			wantErr: "spec.idleTimeout",
+		},
+		{
+			name:    "host name too long for the certificate",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Name = strings.Repeat("a", 50) },
+			wantErr: "metadata.name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := validEnvironment()
+			tt.mutate(devEnv)
+			_, err := validator.ValidateCreate(context.Background(), devEnv)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateCreate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateCreate() error = %v, want an error on %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	create := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "jane@example.com"},
+	}}
+	update := create
+	update.Operation = admissionv1.Update
+
+	tests := []struct {
+		name      string
+		req       admission.Request
+		owner     string
+		wantOwner string
+	}{
+		{name: "owned by its creator", req: create, wantOwner: "jane@example.com"},
+		{name: "explicit owner", req: create, owner: "john@example.com", wantOwner: "john@example.com"},
+		{name: "not taken over by an update", req: update},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := validEnvironment()
+			devEnv.Spec.Owner = tt.owner
+			ctx := admission.NewContextWithRequest(context.Background(), tt.req)
+			if err := (&DeveloperEnvironmentCustomDefaulter{}).Default(ctx, devEnv); err != nil {
+				t.Fatal(err)
+			}
+			if devEnv.Spec.Owner != tt.wantOwner {
+				t.Errorf("owner = %q, want %q", devEnv.Spec.Owner, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestValidateOwner(t *testing.T) {
+	create := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "jane@example.com"},
+	}}
+	scheme := runtime.NewScheme()
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	validator := &DeveloperEnvironmentCustomValidator{
+		Client:      fake.NewClientBuilder().WithScheme(scheme).Build(),
+		ResourceURL: "dev.example.com",
+	}
+
+	tests := []struct {
+		name    string
+		owner   string
+		wantErr bool
+	}{
+		{name: "owned by its creator", owner: "jane@example.com"},
+		{name: "owned by someone else", owner: "john@example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := validEnvironment()
+			devEnv.Spec.Owner = tt.owner
+			ctx := admission.NewContextWithRequest(context.Background(), create)
+			if _, err := validator.ValidateCreate(ctx, devEnv); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// Environments created before they had an owner can only be claimed by the requester
+			old := validEnvironment()
+			if _, err := validator.ValidateUpdate(ctx, old, devEnv); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateImmutable(t *testing.T) {
+	old := validEnvironment()
+	old.Spec.Owner = "jane@example.com"
+	old.Spec.Workspace = &apiv1.WorkspaceSpec{Storage: &apiv1.StorageSpec{
+		Size:             resource.NewQuantity(10<<30, resource.BinarySI),
+		StorageClassName: &[]string{"standard"}[0],
+	}}
+
+	tests := []struct {
+		name    string
+		mutate  func(*apiv1.DeveloperEnvironment)
+		wantErr bool
+	}{
+		{
+			name: "grow workspace",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Workspace.Storage.Size = resource.NewQuantity(20<<30, resource.BinarySI)
+			},
+		},
+		{
+			name: "shrink workspace",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Workspace.Storage.Size = resource.NewQuantity(5<<30, resource.BinarySI)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "change storage class",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.Workspace.Storage.StorageClassName = &[]string{"fast"}[0] },
+			wantErr: true,
+		},
+		{
+			name:    "change database type",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.Database.Type = "redis" },
+			wantErr: true,
+		},
+		{
+			name: "move the database into services",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Services = []apiv1.ServiceSpec{
+					{Name: apiv1.DefaultServiceName, DatabaseSpec: *d.Spec.Database},
+					{Name: "cache", DatabaseSpec: apiv1.DatabaseSpec{Type: "redis"}},
+				}
+				d.Spec.Database = nil
+			},
+		},
+		{
+			name: "change the type of the database service",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.Services = []apiv1.ServiceSpec{
+					{Name: apiv1.DefaultServiceName, DatabaseSpec: apiv1.DatabaseSpec{Type: "mysql"}},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "hand the environment over",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.Owner = "mallory" },
+			wantErr: true,
+		},
+		{
+			name:    "restore an existing environment",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.RestoreFrom = "golang-env-monday" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := old.DeepCopy()
+			tt.mutate(devEnv)
+			if errs := validateImmutable(old, devEnv); (len(errs) > 0) != tt.wantErr {
+				t.Errorf("validateImmutable() = %v, wantErr %v", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	old := validEnvironment()
+	old.Spec.IDE.PasswordSecret = "hunter2"
+
+	tests := []struct {
+		name    string
+		mutate  func(*apiv1.DeveloperEnvironment)
+		wantErr bool
+	}{
+		{
+			name:   "keep the plain text password",
+			mutate: func(*apiv1.DeveloperEnvironment) {},
+		},
+		{
+			name:   "clear the plain text password",
+			mutate: func(d *apiv1.DeveloperEnvironment) { d.Spec.IDE.PasswordSecret = "" },
+		},
+		{
+			name:    "change the plain text password",
+			mutate:  func(d *apiv1.DeveloperEnvironment) { d.Spec.IDE.PasswordSecret = "hunter3" },
+			wantErr: true,
+		},
+		{
+			name: "reference a Secret besides the plain text password",
+			mutate: func(d *apiv1.DeveloperEnvironment) {
+				d.Spec.IDE.PasswordSecretRef = &apiv1.SecretKeyReference{Name: "ide-password"}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devEnv := old.DeepCopy()
+			tt.mutate(devEnv)
+			if errs := validatePassword(old, devEnv); (len(errs) > 0) != tt.wantErr {
+				t.Errorf("validatePassword() = %v, wantErr %v", errs, tt.wantErr)
+			}
+		})
+	}
+}